@@ -23,6 +23,11 @@ integration {
     name = "Docker Save"
     slug = "docker-save"
   }
+  component {
+    type = "post-processor"
+    name = "Docker Squash"
+    slug = "docker-squash"
+  }
   component {
     type = "post-processor"
     name = "Docker Tag"
@@ -0,0 +1,385 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveOwner is the owner set in the headers of the archives uploaded to
+// a container.
+type archiveOwner struct {
+	UID int
+	GID int
+}
+
+// apply sets the owner in the header. This is a no-op on a nil owner, in
+// which case the header is left as-is.
+func (o *archiveOwner) apply(header *tar.Header) {
+	if o == nil {
+		return
+	}
+
+	header.Uid = o.UID
+	header.Gid = o.GID
+	// docker only considers the IDs, but the names from the host would be
+	// misleading if the archive is ever inspected.
+	header.Uname = ""
+	header.Gname = ""
+}
+
+// writeDirArchive writes the directory tree rooted at src to archive, with
+// every entry placed under prefix. If includeRoot is false, src itself does
+// not get an entry in the archive, only its contents do.
+//
+// Entries matching one of the exclude patterns are skipped, see isExcluded.
+// If fileMode is not 0, it replaces the mode of the regular files.
+func writeDirArchive(archive *tar.Writer, src string, prefix string, includeRoot bool, exclude []string, owner *archiveOwner, fileMode int64) error {
+	return filepath.Walk(src, func(hostPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, hostPath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." && !includeRoot {
+			return nil
+		}
+		if relPath != "." && isExcluded(filepath.ToSlash(relPath), exclude) {
+			log.Printf("Excluding %s from upload", hostPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(hostPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(relPath))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		owner.apply(header)
+		if fileMode != 0 && info.Mode().IsRegular() {
+			header.Mode = fileMode
+		}
+
+		if err := archive.WriteHeader(header); err != nil {
+			return fmt.Errorf("Failed to write header for %s: %s", hostPath, err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(hostPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(archive, f); err != nil {
+			return fmt.Errorf("Failed to archive %s: %s", hostPath, err)
+		}
+		return nil
+	})
+}
+
+// extractArchive safely extracts the archive in the dst directory of the
+// host, creating it if needed.
+//
+// Entries cannot be written outside of dst, either through their name or
+// through symlinks extracted before them, and their permissions and
+// modification times are preserved. The first element of the name of each
+// entry is the directory being copied, the exclude patterns are matched
+// against the rest of the name, see isExcluded.
+func extractArchive(archive *tar.Reader, dst string, exclude []string) error {
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	type dirAttrs struct {
+		path    string
+		mode    os.FileMode
+		modTime time.Time
+	}
+	// Directory attributes are only applied once everything is extracted,
+	// otherwise read-only directories could not be filled.
+	var dirs []dirAttrs
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to read archive: %s", err)
+		}
+
+		name := path.Clean("/" + filepath.ToSlash(header.Name))[1:]
+		if name == "" {
+			continue
+		}
+		if _, relPath, ok := strings.Cut(name, "/"); ok && isExcluded(relPath, exclude) {
+			log.Printf("Excluding %s from download", name)
+			continue
+		}
+
+		target := filepath.Join(dst, filepath.FromSlash(name))
+		if err := checkExtractPath(dst, target); err != nil {
+			return err
+		}
+
+		mode := header.FileInfo().Mode()
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("refusing to extract directory %q over a symlink", target)
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirAttrs{target, mode.Perm(), header.ModTime})
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := extractFile(archive, target, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			// Symlinks are created as-is, checkExtractPath ensures nothing
+			// will be extracted through them later on.
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeLink:
+			linkName := path.Clean("/" + header.Linkname)[1:]
+			source := filepath.Join(dst, filepath.FromSlash(linkName))
+			if err := checkExtractPath(dst, source); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Link(source, target); err != nil {
+				return err
+			}
+		default:
+			log.Printf("Skipping %s, unsupported file type %q", name, header.Typeflag)
+			continue
+		}
+
+		if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractFile writes the current entry of archive to target.
+func extractFile(archive io.Reader, target string, mode os.FileMode) error {
+	// Never write through an existing symlink
+	os.Remove(target)
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, archive); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The mode given at creation is subject to the umask
+	return os.Chmod(target, mode)
+}
+
+// checkExtractPath ensures target is inside root, and that none of its parent
+// directories inside root is a symlink, which could lead outside of it.
+func checkExtractPath(root string, target string) error {
+	relPath, err := filepath.Rel(root, target)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to extract %q outside of %q", target, root)
+	}
+
+	current := root
+	parts := strings.Split(relPath, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		fi, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %q through symlink %q", target, current)
+		}
+	}
+
+	return nil
+}
+
+// isExcluded returns whether the slash-separated relPath matches one of the
+// exclude glob patterns, in the syntax of path.Match.
+//
+// A pattern matches either the whole relative path, or any of its leading
+// directories. Patterns without a slash are matched against base names
+// only, so "*.log" excludes log files at any depth.
+func isExcluded(relPath string, exclude []string) bool {
+	for _, pattern := range exclude {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+
+		baseOnly := !strings.Contains(pattern, "/")
+		for current := relPath; current != "." && current != "/"; current = path.Dir(current) {
+			name := current
+			if baseOnly {
+				name = path.Base(current)
+			}
+			if matched, _ := path.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isNumericOwner returns whether the owner is only made of numeric IDs, in
+// the `uid` or `uid:gid` form.
+func isNumericOwner(owner string) bool {
+	for _, id := range strings.SplitN(owner, ":", 2) {
+		if _, err := strconv.Atoi(id); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupOwner translates an owner in the `user[:group]` form to numeric IDs,
+// using the contents of the passwd and group files of the container.
+//
+// As with `docker exec --user`, the group defaults to the primary group of
+// the user, or to the user ID if the user is not in the passwd file.
+func lookupOwner(owner string, passwd string, group string) (*archiveOwner, error) {
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+
+	uid, err := strconv.Atoi(userName)
+	gid := -1
+	if err != nil {
+		entry := findEntry(passwd, userName)
+		if len(entry) < 4 {
+			return nil, fmt.Errorf("unknown user %q", userName)
+		}
+		if uid, err = strconv.Atoi(entry[2]); err != nil {
+			return nil, fmt.Errorf("invalid uid %q for user %q", entry[2], userName)
+		}
+		if gid, err = strconv.Atoi(entry[3]); err != nil {
+			return nil, fmt.Errorf("invalid gid %q for user %q", entry[3], userName)
+		}
+	}
+
+	if hasGroup {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			entry := findEntry(group, groupName)
+			if len(entry) < 3 {
+				return nil, fmt.Errorf("unknown group %q", groupName)
+			}
+			if gid, err = strconv.Atoi(entry[2]); err != nil {
+				return nil, fmt.Errorf("invalid gid %q for group %q", entry[2], groupName)
+			}
+		}
+	}
+
+	if gid < 0 {
+		gid = uid
+		// The primary group of a user given by its ID still comes from
+		// the passwd file if the user is listed there.
+		for _, entry := range entries(passwd) {
+			if len(entry) >= 4 && entry[2] == userName {
+				if primary, err := strconv.Atoi(entry[3]); err == nil {
+					gid = primary
+				}
+				break
+			}
+		}
+	}
+
+	return &archiveOwner{UID: uid, GID: gid}, nil
+}
+
+// findEntry returns the fields of the entry named name in a passwd or group
+// file, or nil if there is none.
+func findEntry(contents string, name string) []string {
+	for _, entry := range entries(contents) {
+		if entry[0] == name {
+			return entry
+		}
+	}
+	return nil
+}
+
+// entries splits the contents of a passwd or group file into the fields of
+// each of its entries.
+func entries(contents string) [][]string {
+	var ret [][]string
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ret = append(ret, strings.Split(line, ":"))
+	}
+	return ret
+}
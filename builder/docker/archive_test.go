@@ -0,0 +1,277 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/sbin/nologin
+# comment
+nonroot:x:65532:65533:nonroot:/home/nonroot:/sbin/nologin
+`
+
+const testGroup = `root:x:0:
+nonroot:x:65533:
+staff:x:50:nonroot
+`
+
+func TestLookupOwner(t *testing.T) {
+	tests := []struct {
+		owner     string
+		expected  *archiveOwner
+		expectErr bool
+	}{
+		{"root", &archiveOwner{0, 0}, false},
+		{"nonroot", &archiveOwner{65532, 65533}, false},
+		{"nonroot:staff", &archiveOwner{65532, 50}, false},
+		{"nonroot:42", &archiveOwner{65532, 42}, false},
+		{"65532", &archiveOwner{65532, 65533}, false},
+		{"1000", &archiveOwner{1000, 1000}, false},
+		{"1000:2000", &archiveOwner{1000, 2000}, false},
+		{"unknown", nil, true},
+		{"root:unknown", nil, true},
+	}
+
+	for _, tt := range tests {
+		owner, err := lookupOwner(tt.owner, testPasswd, testGroup)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("%q: unexpected error status: %v", tt.owner, err)
+			continue
+		}
+		if !reflect.DeepEqual(owner, tt.expected) {
+			t.Errorf("%q: expected %#v, got %#v", tt.owner, tt.expected, owner)
+		}
+	}
+}
+
+func TestWriteDirArchive(t *testing.T) {
+	src := filepath.Join("test-fixtures", "manycakes")
+
+	tests := []struct {
+		name        string
+		prefix      string
+		includeRoot bool
+		exclude     []string
+		fileMode    int64
+		expected    []string
+	}{
+		{
+			"contents only",
+			"cakes",
+			false,
+			nil,
+			0,
+			[]string{"cakes/chocolate", "cakes/vanilla"},
+		},
+		{
+			"with root",
+			"tmp/manycakes",
+			true,
+			nil,
+			0,
+			[]string{"tmp/manycakes/", "tmp/manycakes/chocolate", "tmp/manycakes/vanilla"},
+		},
+		{
+			"with exclusions",
+			"tmp/manycakes",
+			true,
+			[]string{"van*"},
+			0,
+			[]string{"tmp/manycakes/", "tmp/manycakes/chocolate"},
+		},
+		{
+			"with file mode",
+			"cakes",
+			false,
+			nil,
+			0640,
+			[]string{"cakes/chocolate", "cakes/vanilla"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			archive := tar.NewWriter(buf)
+			owner := &archiveOwner{UID: 42, GID: 43}
+			if err := writeDirArchive(archive, src, tt.prefix, tt.includeRoot, tt.exclude, owner, tt.fileMode); err != nil {
+				t.Fatalf("failed to write archive: %s", err)
+			}
+			archive.Close()
+
+			var names []string
+			reader := tar.NewReader(buf)
+			for {
+				header, err := reader.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read archive: %s", err)
+				}
+				if header.Uid != 42 || header.Gid != 43 {
+					t.Errorf("%s: bad owner %d:%d", header.Name, header.Uid, header.Gid)
+				}
+				names = append(names, header.Name)
+
+				if header.Typeflag == tar.TypeReg {
+					if tt.fileMode != 0 && header.Mode != tt.fileMode {
+						t.Errorf("%s: expected mode %o, got %o", header.Name, tt.fileMode, header.Mode)
+					}
+					contents, _ := io.ReadAll(reader)
+					expected, _ := os.ReadFile(filepath.Join(src, filepath.Base(header.Name)))
+					if !bytes.Equal(contents, expected) {
+						t.Errorf("%s: contents differ", header.Name)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("expected entries %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	tests := []struct {
+		relPath  string
+		exclude  []string
+		expected bool
+	}{
+		{"app/debug.log", []string{"*.log"}, true},
+		{"app/debug.log", []string{"app/*.log"}, true},
+		{"app/debug.log", []string{"other/*.log"}, false},
+		{"node_modules/pkg/index.js", []string{"node_modules"}, true},
+		{"src/node_modules/pkg/index.js", []string{"node_modules"}, true},
+		{"src/node_modules/pkg/index.js", []string{"/src/node_modules/"}, true},
+		{"src/main.go", []string{"node_modules", "*.log"}, false},
+		{"src/main.go", nil, false},
+	}
+
+	for _, tt := range tests {
+		if excluded := isExcluded(tt.relPath, tt.exclude); excluded != tt.expected {
+			t.Errorf("%q with %v: expected %t, got %t", tt.relPath, tt.exclude, tt.expected, excluded)
+		}
+	}
+}
+
+func testArchive(t *testing.T, headers []*tar.Header) *tar.Reader {
+	buf := &bytes.Buffer{}
+	archive := tar.NewWriter(buf)
+	for _, header := range headers {
+		contents := []byte(header.Name)
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(contents))
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatalf("failed to write header: %s", err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			archive.Write(contents)
+		}
+	}
+	archive.Close()
+	return tar.NewReader(buf)
+}
+
+func TestExtractArchive(t *testing.T) {
+	dst := t.TempDir()
+
+	archive := testArchive(t, []*tar.Header{
+		{Name: "app/", Typeflag: tar.TypeDir, Mode: 0750},
+		{Name: "app/bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "app/bin/run", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "app/config", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "app/debug.log", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "app/current", Typeflag: tar.TypeSymlink, Linkname: "bin/run"},
+	})
+
+	if err := extractArchive(archive, dst, []string{"*.log"}); err != nil {
+		t.Fatalf("failed to extract archive: %s", err)
+	}
+
+	expectedModes := map[string]os.FileMode{
+		"app":         os.ModeDir | 0750,
+		"app/bin":     os.ModeDir | 0755,
+		"app/bin/run": 0755,
+		"app/config":  0600,
+	}
+	for name, mode := range expectedModes {
+		fi, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if fi.Mode() != mode {
+			t.Errorf("%s: expected mode %s, got %s", name, mode, fi.Mode())
+		}
+	}
+
+	contents, err := os.ReadFile(filepath.Join(dst, "app/current"))
+	if err != nil || string(contents) != "app/bin/run" {
+		t.Errorf("bad symlink target contents: %q, %v", contents, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "app/debug.log")); !os.IsNotExist(err) {
+		t.Errorf("excluded file should not have been extracted: %v", err)
+	}
+}
+
+func TestExtractArchive_unsafe(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+	}{
+		{
+			"write through a symlink",
+			[]*tar.Header{
+				{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "app/escape", Typeflag: tar.TypeSymlink, Linkname: "../../"},
+				{Name: "app/escape/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+			},
+		},
+		{
+			"hard link outside",
+			[]*tar.Header{
+				{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755},
+				{Name: "app/passwd", Typeflag: tar.TypeLink, Linkname: "../../../../etc/passwd"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, "dst")
+			if err := extractArchive(testArchive(t, tt.headers), dst, nil); err == nil {
+				t.Errorf("expected an error")
+			}
+
+			if _, err := os.Stat(filepath.Join(root, "pwned")); !os.IsNotExist(err) {
+				t.Errorf("file written outside of destination")
+			}
+		})
+	}
+
+	// Names are cleaned, so they can't escape the destination directory
+	root := t.TempDir()
+	dst := filepath.Join(root, "dst")
+	archive := testArchive(t, []*tar.Header{
+		{Name: "../../pwned", Typeflag: tar.TypeReg, Mode: 0644},
+	})
+	if err := extractArchive(archive, dst, nil); err != nil {
+		t.Fatalf("failed to extract archive: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "pwned")); err != nil {
+		t.Errorf("file should have been extracted in destination: %s", err)
+	}
+}
@@ -6,12 +6,19 @@ package docker
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // ExportArtifact is an Artifact implementation for when a container is
 // exported from docker into a single flat file.
 type ExportArtifact struct {
 	path string
+	// files are the files of the OCI image layout in the path directory.
+	files []string
+	// checksumFiles are the files holding the checksums of the file.
+	checksumFiles []string
 	// StateData should store data such as GeneratedData
 	// to be shared with post-processors
 	StateData map[string]interface{}
@@ -22,7 +29,11 @@ func (*ExportArtifact) BuilderId() string {
 }
 
 func (a *ExportArtifact) Files() []string {
-	return []string{a.path}
+	files := a.files
+	if files == nil {
+		files = []string{a.path}
+	}
+	return append(files[:len(files):len(files)], a.checksumFiles...)
 }
 
 func (*ExportArtifact) Id() string {
@@ -38,6 +49,39 @@ func (a *ExportArtifact) State(name string) interface{} {
 }
 
 func (a *ExportArtifact) Destroy() error {
+	for _, f := range a.checksumFiles {
+		if err := os.Remove(f); err != nil {
+			return err
+		}
+	}
+	if a.files != nil {
+		// Only the files written by the build are removed, with the
+		// directories they leave empty.
+		root := filepath.Clean(a.path)
+		dirs := map[string]bool{}
+		for _, f := range a.files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for d := filepath.Dir(f); strings.HasPrefix(d, root) && !dirs[d]; d = filepath.Dir(d) {
+				dirs[d] = true
+			}
+		}
+		sorted := make([]string, 0, len(dirs))
+		for d := range dirs {
+			sorted = append(sorted, d)
+		}
+		// Deepest directories first.
+		sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+		for _, d := range sorted {
+			if entries, err := os.ReadDir(d); err == nil && len(entries) == 0 {
+				if err := os.Remove(d); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
 	if a.path != "" {
 		return os.Remove(a.path)
 	}
@@ -4,6 +4,8 @@
 package docker
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -12,3 +14,75 @@ import (
 func TestExportArtifact_impl(t *testing.T) {
 	var _ packersdk.Artifact = new(ExportArtifact)
 }
+
+func TestExportArtifact_checksumFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := &ExportArtifact{
+		path:          filepath.Join(dir, "rootfs.tar"),
+		checksumFiles: []string{filepath.Join(dir, "rootfs.tar.sha256")},
+	}
+	for _, f := range a.Files() {
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(a.Files()) != 2 {
+		t.Fatalf("bad: %#v", a.Files())
+	}
+
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	for _, f := range a.Files() {
+		if _, err := os.Stat(f); err == nil {
+			t.Fatalf("%s should have been removed", f)
+		}
+	}
+}
+
+func TestExportArtifact_files(t *testing.T) {
+	dir := t.TempDir()
+	layout := filepath.Join(dir, "layout")
+	a := &ExportArtifact{
+		path: layout,
+		files: []string{
+			filepath.Join(layout, "blobs", "sha256", "1234"),
+			filepath.Join(layout, "index.json"),
+		},
+	}
+	for _, f := range a.Files() {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(layout); !os.IsNotExist(err) {
+		t.Fatalf("the layout should have been removed: %v", err)
+	}
+
+	// Files the build didn't write are kept, with their directories.
+	other := filepath.Join(layout, "blobs", "sha256", "5678")
+	for _, f := range append(a.Files(), other) {
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Destroy(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Fatalf("%s should have been kept: %s", other, err)
+	}
+	if _, err := os.Stat(a.files[1]); !os.IsNotExist(err) {
+		t.Fatalf("%s should have been removed", a.files[1])
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// buildStep is a step of a BuildKit build, as reported in its plain progress
+// output.
+type buildStep struct {
+	name     string
+	cached   bool
+	done     bool
+	err      string
+	duration time.Duration
+}
+
+// buildProgress follows the plain progress output of a BuildKit build, with
+// lines like:
+//
+//	#5 [2/3] RUN apk add curl
+//	#5 0.231 fetch https://dl-cdn.alpinelinux.org/alpine/v3.20/main/x86_64/APKINDEX.tar.gz
+//	#5 DONE 1.2s
+//
+// The output of the classic builder is not parsed, no steps are reported
+// for it.
+type buildProgress struct {
+	steps map[string]*buildStep
+	order []string
+}
+
+var (
+	buildProgressLine = regexp.MustCompile(`^#(\d+) (.*)$`)
+	// Steps of the Dockerfile are named like `[2/3] RUN ...` or
+	// `[stage 2/3] RUN ...` for multi-stage builds.
+	dockerfileStepName = regexp.MustCompile(`^\[(\S+ )?\d+/\d+\] `)
+)
+
+func newBuildProgress() *buildProgress {
+	return &buildProgress{
+		steps: map[string]*buildStep{},
+	}
+}
+
+// parseLine updates the state of the build from a line of its output.
+func (p *buildProgress) parseLine(line string) {
+	match := buildProgressLine.FindStringSubmatch(strings.TrimRight(line, "\r"))
+	if match == nil {
+		return
+	}
+	id, rest := match[1], match[2]
+
+	step, ok := p.steps[id]
+	if !ok {
+		// The first line of a step is its name
+		p.steps[id] = &buildStep{name: rest}
+		p.order = append(p.order, id)
+		return
+	}
+
+	switch {
+	case rest == "CACHED":
+		step.cached = true
+		step.done = true
+	case strings.HasPrefix(rest, "DONE "):
+		if duration, err := time.ParseDuration(strings.TrimPrefix(rest, "DONE ")); err == nil {
+			step.duration = duration
+		}
+		step.done = true
+	case strings.HasPrefix(rest, "ERROR"):
+		step.err = rest
+	}
+}
+
+// summary returns a line per step of the Dockerfile, with its duration or
+// whether it was cached. The internal steps of BuildKit, like loading the
+// Dockerfile or exporting the image, are left out.
+func (p *buildProgress) summary() []string {
+	var lines []string
+	for _, id := range p.order {
+		step := p.steps[id]
+		if !dockerfileStepName.MatchString(step.name) {
+			continue
+		}
+
+		status := "not finished"
+		switch {
+		case step.err != "":
+			status = "failed"
+		case step.cached:
+			status = "cached"
+		case step.done:
+			status = step.duration.String()
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", step.name, status))
+	}
+	return lines
+}
+
+// cacheHits returns the number of steps of the Dockerfile that were cached,
+// and the total number of steps.
+func (p *buildProgress) cacheHits() (int, int) {
+	cached, total := 0, 0
+	for _, step := range p.steps {
+		if !dockerfileStepName.MatchString(step.name) {
+			continue
+		}
+		total++
+		if step.cached {
+			cached++
+		}
+	}
+	return cached, total
+}
+
+// failure returns the error of the first failed step, or an empty string if
+// none failed.
+func (p *buildProgress) failure() string {
+	for _, id := range p.order {
+		if step := p.steps[id]; step.err != "" {
+			return fmt.Sprintf("%s: %s", step.name, step.err)
+		}
+	}
+	return ""
+}
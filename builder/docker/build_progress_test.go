@@ -0,0 +1,77 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testBuildOutput = `#0 building with "default" instance using docker driver
+
+#1 [internal] load build definition from Dockerfile
+#1 transferring dockerfile: 120B done
+#1 DONE 0.0s
+
+#2 [internal] load metadata for docker.io/library/alpine:3.20
+#2 DONE 0.8s
+
+#3 [build 1/2] FROM docker.io/library/alpine:3.20@sha256:beefdbd8a1da6d2915566fde36db9db0b524eb737fc57cd1367effd16dc0d06d
+#3 CACHED
+
+#4 [build 2/2] RUN apk add --no-cache curl
+#4 0.231 fetch https://dl-cdn.alpinelinux.org/alpine/v3.20/main/x86_64/APKINDEX.tar.gz
+#4 DONE 2.5s
+
+#5 [stage-1 1/1] COPY --from=build /usr/bin/curl /usr/bin/curl
+#5 ERROR: failed to calculate checksum of ref: "/usr/bin/curl": not found
+
+#6 exporting to image
+#6 DONE 0.1s
+`
+
+func TestBuildProgress(t *testing.T) {
+	progress := newBuildProgress()
+	for _, line := range strings.Split(testBuildOutput, "\n") {
+		progress.parseLine(line)
+	}
+
+	expected := []string{
+		"[build 1/2] FROM docker.io/library/alpine:3.20@sha256:beefdbd8a1da6d2915566fde36db9db0b524eb737fc57cd1367effd16dc0d06d: cached",
+		"[build 2/2] RUN apk add --no-cache curl: 2.5s",
+		"[stage-1 1/1] COPY --from=build /usr/bin/curl /usr/bin/curl: failed",
+	}
+	if summary := progress.summary(); !reflect.DeepEqual(summary, expected) {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(summary, "\n"))
+	}
+
+	if cached, total := progress.cacheHits(); cached != 1 || total != 3 {
+		t.Errorf("expected 1 of 3 steps cached, got %d of %d", cached, total)
+	}
+
+	expectedFailure := `[stage-1 1/1] COPY --from=build /usr/bin/curl /usr/bin/curl: ERROR: failed to calculate checksum of ref: "/usr/bin/curl": not found`
+	if failure := progress.failure(); failure != expectedFailure {
+		t.Errorf("expected failure %q, got %q", expectedFailure, failure)
+	}
+}
+
+func TestBuildProgress_classicBuilder(t *testing.T) {
+	progress := newBuildProgress()
+	for _, line := range []string{
+		"Sending build context to Docker daemon  2.048kB",
+		"Step 1/2 : FROM alpine",
+		" ---> 8ca4688f4f35",
+		"Successfully built 8ca4688f4f35",
+	} {
+		progress.parseLine(line)
+	}
+
+	if summary := progress.summary(); len(summary) != 0 {
+		t.Errorf("expected no steps, got %v", summary)
+	}
+	if failure := progress.failure(); failure != "" {
+		t.Errorf("expected no failure, got %q", failure)
+	}
+}
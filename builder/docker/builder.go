@@ -36,6 +36,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	return []string{
 		"ImageSha256",
 		"SourceImageDigest",
+		"Fingerprint",
+		"ExportSha256",
+		"ExportSha512",
 	}, warnings, nil
 }
 
@@ -65,18 +68,27 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	// Setup the driver that will talk to Docker
 	state.Put("driver", driver)
 
-	steps := []multistep.Step{
-		&StepDefaultGeneratedData{
-			GeneratedData: generatedData,
-		},
-		&StepTempDir{},
-		&stepBuild{
-			buildArgs: b.config.BuildConfig,
-		},
+	var provision multistep.Step = &commonsteps.StepProvision{}
+	if b.config.CommitPerProvisioner {
+		log.Print("[DEBUG] Container will be committed after each provisioner")
+		provision = &stepProvisionLayers{
+			StepProvision: &commonsteps.StepProvision{},
+		}
+	}
+
+	// The source steps get the image the container is started from, and the
+	// build steps make the artifact out of it.
+	bootstrap := &stepBuild{
+		buildArgs: b.config.BuildConfig,
+	}
+	source := []multistep.Step{
+		bootstrap,
 		&StepPull{
 			bootstrapped:  !b.config.BuildConfig.IsDefault(),
 			GeneratedData: generatedData,
 		},
+	}
+	build := []multistep.Step{
 		&StepRun{},
 		&communicator.StepConnect{
 			Config:    &b.config.Comm,
@@ -87,27 +99,77 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 				"dockerWindowsContainer": &StepConnectDocker{},
 			},
 		},
-		&commonsteps.StepProvision{},
+		provision,
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.Comm,
 		},
 	}
 
-	if b.config.Discard {
+	if b.config.BuildOnly {
+		log.Print("[DEBUG] Built image will be used as the artifact")
+		source = []multistep.Step{bootstrap}
+		build = []multistep.Step{
+			&stepBuildOnly{
+				GeneratedData: generatedData,
+			},
+		}
+	} else if b.config.Discard {
 		log.Print("[DEBUG] Container will be discarded")
 	} else if b.config.Commit {
 		log.Print("[DEBUG] Container will be committed")
-		steps = append(steps, &StepSetDefaults{})
-		steps = append(steps, &StepCommit{
+		build = append(build, &stepPreCommit{})
+		build = append(build, &StepSetDefaults{})
+		build = append(build, &StepCommit{
 			GeneratedData: generatedData,
 		})
+		if b.config.Squash || b.config.SquashAll {
+			build = append(build, &stepSquash{
+				GeneratedData: generatedData,
+			})
+		}
 	} else if b.config.ExportPath != "" {
 		log.Printf("[DEBUG] Container will be exported to %s", b.config.ExportPath)
-		steps = append(steps, new(StepExport))
+		build = append(build, &stepPreCommit{})
+		if b.config.exportsImage() {
+			build = append(build, &StepSetDefaults{})
+			build = append(build, &StepCommit{
+				GeneratedData: generatedData,
+			})
+		}
+		build = append(build, &StepExport{
+			GeneratedData: generatedData,
+		})
 	} else {
 		return nil, errArtifactNotUsed
 	}
 
+	steps := []multistep.Step{
+		&StepDefaultGeneratedData{
+			GeneratedData: generatedData,
+		},
+		&StepTempDir{},
+	}
+	if b.config.SkipUnchanged {
+		// The build steps only run if no image was built from the same
+		// inputs before, and so does the bootstrap image build, which is
+		// fingerprinted from its inputs.
+		log.Print("[DEBUG] Build will be skipped if its inputs are unchanged")
+		fingerprint := &stepFingerprint{
+			GeneratedData: generatedData,
+		}
+		if b.config.BuildConfig.IsDefault() {
+			steps = append(steps, source...)
+			steps = append(steps, fingerprint)
+		} else {
+			steps = append(steps, fingerprint)
+			steps = append(steps, unlessUnchanged(source)...)
+		}
+		steps = append(steps, unlessUnchanged(build)...)
+	} else {
+		steps = append(steps, source...)
+		steps = append(steps, build...)
+	}
+
 	// Run!
 	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
 	b.runner.Run(ctx, state)
@@ -128,7 +190,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	}
 
 	var artifact packersdk.Artifact
-	if b.config.Commit {
+	if b.config.Commit || b.config.BuildOnly {
 		artifact = &ImportArtifact{
 			IdValue:        state.Get("image_id").(string),
 			BuilderIdValue: BuilderIdImport,
@@ -136,9 +198,16 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			StateData:      stateData,
 		}
 	} else {
+		if checksums, ok := state.GetOk("export_checksums"); ok {
+			stateData["checksums"] = checksums
+		}
+		files, _ := state.Get("export_files").([]string)
+		checksumFiles, _ := state.Get("export_checksum_files").([]string)
 		artifact = &ExportArtifact{
-			path:      b.config.ExportPath,
-			StateData: stateData,
+			path:          b.config.ExportPath,
+			files:         files,
+			checksumFiles: checksumFiles,
+			StateData:     stateData,
 		}
 	}
 
@@ -0,0 +1,310 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:generate packer-sdc struct-markdown
+//go:generate packer-sdc mapstructure-to-hcl2 -type ImageConfigChanges
+
+package docker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ImageConfigChanges is a structured alternative to `changes`, each option is
+// compiled to the matching instruction. The instructions of `changes` are
+// applied after them, and take precedence.
+//
+// ```hcl
+//
+//	image_config {
+//	  entrypoint    = ["/docker-entrypoint.sh"]
+//	  cmd           = ["nginx", "-g", "daemon off;"]
+//	  env           = { NGINX_PORT = "8080" }
+//	  labels        = { "org.opencontainers.image.version" = "1.0" }
+//	  exposed_ports = ["8080/tcp"]
+//	}
+//
+// ```
+type ImageConfigChanges struct {
+	// The default command of the image, in exec form.
+	Cmd []string `mapstructure:"cmd" required:"false"`
+	// The entrypoint of the image, in exec form.
+	Entrypoint []string `mapstructure:"entrypoint" required:"false"`
+	// Environment variables to set in the image.
+	Env map[string]string `mapstructure:"env" required:"false"`
+	// Ports to expose, like `80`, `53/udp` or `8000-8010/tcp`.
+	ExposedPorts []string `mapstructure:"exposed_ports" required:"false"`
+	// Labels to set on the image.
+	Labels map[string]string `mapstructure:"labels" required:"false"`
+	// The user, and optionally group, to run the image as.
+	User string `mapstructure:"user" required:"false"`
+	// The working directory of the image.
+	WorkingDir string `mapstructure:"workdir" required:"false"`
+	// Paths to mark as volumes.
+	Volumes []string `mapstructure:"volumes" required:"false"`
+	// The signal to stop containers of the image with, like `SIGQUIT`.
+	StopSignal string `mapstructure:"stop_signal" required:"false"`
+}
+
+// Changes compiles the options to `docker commit --change` instructions.
+func (c *ImageConfigChanges) Changes() []string {
+	var changes []string
+
+	// Setting the entrypoint resets the command, so it goes first.
+	if c.Entrypoint != nil {
+		changes = append(changes, "ENTRYPOINT "+execForm(c.Entrypoint))
+	}
+	if c.Cmd != nil {
+		changes = append(changes, "CMD "+execForm(c.Cmd))
+	}
+	for _, name := range sortedKeys(c.Env) {
+		changes = append(changes, fmt.Sprintf("ENV %s=%s", name, quoteChangeValue(c.Env[name])))
+	}
+	if len(c.ExposedPorts) > 0 {
+		changes = append(changes, "EXPOSE "+strings.Join(c.ExposedPorts, " "))
+	}
+	changes = append(changes, LabelChanges(c.Labels)...)
+	if c.User != "" {
+		changes = append(changes, "USER "+c.User)
+	}
+	if c.WorkingDir != "" {
+		changes = append(changes, "WORKDIR "+c.WorkingDir)
+	}
+	if len(c.Volumes) > 0 {
+		changes = append(changes, "VOLUME "+execForm(c.Volumes))
+	}
+	if c.StopSignal != "" {
+		changes = append(changes, "STOPSIGNAL "+c.StopSignal)
+	}
+	return changes
+}
+
+// LabelChanges compiles labels to LABEL instructions, sorted by name.
+func LabelChanges(labels map[string]string) []string {
+	var changes []string
+	for _, name := range sortedKeys(labels) {
+		changes = append(changes, fmt.Sprintf("LABEL %s=%s", quoteChangeValue(name), quoteChangeValue(labels[name])))
+	}
+	return changes
+}
+
+// quoteChangeValue quotes a value for an ENV or LABEL instruction, so that it
+// is used as is.
+func quoteChangeValue(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == '$' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// dockerfileInstructions are the instructions that can follow ONBUILD.
+var dockerfileInstructions = map[string]bool{
+	"ADD": true, "ARG": true, "CMD": true, "COPY": true, "ENTRYPOINT": true,
+	"ENV": true, "EXPOSE": true, "HEALTHCHECK": true, "LABEL": true,
+	"RUN": true, "SHELL": true, "STOPSIGNAL": true, "USER": true,
+	"VOLUME": true, "WORKDIR": true,
+}
+
+var (
+	changePort     = regexp.MustCompile(`^([0-9]+)(?:-([0-9]+))?(?:/(tcp|udp|sctp))?$`)
+	changeSignal   = regexp.MustCompile(`^[A-Za-z0-9+-]+$`)
+	changeVariable = regexp.MustCompile(`^[^=\s]+$`)
+)
+
+// ValidateChanges checks that changes are instructions `docker commit` and
+// `docker import` support, with valid arguments.
+func ValidateChanges(changes []string) []error {
+	var errs []error
+	for _, change := range changes {
+		if err := validateChange(change); err != nil {
+			errs = append(errs, fmt.Errorf("invalid change %q: %s", change, err))
+		}
+	}
+	return errs
+}
+
+func validateChange(change string) error {
+	if strings.ContainsAny(change, "\r\n") {
+		return errors.New("a change must be a single line")
+	}
+
+	keyword, args := splitInstruction(change)
+	if args == "" {
+		return fmt.Errorf("%s requires arguments", keyword)
+	}
+
+	switch keyword {
+	case "CMD", "ENTRYPOINT":
+		return validateCommandForm(args)
+	case "ENV", "LABEL":
+		return validateKeyValues(args)
+	case "EXPOSE":
+		for _, port := range strings.Fields(args) {
+			if err := validatePort(port); err != nil {
+				return err
+			}
+		}
+	case "ONBUILD":
+		nested, _ := splitInstruction(args)
+		switch {
+		case nested == "ONBUILD" || nested == "FROM" || nested == "MAINTAINER":
+			return fmt.Errorf("%s isn't allowed as an ONBUILD instruction", nested)
+		case !dockerfileInstructions[nested]:
+			return fmt.Errorf("unknown ONBUILD instruction %s", nested)
+		}
+	case "USER":
+		if len(strings.Fields(args)) != 1 {
+			return errors.New("USER takes a single user[:group] argument")
+		}
+	case "VOLUME":
+		if strings.HasPrefix(args, "[") {
+			return validateJSONArray(args)
+		}
+	case "WORKDIR":
+	case "STOPSIGNAL":
+		if !changeSignal.MatchString(args) {
+			return fmt.Errorf("invalid signal %q", args)
+		}
+	case "HEALTHCHECK":
+		return validateHealthcheck(args)
+	default:
+		return fmt.Errorf("unsupported instruction %s, supported instructions are "+
+			"CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, ONBUILD, USER, VOLUME, WORKDIR, STOPSIGNAL and HEALTHCHECK", keyword)
+	}
+	return nil
+}
+
+// splitInstruction returns the upper-cased keyword of an instruction, and
+// its arguments.
+func splitInstruction(instruction string) (string, string) {
+	instruction = strings.TrimSpace(instruction)
+	keyword, args := instruction, ""
+	if i := strings.IndexFunc(instruction, unicode.IsSpace); i >= 0 {
+		keyword, args = instruction[:i], strings.TrimSpace(instruction[i:])
+	}
+	return strings.ToUpper(keyword), args
+}
+
+// validateCommandForm checks the arguments of CMD and ENTRYPOINT, a JSON
+// array of strings or a shell command.
+func validateCommandForm(args string) error {
+	if strings.HasPrefix(args, "[") {
+		return validateJSONArray(args)
+	}
+	return nil
+}
+
+func validateJSONArray(args string) error {
+	var values []string
+	if err := json.Unmarshal([]byte(args), &values); err != nil {
+		return fmt.Errorf("invalid JSON array of strings: %s", err)
+	}
+	if len(values) == 0 {
+		return errors.New("empty JSON array")
+	}
+	return nil
+}
+
+// validateKeyValues checks the arguments of ENV and LABEL, either `key=value`
+// pairs or the legacy `key value` form.
+func validateKeyValues(args string) error {
+	words, err := splitCommand(args)
+	if err != nil {
+		return err
+	}
+
+	if !strings.Contains(words[0], "=") {
+		if len(words) < 2 {
+			return fmt.Errorf("missing value for %s, expected name=value", words[0])
+		}
+		if !changeVariable.MatchString(words[0]) {
+			return fmt.Errorf("invalid name %q", words[0])
+		}
+		return nil
+	}
+
+	for _, word := range words {
+		name, _, ok := strings.Cut(word, "=")
+		if !ok {
+			return fmt.Errorf("missing value for %s, expected name=value", word)
+		}
+		if name == "" {
+			return fmt.Errorf("missing name in %q", word)
+		}
+	}
+	return nil
+}
+
+// validatePort checks a port specification of EXPOSE, `port[/protocol]` or
+// `first-last[/protocol]`.
+func validatePort(spec string) error {
+	match := changePort.FindStringSubmatch(spec)
+	if match == nil {
+		return fmt.Errorf("invalid port %q, expected port[/tcp|udp|sctp] or a range of ports", spec)
+	}
+
+	first, err := strconv.Atoi(match[1])
+	if err != nil || first < 1 || first > 65535 {
+		return fmt.Errorf("invalid port number in %q", spec)
+	}
+	if match[2] != "" {
+		last, err := strconv.Atoi(match[2])
+		if err != nil || last < first || last > 65535 {
+			return fmt.Errorf("invalid port range in %q", spec)
+		}
+	}
+	return nil
+}
+
+// validateHealthcheck checks the arguments of HEALTHCHECK, `NONE` or
+// `[options] CMD command`.
+func validateHealthcheck(args string) error {
+	if strings.EqualFold(args, "NONE") {
+		return nil
+	}
+
+	rest := args
+	for strings.HasPrefix(rest, "--") {
+		var option string
+		option, rest = splitInstruction(rest)
+		name, value, ok := strings.Cut(strings.ToLower(option), "=")
+		if !ok || value == "" {
+			return fmt.Errorf("missing value for HEALTHCHECK option %s", option)
+		}
+
+		switch name {
+		case "--interval", "--timeout", "--start-period", "--start-interval":
+			if d, err := time.ParseDuration(value); err != nil || d < 0 {
+				return fmt.Errorf("invalid duration for HEALTHCHECK option %s", option)
+			}
+		case "--retries":
+			if n, err := strconv.Atoi(value); err != nil || n < 0 {
+				return fmt.Errorf("invalid number for HEALTHCHECK option %s", option)
+			}
+		default:
+			return fmt.Errorf("unknown HEALTHCHECK option %s", option)
+		}
+	}
+
+	keyword, command := splitInstruction(rest)
+	if keyword != "CMD" {
+		return errors.New("HEALTHCHECK expects NONE or [options] CMD command")
+	}
+	if command == "" {
+		return errors.New("HEALTHCHECK CMD requires a command")
+	}
+	return validateCommandForm(command)
+}
@@ -0,0 +1,110 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateChanges(t *testing.T) {
+	tests := []struct {
+		change    string
+		expectErr bool
+	}{
+		{`CMD ["nginx", "-g", "daemon off;"]`, false},
+		{`CMD nginx -g "daemon off;"`, false},
+		{`CMD ["nginx", "-g"`, true},
+		{`CMD []`, true},
+		{`ENTRYPOINT ["/docker-entrypoint.sh"]`, false},
+		{`entrypoint /docker-entrypoint.sh`, false},
+		{`ENV FOO=bar BAZ="hello world"`, false},
+		{`ENV FOO bar baz`, false},
+		{`ENV FOO`, true},
+		{`ENV FOO=bar BAZ`, true},
+		{`ENV =bar`, true},
+		{`LABEL "org.opencontainers.image.version"="1.0"`, false},
+		{`LABEL version`, true},
+		{`EXPOSE 80 53/udp 8000-8010/tcp`, false},
+		{`EXPOSE 80/http`, true},
+		{`EXPOSE 0`, true},
+		{`EXPOSE 70000`, true},
+		{`EXPOSE 8010-8000`, true},
+		{`ONBUILD RUN make`, false},
+		{`ONBUILD ONBUILD RUN make`, true},
+		{`ONBUILD FROM alpine`, true},
+		{`ONBUILD BUILD .`, true},
+		{`USER nginx:nginx`, false},
+		{`USER nginx nginx`, true},
+		{`VOLUME /data /logs`, false},
+		{`VOLUME ["/data"]`, false},
+		{`VOLUME ["/data"`, true},
+		{`WORKDIR /app`, false},
+		{`WORKDIR`, true},
+		{`STOPSIGNAL SIGQUIT`, false},
+		{`STOPSIGNAL 9`, false},
+		{`STOPSIGNAL SIG QUIT`, true},
+		{`HEALTHCHECK NONE`, false},
+		{`HEALTHCHECK --interval=30s --retries=3 CMD curl -f http://localhost/`, false},
+		{`HEALTHCHECK CMD ["curl", "-f", "http://localhost/"]`, false},
+		{`HEALTHCHECK --interval=soon CMD true`, true},
+		{`HEALTHCHECK --retries=-1 CMD true`, true},
+		{`HEALTHCHECK --verbose=1 CMD true`, true},
+		{`HEALTHCHECK curl -f http://localhost/`, true},
+		{`HEALTHCHECK CMD`, true},
+		{`RUN apt-get update`, true},
+		{`COPY . /app`, true},
+		{"ENV FOO=bar\nRUN true", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.change, func(t *testing.T) {
+			errs := ValidateChanges([]string{tt.change})
+			if tt.expectErr && len(errs) == 0 {
+				t.Fatal("should error")
+			}
+			if !tt.expectErr && len(errs) > 0 {
+				t.Fatalf("bad: %v", errs)
+			}
+		})
+	}
+}
+
+func TestImageConfigChanges(t *testing.T) {
+	c := ImageConfigChanges{
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Env:          map[string]string{"NGINX_PORT": "8080", "GREETING": `say "$HI"`},
+		ExposedPorts: []string{"8080/tcp", "53/udp"},
+		Labels:       map[string]string{"org.opencontainers.image.version": "1.0"},
+		User:         "nginx",
+		WorkingDir:   "/app",
+		Volumes:      []string{"/data"},
+		StopSignal:   "SIGQUIT",
+	}
+
+	expected := []string{
+		`ENTRYPOINT ["/docker-entrypoint.sh"]`,
+		`CMD ["nginx","-g","daemon off;"]`,
+		`ENV GREETING="say \"\$HI\""`,
+		`ENV NGINX_PORT="8080"`,
+		`EXPOSE 8080/tcp 53/udp`,
+		`LABEL "org.opencontainers.image.version"="1.0"`,
+		`USER nginx`,
+		`WORKDIR /app`,
+		`VOLUME ["/data"]`,
+		`STOPSIGNAL SIGQUIT`,
+	}
+	changes := c.Changes()
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("bad: %#v", changes)
+	}
+	if errs := ValidateChanges(changes); len(errs) > 0 {
+		t.Fatalf("bad: %v", errs)
+	}
+
+	if changes := new(ImageConfigChanges).Changes(); len(changes) > 0 {
+		t.Fatalf("bad: %#v", changes)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// checkpointRepository is the repository the checkpoint images are tagged in,
+// their tag is the key of the checkpoint.
+const checkpointRepository = "packer-checkpoint"
+
+// checkpointKey returns the key of the checkpoint of the provisioner at
+// position, counted from 1, with the type typ and the entry config of
+// `checkpoint_keys`, following the checkpoint with the key parent, or the ID
+// of the base image for the first one.
+func checkpointKey(parent string, position int, typ string, config string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00%s", parent, position, typ, config)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// checkpointTag returns the name of the image of the checkpoint with this key.
+func checkpointTag(key string) string {
+	return fmt.Sprintf("%s:%s", checkpointRepository, key)
+}
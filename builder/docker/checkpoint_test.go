@@ -0,0 +1,24 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import "testing"
+
+func TestCheckpointKey(t *testing.T) {
+	expected := checkpointKey("sha256:base", 1, "shell", "setup")
+	if got := checkpointKey("sha256:base", 1, "shell", "setup"); got != expected {
+		t.Errorf("the same provisioner should have the same key")
+	}
+
+	for name, key := range map[string]string{
+		"base image": checkpointKey("sha256:other", 1, "shell", "setup"),
+		"position":   checkpointKey("sha256:base", 2, "shell", "setup"),
+		"type":       checkpointKey("sha256:base", 1, "file", "setup"),
+		"config":     checkpointKey("sha256:base", 1, "shell", "install"),
+	} {
+		if key == expected {
+			t.Errorf("%s should change the key", name)
+		}
+	}
+}
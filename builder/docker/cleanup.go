@@ -0,0 +1,193 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// cleanupPresets are the paths removed by each of the `cleanup_presets`.
+var cleanupPresets = map[string][]string{
+	"apt": {
+		"/var/lib/apt/lists/*",
+		"/var/cache/apt/*.bin",
+		"/var/cache/apt/archives/*.deb",
+		"/var/cache/apt/archives/partial/*",
+	},
+	"apk": {
+		"/var/cache/apk/*",
+	},
+	"yum": {
+		"/var/cache/yum/*",
+		"/var/cache/dnf/*",
+	},
+	"pip": {
+		"~/.cache/pip",
+		"/root/.cache/pip",
+	},
+	"npm": {
+		"~/.npm",
+		"/root/.npm",
+	},
+}
+
+// validateCleanupPath checks that a path of `cleanup_paths` is absolute, or
+// relative to the home directory, and that it can't match the root directory
+// or one of its children.
+func validateCleanupPath(p string) error {
+	rel := strings.TrimPrefix(p, "~/")
+	if rel == p {
+		if !path.IsAbs(p) {
+			return fmt.Errorf("cleanup path %q must be absolute or start with ~/", p)
+		}
+		rel = strings.TrimPrefix(path.Clean(p), "/")
+		first, _, _ := strings.Cut(rel, "/")
+		if first == "" || first == ".." || strings.ContainsAny(first, "*?[") {
+			return fmt.Errorf("cleanup path %q would remove top-level directories", p)
+		}
+	}
+	if rel == "" || strings.HasPrefix(path.Clean(rel), "..") {
+		return fmt.Errorf("invalid cleanup path %q", p)
+	}
+	return nil
+}
+
+// cleanupPaths returns the paths to remove from the container before it is
+// committed or exported.
+func cleanupPaths(config *Config) []string {
+	var paths []string
+	for _, preset := range config.CleanupPresets {
+		paths = append(paths, cleanupPresets[preset]...)
+	}
+	return append(paths, config.CleanupPaths...)
+}
+
+// cleanupBracket matches the bracket expressions of globs that can be
+// left unquoted.
+var cleanupBracket = regexp.MustCompile(`^\[[!^]?[A-Za-z0-9._:!-]+\]`)
+
+// shellGlob quotes a path for a POSIX shell, leaving the glob characters
+// unquoted so that they are expanded. A leading ~/ is replaced with home,
+// or expanded if home is empty.
+func shellGlob(p string, home string) string {
+	var b strings.Builder
+	if rest := strings.TrimPrefix(p, "~/"); rest != p {
+		if home == "" {
+			b.WriteString(`"$HOME"/`)
+		} else {
+			b.WriteString("'" + strings.ReplaceAll(strings.TrimSuffix(home, "/"), "'", `'\''`) + "'/")
+		}
+		p = rest
+	}
+
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			b.WriteString("'" + strings.ReplaceAll(literal.String(), "'", `'\''`) + "'")
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '*' || p[i] == '?':
+			flush()
+			b.WriteByte(p[i])
+		case p[i] == '[' && cleanupBracket.MatchString(p[i:]):
+			flush()
+			bracket := cleanupBracket.FindString(p[i:])
+			b.WriteString(bracket)
+			i += len(bracket) - 1
+		default:
+			literal.WriteByte(p[i])
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// cleanupScript returns a shell script removing paths, with ~/ being home
+// if it isn't empty, and printing the size of what it removed in kilobytes.
+func cleanupScript(paths []string, home string) string {
+	globs := make([]string, len(paths))
+	for i, p := range paths {
+		globs[i] = shellGlob(p, home)
+	}
+	return fmt.Sprintf(`total=0; for p in %s; do `+
+		`if [ -e "$p" ] || [ -L "$p" ]; then `+
+		`size=$(du -sk "$p" 2>/dev/null | cut -f1); `+
+		`rm -rf "$p" && total=$((total + ${size:-0})); `+
+		`fi; done; echo "$total"`, strings.Join(globs, " "))
+}
+
+// runCleanup removes paths from the container through the communicator,
+// and returns the number of bytes reclaimed.
+//
+// With asRoot, the paths are removed as root, whatever user runs the
+// commands of the communicator, so that the caches of the package managers
+// can be removed. Paths under ~/ are still the ones of the home of that
+// user.
+func runCleanup(ctx context.Context, comm packersdk.Communicator, paths []string, asRoot bool) (int64, error) {
+	var home, prefix string
+	if asRoot {
+		prefix = execUserPrefix + "root "
+		for _, p := range paths {
+			if strings.HasPrefix(p, "~/") {
+				var err error
+				if home, err = remoteHome(ctx, comm); err != nil {
+					return 0, err
+				}
+				break
+			}
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := &packersdk.RemoteCmd{
+		Command: prefix + cleanupScript(paths, home),
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	}
+	if err := comm.Start(ctx, cmd); err != nil {
+		return 0, err
+	}
+	if status := cmd.Wait(); status != 0 {
+		return 0, fmt.Errorf("cleanup exited with status %d: %s", status, stderr.String())
+	}
+
+	kb, err := strconv.ParseInt(strings.TrimSpace(stdout.String()), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected output of cleanup %q: %s", stdout.String(), err)
+	}
+	return kb * 1024, nil
+}
+
+// remoteHome returns the home directory of the user running the commands of
+// the communicator.
+func remoteHome(ctx context.Context, comm packersdk.Communicator) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := &packersdk.RemoteCmd{
+		Command: `echo "$HOME"`,
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	}
+	if err := comm.Start(ctx, cmd); err != nil {
+		return "", err
+	}
+	if status := cmd.Wait(); status != 0 {
+		return "", fmt.Errorf("finding the home directory exited with status %d: %s", status, stderr.String())
+	}
+	home := strings.TrimSpace(stdout.String())
+	if !path.IsAbs(home) {
+		return "", fmt.Errorf("unexpected home directory %q", home)
+	}
+	return home, nil
+}
@@ -0,0 +1,116 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestShellGlob(t *testing.T) {
+	tests := map[string]string{
+		"/var/lib/apt/lists/*": `'/var/lib/apt/lists/'*`,
+		"~/.cache/pip":         `"$HOME"/'.cache/pip'`,
+		"/tmp/it's [ab]?":      `'/tmp/it'\''s '[ab]?`,
+		"/packer-files/.[!.]*": `'/packer-files/.'[!.]*`,
+		"/tmp/[$(reboot)]":     `'/tmp/[$(reboot)]'`,
+		"/tmp/$(reboot)":       `'/tmp/$(reboot)'`,
+	}
+	for p, expected := range tests {
+		if glob := shellGlob(p, ""); glob != expected {
+			t.Errorf("%q: expected %s, got %s", p, expected, glob)
+		}
+	}
+
+	if glob := shellGlob("~/.cache/*", "/home/o'neil/"); glob != `'/home/o'\''neil'/'.cache/'*` {
+		t.Errorf("bad: %s", glob)
+	}
+}
+
+func TestCleanupPaths(t *testing.T) {
+	config := &Config{ContainerDir: "/packer-files"}
+	if paths := cleanupPaths(config); len(paths) > 0 {
+		t.Fatalf("bad: %#v", paths)
+	}
+
+	// The container dir is never emptied, it is mounted from the host.
+	config.CleanupPresets = []string{"apk", "pip"}
+	config.CleanupPaths = []string{"/tmp/*"}
+	expected := []string{"/var/cache/apk/*", "~/.cache/pip", "/root/.cache/pip", "/tmp/*"}
+	if paths := cleanupPaths(config); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("bad: %#v", paths)
+	}
+}
+
+func TestCleanupScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"cache/a", "cache/b", "cache/.hidden", "keep/c", "it's here"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(strings.Repeat("x", 8192)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := cleanupScript([]string{
+		filepath.Join(dir, "cache", "*"),
+		filepath.Join(dir, "cache", ".[!.]*"),
+		filepath.Join(dir, "it's here"),
+		filepath.Join(dir, "missing", "*"),
+	}, "")
+	output, err := exec.Command(sh, "-c", script).Output()
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if kb := strings.TrimSpace(string(output)); kb == "0" || kb == "" {
+		t.Fatalf("should have reported the size removed: %q", output)
+	}
+
+	for name, exists := range map[string]bool{
+		"cache":         true,
+		"cache/a":       false,
+		"cache/b":       false,
+		"cache/.hidden": false,
+		"keep/c":        true,
+		"it's here":     false,
+	} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if exists != (err == nil) {
+			t.Errorf("%s: expected exists=%t, got %v", name, exists, err)
+		}
+	}
+}
+
+func TestRunCleanup_asRoot(t *testing.T) {
+	comm := testExecCommunicator(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	cache := filepath.Join(home, ".cache", "pip")
+	if err := os.MkdirAll(cache, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cache, "wheel"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runCleanup(context.Background(), comm, []string{"~/.cache/pip"}, true); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if _, err := os.Stat(cache); !os.IsNotExist(err) {
+		t.Fatalf("should have removed %s: %v", cache, err)
+	}
+}
@@ -5,17 +5,24 @@ package docker
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/hashicorp/go-version"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -29,38 +36,61 @@ type Communicator struct {
 	Version       *version.Version
 	Config        *Config
 	ContainerUser string
-	lock          sync.Mutex
 	EntryPoint    []string
+
+	// Ui is used to report the progress of large uploads, it can be nil.
+	Ui packersdk.Ui
+
+	// lock protects the state shared by the concurrent operations of the
+	// communicator. Commands themselves are not serialized, each of them
+	// runs in its own docker exec process.
+	lock  sync.Mutex
+	owner *archiveOwner
+
+	// containerLock protects ContainerID, which changes when the container
+	// is replaced, and the count of the commands running in the container.
+	containerLock sync.Mutex
+	execs         int
 }
 
+// uploadSpoolSize is the maximum size of the uploads of unknown size that are
+// buffered in memory before being sent to the container.
+const uploadSpoolSize = 64 * 1024 * 1024
+
 var _ packersdk.Communicator = new(Communicator)
 
 func (c *Communicator) Start(ctx context.Context, remote *packersdk.RemoteCmd) error {
-	dockerArgs := []string{
-		"exec",
-		"-i",
-		c.ContainerID,
+	// Without a TTY, the processes of a docker exec outlive the docker
+	// client, so they are marked to be killed in the container when the
+	// context is cancelled. This needs a shell in the container.
+	var execID string
+	if !c.Config.NoShell && !c.Config.WindowsContainer {
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			return err
+		}
+		execID = hex.EncodeToString(id)
 	}
-	dockerArgs = append(dockerArgs, c.EntryPoint...)
-	dockerArgs = append(dockerArgs, fmt.Sprintf("(%s)", remote.Command))
 
-	if c.Config.Pty {
-		dockerArgs = append(dockerArgs[:2], append([]string{"-t"}, dockerArgs[2:]...)...)
-	}
+	// The container can't be replaced while the command runs.
+	c.containerLock.Lock()
+	c.execs++
+	c.containerLock.Unlock()
+	started := false
+	defer func() {
+		if !started {
+			c.endExec()
+		}
+	}()
 
-	if c.Config.ExecUser != "" {
-		dockerArgs = append(dockerArgs[:2],
-			append([]string{"-u", c.Config.ExecUser}, dockerArgs[2:]...)...)
+	dockerArgs, err := c.execArgs(remote.Command, execID)
+	if err != nil {
+		return err
 	}
 
 	cmd := exec.Command(c.Executable, dockerArgs...)
 
-	var (
-		stdin_w io.WriteCloser
-		err     error
-	)
-
-	stdin_w, err = cmd.StdinPipe()
+	stdin_w, err := cmd.StdinPipe()
 	if err != nil {
 		return err
 	}
@@ -75,165 +105,362 @@ func (c *Communicator) Start(ctx context.Context, remote *packersdk.RemoteCmd) e
 		return err
 	}
 
-	// Run the actual command in a goroutine so that Start doesn't block
-	go c.run(cmd, remote, stdin_w, stdout_r, stderr_r)
+	// Start the command
+	log.Printf("Executing %s:", strings.Join(cmd.Args, " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Error executing %q: %s", remote.Command, err)
+	}
+
+	// Cancelling the context kills this docker exec and only this one.
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if execID != "" {
+				c.killExec(execID)
+			}
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	// Wait for the command in a goroutine so that Start doesn't block
+	started = true
+	go func() {
+		c.run(cmd, remote, stdin_w, stdout_r, stderr_r)
+		close(done)
+	}()
 
 	return nil
 }
 
-// Upload uploads a file to the docker container
-func (c *Communicator) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
-	if fi == nil {
-		return c.uploadReader(dst, src)
-	}
-	return c.uploadFile(dst, src, fi)
+// endExec records the end of a command started in the container.
+func (c *Communicator) endExec() {
+	c.containerLock.Lock()
+	defer c.containerLock.Unlock()
+	c.execs--
 }
 
-// uploadReader writes an io.Reader to a temporary file before uploading
-func (c *Communicator) uploadReader(dst string, src io.Reader) error {
-	// Create a temporary file to store the upload
-	tempfile, err := ioutil.TempFile(c.HostDir, "upload")
-	if err != nil {
-		return fmt.Errorf("Failed to open temp file for writing: %s", err)
-	}
-	defer os.Remove(tempfile.Name())
-	defer tempfile.Close()
+// containerID returns the ID of the container the communicator uses.
+func (c *Communicator) containerID() string {
+	c.containerLock.Lock()
+	defer c.containerLock.Unlock()
+	return c.ContainerID
+}
+
+// replaceContainer replaces the container the communicator uses with the
+// one returned by start, which is passed the ID of the current container.
+// Commands can't start meanwhile, and it is refused while commands run.
+func (c *Communicator) replaceContainer(start func(string) (string, error)) error {
+	c.containerLock.Lock()
+	defer c.containerLock.Unlock()
 
-	if _, err := io.Copy(tempfile, src); err != nil {
-		return fmt.Errorf("Failed to copy upload file to tempfile: %s", err)
+	if c.execs > 0 {
+		return fmt.Errorf("Can't replace container %s while %d commands run in it", c.ContainerID, c.execs)
 	}
-	if _, err := tempfile.Seek(0, 0); err != nil {
-		return fmt.Errorf("Error seeking tempfile info: %s", err)
+	id, err := start(c.ContainerID)
+	if err != nil {
+		return err
 	}
+	c.ContainerID = id
+	return nil
+}
 
-	fi, err := tempfile.Stat()
-	if err != nil {
-		return fmt.Errorf("Error getting tempfile info: %s", err)
+// execIDVariable is the environment variable marking the processes of a
+// docker exec, to kill them if it is cancelled.
+const execIDVariable = "PACKER_EXEC_ID"
+
+// killExec kills the processes of the docker exec marked with id in the
+// container.
+func (c *Communicator) killExec(id string) {
+	script := fmt.Sprintf(`for f in /proc/[0-9]*/environ; do
+	if tr '\0' '\n' < "$f" 2>/dev/null | grep -qx '%s=%s'; then
+		p=${f#/proc/}
+		kill -KILL "${p%%/environ}" 2>/dev/null
+	fi
+done; true`, execIDVariable, id)
+
+	args := []string{"exec", "--user", "root", c.containerID()}
+	args = append(args, c.EntryPoint...)
+	args = append(args, script)
+	log.Printf("Killing the processes of cancelled command %s", id)
+	if output, err := exec.Command(c.Executable, args...).CombinedOutput(); err != nil {
+		log.Printf("Error killing the processes of cancelled command %s: %s, %s", id, err, output)
 	}
-	return c.uploadFile(dst, tempfile, &fi)
 }
 
-// uploadFile uses docker cp to copy the file from the host to the container
-func (c *Communicator) uploadFile(dst string, src io.Reader, fi *os.FileInfo) error {
-	// command format: docker cp /path/to/infile containerid:/path/to/outfile
-	log.Printf("Copying to %s on container %s.", dst, c.ContainerID)
+// execUserPrefix is the prefix a command can start with to run as another
+// user than exec_user, like `PACKER_EXEC_USER=root apt-get update`.
+const execUserPrefix = "PACKER_EXEC_USER="
+
+// execArgs returns the arguments of the docker exec running command, with
+// the processes marked with execID if it isn't empty.
+func (c *Communicator) execArgs(command string, execID string) ([]string, error) {
+	user := c.Config.ExecUser
+	if strings.HasPrefix(command, execUserPrefix) {
+		var rest string
+		user, rest, _ = strings.Cut(strings.TrimPrefix(command, execUserPrefix), " ")
+		if user == "" {
+			return nil, fmt.Errorf("Missing user after %s in command %q", execUserPrefix, command)
+		}
+		command = strings.TrimLeft(rest, " ")
+		if command == "" {
+			return nil, fmt.Errorf("Missing command after %s%s", execUserPrefix, user)
+		}
+	}
 
-	localCmd := exec.Command(c.Executable, "cp", "-",
-		fmt.Sprintf("%s:%s", c.ContainerID, filepath.Dir(dst)))
+	dockerArgs := []string{
+		"exec",
+		"-i",
+	}
+	if c.Config.Pty {
+		dockerArgs = append(dockerArgs, "-t")
+	}
+	if user != "" {
+		dockerArgs = append(dockerArgs, "-u", user)
+	}
+	if c.Config.ExecWorkdir != "" {
+		dockerArgs = append(dockerArgs, "-w", c.Config.ExecWorkdir)
+	}
 
-	stderrP, err := localCmd.StderrPipe()
-	if err != nil {
-		return fmt.Errorf("Failed to open pipe: %s", err)
+	names := make([]string, 0, len(c.Config.ExecEnv))
+	for name := range c.Config.ExecEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", name, c.Config.ExecEnv[name]))
+	}
+	if execID != "" {
+		dockerArgs = append(dockerArgs, "-e", fmt.Sprintf("%s=%s", execIDVariable, execID))
 	}
 
-	stdin, err := localCmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("Failed to open pipe: %s", err)
+	dockerArgs = append(dockerArgs, c.containerID())
+	if c.Config.NoShell {
+		// Without a shell, the command has to be split into arguments
+		// here, there is nothing in the container that could do it.
+		args, err := splitCommand(command)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to split command %q without a shell: %s", command, err)
+		}
+		dockerArgs = append(dockerArgs, args...)
+	} else {
+		dockerArgs = append(dockerArgs, c.EntryPoint...)
+		dockerArgs = append(dockerArgs, fmt.Sprintf("(%s)", command))
 	}
 
-	if err := localCmd.Start(); err != nil {
-		return err
+	return dockerArgs, nil
+}
+
+// Upload uploads a file to the docker container
+func (c *Communicator) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	if fi == nil {
+		return c.uploadReader(dst, src)
 	}
+	return c.uploadFile(dst, src, fi)
+}
 
-	archive := tar.NewWriter(stdin)
-	header, err := tar.FileInfoHeader(*fi, "")
+// uploadReader uploads the contents of an io.Reader, whose size needs to be
+// known beforehand to write the header of the archive sent to docker cp.
+//
+// The size is taken from the reader when it can tell it, otherwise the
+// contents are buffered in memory, and only spooled to a temporary file if
+// they are larger than uploadSpoolSize. The file is in the temporary
+// directory of the system rather than in HostDir, which is mounted in the
+// container.
+func (c *Communicator) uploadReader(dst string, src io.Reader) error {
+	size, reader, cleanup, err := sizeReader(src, "")
 	if err != nil {
 		return err
 	}
-	header.Name = filepath.Base(dst)
-	if err := archive.WriteHeader(header); err != nil {
-		return fmt.Errorf("Failed to write header: %s", err)
+	defer cleanup()
+
+	fi := os.FileInfo(&uploadFileInfo{
+		name:    filepath.Base(dst),
+		size:    size,
+		modTime: time.Now(),
+	})
+	return c.uploadFile(dst, reader, &fi)
+}
+
+// sizeReader returns the number of bytes left to read from src, and a reader
+// for them, spooling src in spoolDir, or the temporary directory of the
+// system if it is empty, when needed. The returned cleanup function must be
+// called once the upload is done.
+func sizeReader(src io.Reader, spoolDir string) (int64, io.Reader, func(), error) {
+	noop := func() {}
+
+	switch r := src.(type) {
+	case interface{ Len() int }:
+		// bytes.Reader, strings.Reader and bytes.Buffer know what's left
+		return int64(r.Len()), src, noop, nil
+	case io.Seeker:
+		current, err := r.Seek(0, io.SeekCurrent)
+		if err == nil {
+			end, err := r.Seek(0, io.SeekEnd)
+			if err != nil {
+				return 0, nil, noop, fmt.Errorf("Error seeking upload: %s", err)
+			}
+			if _, err := r.Seek(current, io.SeekStart); err != nil {
+				return 0, nil, noop, fmt.Errorf("Error seeking upload: %s", err)
+			}
+			return end - current, src, noop, nil
+		}
+		// Not actually seekable, like pipes, fall back to buffering.
 	}
 
-	numBytes, err := io.Copy(archive, src)
+	buf := &bytes.Buffer{}
+	n, err := io.CopyN(buf, src, uploadSpoolSize+1)
+	if err == io.EOF {
+		return n, buf, noop, nil
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to pipe upload: %s", err)
+		return 0, nil, noop, fmt.Errorf("Failed to read upload: %s", err)
 	}
-	log.Printf("Copied %d bytes for %s", numBytes, dst)
 
-	if err := archive.Close(); err != nil {
-		return fmt.Errorf("Failed to close archive: %s", err)
+	log.Printf("Upload is larger than %d bytes, spooling it to disk", uploadSpoolSize)
+	tempfile, err := os.CreateTemp(spoolDir, "packer-upload")
+	if err != nil {
+		return 0, nil, noop, fmt.Errorf("Failed to open temp file for writing: %s", err)
 	}
-	if err := stdin.Close(); err != nil {
-		return fmt.Errorf("Failed to close stdin: %s", err)
+	cleanup := func() {
+		tempfile.Close()
+		os.Remove(tempfile.Name())
 	}
 
-	stderrOut, err := ioutil.ReadAll(stderrP)
+	size, err := io.Copy(tempfile, io.MultiReader(buf, src))
 	if err != nil {
-		return err
+		cleanup()
+		return 0, nil, noop, fmt.Errorf("Failed to copy upload file to tempfile: %s", err)
 	}
-
-	if err := localCmd.Wait(); err != nil {
-		return fmt.Errorf("Failed to upload to '%s' in container: %s. %s.", dst, stderrOut, err)
+	if _, err := tempfile.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return 0, nil, noop, fmt.Errorf("Error seeking tempfile info: %s", err)
 	}
 
-	if err := c.fixDestinationOwner(dst); err != nil {
-		return err
-	}
+	return size, tempfile, cleanup, nil
+}
 
-	return nil
+// uploadFileInfo describes the file created by an upload from an io.Reader.
+type uploadFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
 }
 
-func (c *Communicator) UploadDir(dst string, src string, exclude []string) error {
-	/*
-		from https://docs.docker.com/engine/reference/commandline/cp/#extended-description
-		SRC_PATH specifies a directory
-			DEST_PATH does not exist
-				DEST_PATH is created as a directory and the contents of the source directory are copied into this directory
-			DEST_PATH exists and is a file
-				Error condition: cannot copy a directory to a file
-			DEST_PATH exists and is a directory
-				SRC_PATH does not end with /. (that is: slash followed by dot)
-					the source directory is copied into this directory
-				SRC_PATH does end with /. (that is: slash followed by dot)
-					the content of the source directory is copied into this directory
+func (fi *uploadFileInfo) Name() string       { return fi.name }
+func (fi *uploadFileInfo) Size() int64        { return fi.size }
+func (fi *uploadFileInfo) Mode() os.FileMode  { return 0644 }
+func (fi *uploadFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *uploadFileInfo) IsDir() bool        { return false }
+func (fi *uploadFileInfo) Sys() interface{}   { return nil }
+
+// uploadFile uses docker cp to copy the file from the host to the container
+func (c *Communicator) uploadFile(dst string, src io.Reader, fi *os.FileInfo) error {
+	// command format: docker cp /path/to/infile containerid:/path/to/outfile
+	log.Printf("Copying to %s on container %s.", dst, c.containerID())
 
-		translating that in to our semantics:
+	owner, err := c.archiveOwner()
+	if err != nil {
+		return err
+	}
 
-		if source ends in /
-			docker cp src. dest
-		otherwise, cp source dest
+	err = c.copyArchive(filepath.Dir(dst), owner != nil, func(archive *tar.Writer) error {
+		header, err := tar.FileInfoHeader(*fi, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.Base(dst)
+		owner.apply(header)
+		if c.Config.uploadMode != 0 {
+			header.Mode = c.Config.uploadMode
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return fmt.Errorf("Failed to write header: %s", err)
+		}
 
-	*/
+		reader := src
+		if header.Size >= progressThreshold {
+			progress := newProgressReader(src, c.Ui, dst, header.Size)
+			defer progress.done()
+			reader = progress
+		}
+
+		numBytes, err := io.Copy(archive, reader)
+		if err != nil {
+			return fmt.Errorf("Failed to pipe upload: %s", err)
+		}
+		log.Printf("Copied %d bytes for %s", numBytes, dst)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to upload to '%s' in container: %s", dst, err)
+	}
 
-	dockerSource := src
-	if src[len(src)-1] == '/' {
-		dockerSource = fmt.Sprintf("%s.", src)
+	if owner != nil {
+		return nil
 	}
 
-	// Make the directory, then copy into it
-	localCmd := exec.Command(c.Executable, "cp", dockerSource, fmt.Sprintf("%s:%s", c.ContainerID, dst))
+	return c.fixDestinationOwner(dst)
+}
+
+// copyArchive streams the tar archive written by writeFn to `docker cp`,
+// which extracts it in the dir directory of the container.
+//
+// If preserveOwner is set, the archive is copied in archive mode so that
+// the owner set in its headers is kept.
+func (c *Communicator) copyArchive(dir string, preserveOwner bool, writeFn func(*tar.Writer) error) error {
+	args := []string{"cp"}
+	if preserveOwner {
+		args = append(args, "--archive")
+	}
+	args = append(args, "-", fmt.Sprintf("%s:%s", c.containerID(), dir))
+	localCmd := exec.Command(c.Executable, args...)
 
 	stderrP, err := localCmd.StderrPipe()
 	if err != nil {
 		return fmt.Errorf("Failed to open pipe: %s", err)
 	}
+
+	stdin, err := localCmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("Failed to open pipe: %s", err)
+	}
+
 	if err := localCmd.Start(); err != nil {
-		return fmt.Errorf("Failed to copy: %s", err)
+		return err
 	}
+
+	archive := tar.NewWriter(stdin)
+	writeErr := writeFn(archive)
+	if writeErr == nil {
+		if err := archive.Close(); err != nil {
+			writeErr = fmt.Errorf("Failed to close archive: %s", err)
+		}
+	}
+	if err := stdin.Close(); err != nil && writeErr == nil {
+		writeErr = fmt.Errorf("Failed to close stdin: %s", err)
+	}
+
 	stderrOut, err := ioutil.ReadAll(stderrP)
 	if err != nil {
 		return err
 	}
 
-	// Wait for the copy to complete
 	if err := localCmd.Wait(); err != nil {
-		return fmt.Errorf("Failed to upload to '%s' in container: %s. %s.", dst, stderrOut, err)
+		return fmt.Errorf("%s. %s", stderrOut, err)
 	}
 
-	if err := c.fixDestinationOwner(dst); err != nil {
-		return err
-	}
-
-	return nil
+	return writeErr
 }
 
 // Download pulls a file out of a container using `docker cp`. We have a source
 // path and want to write to an io.Writer, not a file. We use - to make docker
 // cp to write to stdout, and then copy the stream to our destination io.Writer.
 func (c *Communicator) Download(src string, dst io.Writer) error {
-	log.Printf("Downloading file from container: %s:%s", c.ContainerID, src)
-	localCmd := exec.Command(c.Executable, "cp", fmt.Sprintf("%s:%s", c.ContainerID, src), "-")
+	containerID := c.containerID()
+	log.Printf("Downloading file from container: %s:%s", containerID, src)
+	localCmd := exec.Command(c.Executable, "cp", fmt.Sprintf("%s:%s", containerID, src), "-")
 
 	pipe, err := localCmd.StdoutPipe()
 	if err != nil {
@@ -279,17 +506,93 @@ func (c *Communicator) Download(src string, dst io.Writer) error {
 	return nil
 }
 
+// UploadDir uploads a directory by streaming an archive of its contents to
+// `docker cp`. As with the SSH communicator, if src ends with a slash only
+// its contents are copied into dst, otherwise the directory itself is.
+//
+// The archive is extracted in the parent of dst, so dst does not need to
+// exist beforehand, and dst itself is not part of the archive so that its
+// owner and mode are left untouched if it already exists. Files and
+// directories matching one of the exclude patterns are not uploaded.
+func (c *Communicator) UploadDir(dst string, src string, exclude []string) error {
+	owner, err := c.archiveOwner()
+	if err != nil {
+		return err
+	}
+
+	dst = path.Clean(dst)
+	parent, prefix := path.Dir(dst), path.Base(dst)
+	if dst == "/" {
+		prefix = ""
+	}
+
+	// Without a trailing slash, the source directory itself is copied into
+	// the destination, otherwise only its contents are.
+	includeRoot := src[len(src)-1] != '/'
+	if includeRoot {
+		prefix = path.Join(prefix, filepath.Base(src))
+	}
+
+	log.Printf("Copying directory %s to %s on container %s.", src, dst, c.containerID())
+	err = c.copyArchive(parent, owner != nil, func(archive *tar.Writer) error {
+		return writeDirArchive(archive, src, prefix, includeRoot, exclude, owner, c.Config.uploadMode)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to upload to '%s' in container: %s", dst, err)
+	}
+
+	if owner != nil {
+		return nil
+	}
+
+	return c.fixDestinationOwner(dst)
+}
+
+// DownloadDir pulls a directory out of a container using `docker cp`, which
+// streams it as a tar archive that is then extracted in dst. As with the SSH
+// communicator, the directory itself ends up in dst, and files and
+// directories matching one of the exclude patterns are not extracted.
 func (c *Communicator) DownloadDir(src string, dst string, exclude []string) error {
-	return fmt.Errorf("DownloadDir is not implemented for docker")
+	containerID := c.containerID()
+	log.Printf("Downloading directory from container: %s:%s", containerID, src)
+	localCmd := exec.Command(c.Executable, "cp", fmt.Sprintf("%s:%s", containerID, src), "-")
+
+	pipe, err := localCmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("Failed to open pipe: %s", err)
+	}
+
+	var stderr bytes.Buffer
+	localCmd.Stderr = &stderr
+
+	if err = localCmd.Start(); err != nil {
+		return fmt.Errorf("Failed to start download: %s", err)
+	}
+
+	extractErr := extractArchive(tar.NewReader(pipe), dst, exclude)
+	if extractErr != nil {
+		// Don't leave docker cp blocked on a pipe nobody reads anymore
+		//nolint:errcheck
+		localCmd.Process.Kill()
+	}
+
+	if err := localCmd.Wait(); err != nil && extractErr == nil {
+		return fmt.Errorf("Failed to download '%s' from container: %s. %s", src, stderr.String(), err)
+	}
+	if extractErr != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("Error downloading directory: %s", stderr.String())
+		}
+		return fmt.Errorf("Failed to download '%s' from container: %s", src, extractErr)
+	}
+
+	return nil
 }
 
-// Runs the given command and blocks until completion
+// Waits for the given started command to complete, while forwarding its
+// input and outputs. Commands are independent from each other, any number of
+// them can run at the same time.
 func (c *Communicator) run(cmd *exec.Cmd, remote *packersdk.RemoteCmd, stdin io.WriteCloser, stdout, stderr io.ReadCloser) {
-	// For Docker, remote communication must be serialized since it
-	// only supports single execution.
-	c.lock.Lock()
-	defer c.lock.Unlock()
-
 	wg := sync.WaitGroup{}
 	repeat := func(w io.Writer, r io.ReadCloser) {
 		//nolint:errcheck
@@ -308,14 +611,6 @@ func (c *Communicator) run(cmd *exec.Cmd, remote *packersdk.RemoteCmd, stdin io.
 		go repeat(remote.Stderr, stderr)
 	}
 
-	// Start the command
-	log.Printf("Executing %s:", strings.Join(cmd.Args, " "))
-	if err := cmd.Start(); err != nil {
-		log.Printf("Error executing: %s", err)
-		remote.SetExited(254)
-		return
-	}
-
 	var exitStatus int
 
 	if remote.Stdin != nil {
@@ -336,32 +631,157 @@ func (c *Communicator) run(cmd *exec.Cmd, remote *packersdk.RemoteCmd, stdin io.
 		// There is no process-independent way to get the REAL
 		// exit status so we just try to go deeper.
 		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-			exitStatus = status.ExitStatus()
+			exitStatus = waitExitStatus(status)
 		}
 	}
 
+	// The container can be replaced once the command exited.
+	c.endExec()
+
 	// Set the exit status which triggers waiters
 	remote.SetExited(exitStatus)
 }
 
+// waitExitStatus returns the exit status of a process, following the shell
+// convention of reporting 128+N for processes killed by the signal N.
+func waitExitStatus(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
 // TODO Workaround for #5307. Remove once #5409 is fixed.
 func (c *Communicator) fixDestinationOwner(destination string) error {
 	if !c.Config.FixUploadOwner {
 		return nil
 	}
 
-	owner := c.ContainerUser
-	if owner == "" {
-		owner = "root"
-	}
-
+	owner := c.uploadOwner()
 	chownArgs := []string{
-		c.Executable, "exec", "--user", "root", c.ContainerID, "/bin/sh", "-c",
-		fmt.Sprintf("chown -R %s %s", owner, destination),
+		c.Executable, "exec", "--user", "root", c.containerID(),
 	}
+	chownArgs = append(chownArgs, c.EntryPoint...)
+	chownArgs = append(chownArgs, fmt.Sprintf("chown -R %s %s", owner, destination))
 	if output, err := exec.Command(chownArgs[0], chownArgs[1:]...).CombinedOutput(); err != nil {
 		return fmt.Errorf("Failed to set owner of the uploaded file: %s, %s", err, output)
 	}
 
 	return nil
 }
+
+// uploadOwner returns the owner uploaded files should have, as a
+// `user[:group]` string.
+func (c *Communicator) uploadOwner() string {
+	if c.Config.UploadOwner != "" {
+		return c.Config.UploadOwner
+	}
+	if c.ContainerUser != "" {
+		return c.ContainerUser
+	}
+	return "root"
+}
+
+// archiveOwner returns the owner to set in the headers of the archives
+// uploaded to the container, or nil if uploads are not owned this way.
+//
+// This is the case with the `archive` upload_owner_method, which is the only
+// one possible when there is no shell in the container.
+func (c *Communicator) archiveOwner() (*archiveOwner, error) {
+	if c.Config.UploadOwnerMethod != UploadOwnerArchive || !c.Config.FixUploadOwner {
+		return nil, nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.owner != nil {
+		return c.owner, nil
+	}
+
+	user := c.uploadOwner()
+
+	// Names need to be translated to IDs, which is done from the passwd
+	// and group files of the container, as nothing can be run there.
+	var passwd, group bytes.Buffer
+	if !isNumericOwner(user) {
+		if err := c.Download("/etc/passwd", &passwd); err != nil {
+			log.Printf("Failed to read /etc/passwd from container: %s", err)
+		}
+		if err := c.Download("/etc/group", &group); err != nil {
+			log.Printf("Failed to read /etc/group from container: %s", err)
+		}
+	}
+
+	owner, err := lookupOwner(user, passwd.String(), group.String())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve owner of uploaded files: %s", err)
+	}
+
+	c.owner = owner
+	return owner, nil
+}
+
+// splitCommand splits a command line into arguments, following the quoting
+// rules of a POSIX shell for single quotes, double quotes and backslashes.
+//
+// This is only meant to run simple commands without a shell, so no other
+// shell syntax (variables, pipes, redirections, ...) is interpreted.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case ch == '\\':
+			if i+1 >= len(command) {
+				return nil, errors.New("trailing backslash")
+			}
+			i++
+			if command[i] != '\n' {
+				current.WriteByte(command[i])
+			}
+			inArg = true
+		case ch == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated single quote")
+			}
+			current.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		case ch == '"':
+			i++
+			for ; i < len(command) && command[i] != '"'; i++ {
+				if command[i] == '\\' && i+1 < len(command) && strings.IndexByte("\\\"$`\n", command[i+1]) >= 0 {
+					i++
+				}
+				current.WriteByte(command[i])
+			}
+			if i >= len(command) {
+				return nil, errors.New("unterminated double quote")
+			}
+			inArg = true
+		default:
+			current.WriteByte(ch)
+			inArg = true
+		}
+	}
+
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	return args, nil
+}
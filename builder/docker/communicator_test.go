@@ -4,15 +4,23 @@
 package docker
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/acctest"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
 // TestUploadDownload verifies that basic upload / download functionality works
@@ -164,6 +172,7 @@ func TestFixUploadOwner(t *testing.T) {
 	testcases := []string{
 		"fix_upload_owner.json",
 		"fix_upload_owner.pkr.hcl",
+		"upload_owner.pkr.hcl",
 	}
 	for _, tc := range testcases {
 		templatePath := filepath.Join("test-fixtures", tc)
@@ -178,3 +187,417 @@ func TestFixUploadOwner(t *testing.T) {
 		})
 	}
 }
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command   string
+		expected  []string
+		expectErr bool
+	}{
+		{"/app/server --version", []string{"/app/server", "--version"}, false},
+		{"  echo   spaced\targs\n", []string{"echo", "spaced", "args"}, false},
+		{`echo 'single quoted $HOME' "double \"quoted\" \$HOME"`, []string{"echo", "single quoted $HOME", `double "quoted" $HOME`}, false},
+		{`echo escaped\ space ''`, []string{"echo", "escaped space", ""}, false},
+		{`echo "unterminated`, nil, true},
+		{`echo 'unterminated`, nil, true},
+		{`echo trailing\`, nil, true},
+		{"   ", nil, true},
+	}
+
+	for _, tt := range tests {
+		args, err := splitCommand(tt.command)
+		if (err != nil) != tt.expectErr {
+			t.Errorf("%q: unexpected error status: %v", tt.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("%q: expected %#v, got %#v", tt.command, tt.expected, args)
+		}
+	}
+}
+
+func TestSizeReader(t *testing.T) {
+	contents := "hello world"
+
+	seeker, err := os.CreateTemp(t.TempDir(), "seeker")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %s", err)
+	}
+	defer seeker.Close()
+	seeker.WriteString(contents)
+	seeker.Seek(6, io.SeekStart)
+
+	tests := []struct {
+		name     string
+		src      io.Reader
+		expected string
+	}{
+		{"Len", strings.NewReader(contents), contents},
+		{"Seeker", seeker, "world"},
+		{"unknown size", io.MultiReader(strings.NewReader(contents)), contents},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spoolDir := t.TempDir()
+			size, reader, cleanup, err := sizeReader(tt.src, spoolDir)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer cleanup()
+
+			if size != int64(len(tt.expected)) {
+				t.Errorf("expected size %d, got %d", len(tt.expected), size)
+			}
+			read, _ := io.ReadAll(reader)
+			if string(read) != tt.expected {
+				t.Errorf("expected contents %q, got %q", tt.expected, read)
+			}
+			if files, _ := os.ReadDir(spoolDir); len(files) != 0 {
+				t.Errorf("small uploads should not be spooled to disk")
+			}
+		})
+	}
+}
+
+func TestSizeReader_spool(t *testing.T) {
+	contents := bytes.Repeat([]byte("x"), uploadSpoolSize+10)
+	spoolDir := t.TempDir()
+
+	size, reader, cleanup, err := sizeReader(io.MultiReader(bytes.NewReader(contents)), spoolDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if size != int64(len(contents)) {
+		t.Errorf("expected size %d, got %d", len(contents), size)
+	}
+	if files, _ := os.ReadDir(spoolDir); len(files) != 1 {
+		t.Errorf("large uploads should be spooled to disk, got %d files", len(files))
+	}
+	read, _ := io.ReadAll(reader)
+	if !bytes.Equal(read, contents) {
+		t.Errorf("spooled contents differ")
+	}
+
+	cleanup()
+	if files, _ := os.ReadDir(spoolDir); len(files) != 0 {
+		t.Errorf("spool file should have been removed")
+	}
+}
+
+func TestCommunicatorUpload_spool(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	hostDir := t.TempDir()
+	spoolDir := t.TempDir()
+	t.Setenv("TMPDIR", spoolDir)
+
+	// Lists the files of both directories while the upload is copied.
+	listing := filepath.Join(t.TempDir(), "listing")
+	executable := filepath.Join(t.TempDir(), "docker")
+	script := fmt.Sprintf(`#!/bin/sh
+if [ "$1" = cp ]; then
+	{ ls -A %[1]q; echo --; ls -A %[2]q; } > %[3]q
+	cat > /dev/null
+fi
+`, hostDir, spoolDir, listing)
+	if err := os.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake docker: %s", err)
+	}
+	comm := &Communicator{
+		Executable:  executable,
+		ContainerID: "container",
+		HostDir:     hostDir,
+		Config:      &Config{},
+	}
+
+	contents := bytes.Repeat([]byte("x"), uploadSpoolSize+10)
+	if err := comm.Upload("/upload", io.MultiReader(bytes.NewReader(contents)), nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	out, err := os.ReadFile(listing)
+	if err != nil {
+		t.Fatalf("the upload wasn't copied: %s", err)
+	}
+	hostFiles, spoolFiles, _ := strings.Cut(string(out), "--\n")
+	if hostFiles != "" {
+		t.Errorf("should not have spooled the upload in the host dir: %q", hostFiles)
+	}
+	if !strings.HasPrefix(spoolFiles, "packer-upload") {
+		t.Errorf("should have spooled the upload in the temporary directory: %q", spoolFiles)
+	}
+	if files, _ := os.ReadDir(spoolDir); len(files) != 0 {
+		t.Errorf("spool file should have been removed")
+	}
+}
+
+// testExecCommunicator returns a communicator whose docker executable runs
+// the commands on the host, skipping the `exec -i <container>` arguments.
+func testExecCommunicator(t *testing.T) *Communicator {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	// Like docker exec without a TTY, the command keeps running if the
+	// client is killed.
+	executable := filepath.Join(t.TempDir(), "docker")
+	script := `#!/bin/sh
+shift
+while [ "$1" != container ]; do
+	case "$1" in
+	-e) export "$2"; shift 2 ;;
+	-u|--user|-w) shift 2 ;;
+	*) shift ;;
+	esac
+done
+shift
+"$@" <&0 &
+wait $!
+`
+	if err := os.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake docker: %s", err)
+	}
+
+	return &Communicator{
+		Executable:  executable,
+		ContainerID: "container",
+		Config:      &Config{},
+		EntryPoint:  []string{"/bin/sh", "-c"},
+	}
+}
+
+func waitCmd(t *testing.T, cmd *packersdk.RemoteCmd) int {
+	done := make(chan int, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case status := <-done:
+		return status
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for %q", cmd.Command)
+	}
+	return 0
+}
+
+func TestCommunicatorStart_concurrent(t *testing.T) {
+	comm := testExecCommunicator(t)
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	// The first command can only complete once the second one ran
+	waiting := &packersdk.RemoteCmd{
+		Command: fmt.Sprintf("while [ ! -f %s ]; do sleep 0.1; done", marker),
+	}
+	if err := comm.Start(context.Background(), waiting); err != nil {
+		t.Fatalf("failed to start command: %s", err)
+	}
+
+	touch := &packersdk.RemoteCmd{Command: fmt.Sprintf("touch %s", marker)}
+	if err := comm.Start(context.Background(), touch); err != nil {
+		t.Fatalf("failed to start command: %s", err)
+	}
+
+	if status := waitCmd(t, touch); status != 0 {
+		t.Errorf("unexpected exit status %d", status)
+	}
+	if status := waitCmd(t, waiting); status != 0 {
+		t.Errorf("unexpected exit status %d", status)
+	}
+}
+
+func TestCommunicatorStart_cancel(t *testing.T) {
+	comm := testExecCommunicator(t)
+
+	if _, err := os.Stat("/proc/self/environ"); err != nil {
+		t.Skip("requires /proc")
+	}
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := &packersdk.RemoteCmd{Command: fmt.Sprintf("sleep 30 & echo $! > %s; wait", pidFile)}
+	if err := comm.Start(ctx, cancelled); err != nil {
+		t.Fatalf("failed to start command: %s", err)
+	}
+	var pid []byte
+	for i := 0; i < 100 && len(pid) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		pid, _ = os.ReadFile(pidFile)
+	}
+
+	var stdout bytes.Buffer
+	other := &packersdk.RemoteCmd{Command: "sleep 1; echo done", Stdout: &stdout}
+	if err := comm.Start(context.Background(), other); err != nil {
+		t.Fatalf("failed to start command: %s", err)
+	}
+
+	cancel()
+	if status := waitCmd(t, cancelled); status == 0 {
+		t.Errorf("cancelled command should not succeed")
+	}
+
+	// The process in the container is killed, not only the docker client.
+	killed := false
+	for i := 0; i < 100 && !killed; i++ {
+		stat, err := os.ReadFile(filepath.Join("/proc", strings.TrimSpace(string(pid)), "stat"))
+		killed = err != nil || strings.Contains(string(stat), ") Z ")
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !killed {
+		t.Errorf("process %s of the cancelled command should have been killed", pid)
+	}
+
+	if status := waitCmd(t, other); status != 0 {
+		t.Errorf("unexpected exit status %d", status)
+	}
+	if strings.TrimSpace(stdout.String()) != "done" {
+		t.Errorf("other command should not have been cancelled, got output %q", stdout.String())
+	}
+}
+
+func TestCommunicatorReplaceContainer(t *testing.T) {
+	comm := testExecCommunicator(t)
+
+	stdin, input := io.Pipe()
+	running := &packersdk.RemoteCmd{Command: "cat", Stdin: stdin}
+	if err := comm.Start(context.Background(), running); err != nil {
+		t.Fatalf("failed to start command: %s", err)
+	}
+
+	// The container is kept while a command runs in it
+	started := false
+	err := comm.replaceContainer(func(string) (string, error) {
+		started = true
+		return "other", nil
+	})
+	if err == nil || started || comm.containerID() != "container" {
+		t.Fatalf("should not replace the container while a command runs")
+	}
+
+	input.Close()
+	if status := waitCmd(t, running); status != 0 {
+		t.Errorf("unexpected exit status %d", status)
+	}
+
+	err = comm.replaceContainer(func(old string) (string, error) {
+		if old != "container" {
+			t.Errorf("should be passed the current container, got %q", old)
+		}
+		return "other", nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if comm.containerID() != "other" {
+		t.Errorf("container should have been replaced, got %q", comm.containerID())
+	}
+}
+
+func TestCommunicatorExecArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    Config
+		command   string
+		expected  []string
+		expectErr bool
+	}{
+		{
+			"defaults",
+			Config{},
+			"echo hello",
+			[]string{"exec", "-i", "id", "/bin/sh", "-c", "(echo hello)"},
+			false,
+		},
+		{
+			"all options",
+			Config{
+				Pty:         true,
+				ExecUser:    "app",
+				ExecWorkdir: "/src",
+				ExecEnv:     map[string]string{"B": "2", "A": "1 2"},
+			},
+			"make",
+			[]string{"exec", "-i", "-t", "-u", "app", "-w", "/src", "-e", "A=1 2", "-e", "B=2", "id", "/bin/sh", "-c", "(make)"},
+			false,
+		},
+		{
+			"user prefix",
+			Config{ExecUser: "app"},
+			"PACKER_EXEC_USER=root  apt-get update",
+			[]string{"exec", "-i", "-u", "root", "id", "/bin/sh", "-c", "(apt-get update)"},
+			false,
+		},
+		{
+			"user prefix without shell",
+			Config{NoShell: true},
+			"PACKER_EXEC_USER=0:0 /app/setup --init",
+			[]string{"exec", "-i", "-u", "0:0", "id", "/app/setup", "--init"},
+			false,
+		},
+		{
+			"empty user prefix",
+			Config{},
+			"PACKER_EXEC_USER= whoami",
+			nil,
+			true,
+		},
+		{
+			"user prefix without command",
+			Config{},
+			"PACKER_EXEC_USER=bob",
+			nil,
+			true,
+		},
+		{
+			"user prefix with blank command",
+			Config{},
+			"PACKER_EXEC_USER=bob   ",
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comm := &Communicator{
+				ContainerID: "id",
+				Config:      &tt.config,
+				EntryPoint:  []string{"/bin/sh", "-c"},
+			}
+
+			args, err := comm.execArgs(tt.command, "")
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("unexpected error status: %v", err)
+			}
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestCommunicatorStart_exitStatus(t *testing.T) {
+	comm := testExecCommunicator(t)
+
+	tests := []struct {
+		command  string
+		expected int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + 15},
+		{"kill -KILL $$", 128 + 9},
+	}
+
+	for _, tt := range tests {
+		// The fake docker executable is the shell here, so signals
+		// are received by the process started by the communicator.
+		cmd := &packersdk.RemoteCmd{Command: tt.command}
+		if err := comm.Start(context.Background(), cmd); err != nil {
+			t.Fatalf("failed to start command: %s", err)
+		}
+		if status := waitCmd(t, cmd); status != tt.expected {
+			t.Errorf("%q: expected exit status %d, got %d", tt.command, tt.expected, status)
+		}
+	}
+}
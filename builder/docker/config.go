@@ -10,6 +10,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
@@ -19,10 +24,26 @@ import (
 	"github.com/mitchellh/mapstructure"
 )
 
+const (
+	// UploadOwnerChown fixes the owner of uploads with `chown -R`
+	UploadOwnerChown = "chown"
+	// UploadOwnerArchive sets the owner of uploads in the archive headers
+	UploadOwnerArchive = "archive"
+
+	// CommitStopStop stops the container before commit or export
+	CommitStopStop = "stop"
+	// CommitStopPause pauses the container during commit or export
+	CommitStopPause = "pause"
+	// CommitStopNone leaves the container running during commit or export
+	CommitStopNone = "none"
+)
+
 var (
-	errArtifactNotUsed     = fmt.Errorf("No instructions given for handling the artifact; expected commit, discard, or export_path")
+	errArtifactNotUsed     = fmt.Errorf("No instructions given for handling the artifact; expected commit, discard, export_path, or build_only")
 	errArtifactUseConflict = fmt.Errorf("Cannot specify more than one of commit, discard, and export_path")
 	errExportPathNotFile   = fmt.Errorf("export_path must be a file, not a directory")
+	errExportPathNotDir    = fmt.Errorf("export_path must be a directory, not a file, with the oci-layout export_format")
+	errExportPathNotEmpty  = fmt.Errorf("export_path must be an empty directory, or not exist, with the oci-layout export_format")
 )
 
 type Config struct {
@@ -38,16 +59,150 @@ type Config struct {
 	// [Bootstrapping a build with a Dockerfile](#bootstrapping-a-build-with-a-dockerfile)
 	// section of this documentation.
 	BuildConfig DockerfileBootstrapConfig `mapstructure:"build"`
+	// Use the image built from the `build` configuration as the artifact of
+	// the build, without running a container from it. Provisioners are not
+	// run in this mode, the image can be tagged and pushed with the
+	// post-processors like a committed image.
+	//
+	// This cannot be used with `commit`, `discard` or `export_path`.
+	BuildOnly bool `mapstructure:"build_only" required:"false"`
 	// Set the author (e-mail) of a commit.
 	Author string `mapstructure:"author"`
 	// Dockerfile instructions to add to the commit. Example of instructions
 	// are CMD, ENTRYPOINT, ENV, and EXPOSE. Example: [ "USER ubuntu", "WORKDIR
 	// /app", "EXPOSE 8080" ]
+	//
+	// Only the instructions `docker commit` supports are allowed: CMD,
+	// ENTRYPOINT, ENV, EXPOSE, LABEL, ONBUILD, USER, VOLUME, WORKDIR,
+	// STOPSIGNAL and HEALTHCHECK. They are checked before the build starts.
 	Changes []string `mapstructure:"changes"`
+	// A structured alternative to `changes`, to set the configuration of the
+	// committed image. See [Image Config](#image-config).
+	ImageConfig ImageConfigChanges `mapstructure:"image_config" required:"false"`
+	// Labels to set on the committed image. They are applied after
+	// `image_config` and before `changes`.
+	Labels map[string]string `mapstructure:"labels" required:"false"`
+	// Label the committed image with the OCI annotations tracing it back to
+	// its build: `org.opencontainers.image.created`, `.base.name` and
+	// `.base.digest` for the source image, and `.revision`, `.source` and
+	// `.version` from the git repository of the directory of the template,
+	// its HEAD commit, `origin` remote and the tag of HEAD. Values that
+	// can't be determined are left out, like the `.base.name` of the images
+	// built with `build`. They can be overridden with `labels` and `changes`.
+	// Defaults to `false`.
+	OCIAnnotations bool `mapstructure:"oci_annotations" required:"false"`
 	// If true, the container will be committed to an image rather than exported.
 	// Default `false`. If `commit` is `false`, then either `discard` must be
 	// set to `true` or an `export_path` must be provided.
 	Commit bool `mapstructure:"commit" required:"true"`
+	// Commit the container after each provisioner, and run the next one in
+	// a new container started from that commit. The image then has one layer
+	// per provisioner, named after it in its history (`docker history`), and
+	// the size of each layer is reported at the end of the build.
+	//
+	// Provisioners are told apart by the `Provisioning with <type>` message
+	// Packer prints when starting them, not by the messages of the
+	// provisioners themselves. A layer is committed before the first command
+	// or file transfer that follows a new provisioner. Provisioners that
+	// don't talk to the container, like `breakpoint`, don't get a layer.
+	// Processes started by a provisioner don't survive into the next one.
+	//
+	// Requires `commit` and the `docker` communicator, and cannot be used
+	// with `windows_container`. Defaults to `false`.
+	CommitPerProvisioner bool `mapstructure:"commit_per_provisioner" required:"false"`
+	// Tag the layers committed by `commit_per_provisioner` as checkpoints,
+	// in the `packer-checkpoint` repository, so that a failed build can be
+	// resumed from the last provisioner that succeeded. Requires
+	// `checkpoint_keys`. Defaults to `false`.
+	//
+	// The checkpoint of a provisioner is keyed by the ID of the base image,
+	// and by the position, type and entry of `checkpoint_keys` of each
+	// provisioner up to it. On the next run, the container is started from
+	// the deepest checkpoint found, and the provisioners it covers are
+	// skipped: their commands succeed without output and their uploads are
+	// dropped, without reaching the container. Their downloads get the files
+	// of the checkpoint.
+	//
+	// The checkpoint tags are removed once the build succeeds.
+	Checkpoint bool `mapstructure:"checkpoint" required:"false"`
+	// The configuration of each provisioner, in order, which its checkpoint
+	// is keyed by, since Packer doesn't give it to builders. Use anything that
+	// changes when the provisioner would do something else, like
+	// `filesha256("setup.sh")` for a script, or a version you bump. The
+	// provisioners past the end of the list aren't checkpointed, nor skipped.
+	CheckpointKeys []string `mapstructure:"checkpoint_keys" required:"false"`
+	// Skip the build when an image was already built from the same inputs,
+	// and use that image as the artifact. Requires `commit`. Defaults to
+	// `false`.
+	//
+	// The inputs are summed up in a fingerprint, which the committed image
+	// is labeled with as `packer.fingerprint`, and which is available to the
+	// post-processors as `build.Fingerprint`. It covers the source image
+	// digest, the `changes`, the options that change how the container is
+	// run, provisioned and committed, like `run_command`, `exec_user`,
+	// `cleanup_paths`, `squash` or `labels`, and the `fingerprint_files` and
+	// `fingerprint_variables`. With a `build` configuration, the Dockerfile,
+	// arguments and context and the IDs of the base images of the Dockerfile
+	// stand for the source image, and the image is only built when no image
+	// is found. Base images that aren't there are pulled first. The
+	// provisioners are not part of the fingerprint: list the files and
+	// variables they use in `fingerprint_files` and `fingerprint_variables`.
+	SkipUnchanged bool `mapstructure:"skip_unchanged" required:"false"`
+	// Files and directories whose contents are part of the fingerprint of
+	// `skip_unchanged`, like the scripts run by the provisioners.
+	FingerprintFiles []string `mapstructure:"fingerprint_files" required:"false"`
+	// Variables that are part of the fingerprint of `skip_unchanged`, like
+	// the versions of the software installed by the provisioners.
+	FingerprintVariables map[string]string `mapstructure:"fingerprint_variables" required:"false"`
+	// A repository to look for the image in when it isn't found locally, as
+	// `<fingerprint_repository>:<fingerprint>`. Images are shared this way
+	// by tagging them with `build.Fingerprint` before pushing them.
+	FingerprintRepository string `mapstructure:"fingerprint_repository" required:"false"`
+	// Squash the layers added to the source image by the build into a
+	// single layer, so that files removed by a provisioner don't take space
+	// in the image. The configuration of the image, like its command,
+	// environment and labels, is preserved. The space saved is reported.
+	// Requires `commit`. Defaults to `false`.
+	Squash bool `mapstructure:"squash" required:"false"`
+	// Squash all the layers of the image into a single one, the ones of the
+	// source image included, as if it was built from scratch. Requires
+	// `commit`. Defaults to `false`.
+	SquashAll bool `mapstructure:"squash_all" required:"false"`
+	// What to do with the container before it is committed or exported, so
+	// that processes still writing to its filesystem don't leave
+	// half-written files in the image: `stop` stops it, `pause` suspends its
+	// processes, and `none` leaves it running. Defaults to `stop` for
+	// windows containers, which can't be committed while running, and to
+	// `none` otherwise.
+	CommitStop string `mapstructure:"commit_stop" required:"false"`
+	// The signal sent to the container by `commit_stop = "stop"`. Defaults
+	// to the stop signal of the container.
+	CommitStopSignal string `mapstructure:"commit_stop_signal" required:"false"`
+	// How long to wait for the container to stop with `commit_stop = "stop"`
+	// before killing it, like `30s`. Defaults to the timeout of `docker stop`.
+	CommitStopTimeout time.Duration `mapstructure:"commit_stop_timeout" required:"false"`
+	// A command run in the container through the communicator before it is
+	// committed or exported, and before `commit_stop` applies, like
+	// `sync; apt-get clean`. The build fails if it exits with a non-zero
+	// status.
+	PreCommitCommand string `mapstructure:"pre_commit_command" required:"false"`
+	// Paths to remove from the container before it is committed or
+	// exported, after the `pre_commit_command`. Paths are absolute, or
+	// relative to the home directory with `~/`, and can contain glob
+	// patterns, like `/tmp/*`. The space reclaimed is reported.
+	//
+	// With the docker communicator, the paths are removed as root, even when
+	// `exec_user` is set, and `~/` is the home directory of the `exec_user`.
+	// Other communicators remove them as the user they log in as.
+	CleanupPaths []string `mapstructure:"cleanup_paths" required:"false"`
+	// Sets of paths to remove like `cleanup_paths`, for the caches of
+	// common tools: `apt`, `apk`, `yum` (and dnf), `pip` and `npm`.
+	//
+	// The contents of `container_dir` are never in the image, as it is
+	// mounted from the host: with the docker communicator and a shell,
+	// the build fails if a provisioner unmounted it. Cleaning up requires a
+	// shell in a linux container.
+	CleanupPresets []string `mapstructure:"cleanup_presets" required:"false"`
 	// The directory inside container to mount temp directory from host server
 	// for work [file provisioner](/packer/docs/provisioners/file). This defaults
 	// to c:/packer-files on windows and /packer-files on other systems.
@@ -80,9 +235,78 @@ type Config struct {
 	// Username (UID) to run remote commands with. You can also set the group
 	// name/ID if you want: (UID or UID:GID). You may need this if you get
 	// permission errors trying to run the shell or other provisioners.
+	//
+	// A single command can be run as another user than `exec_user` by
+	// prefixing it with `PACKER_EXEC_USER=<user> `, for example in the
+	// `execute_command` of a provisioner:
+	//
+	// ```hcl
+	// execute_command = "PACKER_EXEC_USER=root {{ .Vars }} {{ .Path }}"
+	// ```
+	//
+	// The prefix is removed from the command before it is run. It must be
+	// followed by a user and a command.
 	ExecUser string `mapstructure:"exec_user" required:"false"`
+	// The working directory remote commands are run from, as an absolute path
+	// in the container. Defaults to the `WORKDIR` of the image.
+	ExecWorkdir string `mapstructure:"exec_workdir" required:"false"`
+	// Environment variables set for the remote commands, in addition to the
+	// ones of the container.
+	//
+	// ```hcl
+	// exec_env = {
+	//   DEBIAN_FRONTEND = "noninteractive"
+	// }
+	// ```
+	ExecEnv map[string]string `mapstructure:"exec_env" required:"false"`
 	// The path where the final container will be exported as a tar file.
 	ExportPath string `mapstructure:"export_path" required:"true"`
+	// What to export to `export_path`: `rootfs` exports the filesystem of
+	// the container as a flat tar file, like `docker export`. The other
+	// formats export the image committed from the container, with its
+	// configuration, labels and platform: `docker-archive` as a tar file
+	// `docker load` reads, `oci-archive` as a tar file of an OCI image
+	// layout, and `oci-layout` as an OCI image layout in the `export_path`
+	// directory, which must be empty or not exist. The image is deleted once
+	// exported. `ext4` and `squashfs`
+	// export the filesystem of the container as a filesystem image, written
+	// without root privileges or loop devices, to boot or mount, with the
+	// extended attributes of the files, like their capabilities. `lxc`
+	// exports it as the unified tarball of an LXC or Incus image, with a
+	// `metadata.yaml` with the architecture of `platform`, the creation
+	// date and `message` as description, and templates of `/etc/hostname`
+	// and `/etc/hosts`. Defaults to `rootfs`.
+	ExportFormat string `mapstructure:"export_format" required:"false"`
+	// The size of the filesystem of the `ext4` export_format, in bytes or
+	// with a `K`, `M`, `G` or `T` suffix, like `512M` or `4G`. Defaults to
+	// the size the files take, with `export_fs_headroom` free.
+	ExportFSSize string `mapstructure:"export_fs_size" required:"false"`
+	// The percentage of free space and inodes the filesystem of the `ext4`
+	// export_format has in addition to the ones the files take, when
+	// `export_fs_size` isn't set. Defaults to `20`.
+	ExportFSHeadroom int `mapstructure:"export_fs_headroom" required:"false"`
+	// The label of the filesystem of the `ext4` export_format, up to 16
+	// bytes, to mount it with `LABEL=<label>`.
+	ExportFSLabel string `mapstructure:"export_fs_label" required:"false"`
+	// The UUID of the filesystem of the `ext4` export_format, like
+	// `2b8b4c3e-9f4e-4d3c-8f6b-6a1e0c9d7f21`. Defaults to a random UUID.
+	ExportFSUUID string `mapstructure:"export_fs_uuid" required:"false"`
+	// The compression of the file written to `export_path`: `none`, `gzip`,
+	// `zstd` or `xz`. The container is compressed while it is exported.
+	// Defaults to the compression the extension of `export_path` stands
+	// for, like `.tar.gz` or `.tgz` for `gzip`, `.tar.zst` for `zstd` and
+	// `.tar.xz` for `xz`, and to `none` otherwise.
+	ExportCompression string `mapstructure:"export_compression" required:"false"`
+	// The level of `export_compression`, from 1 to 9 for `gzip` and `xz`,
+	// and from 1 to 22 for `zstd`. Defaults to the default level of the
+	// compression.
+	ExportCompressionLevel int `mapstructure:"export_compression_level" required:"false"`
+	// The checksums of the exported file to compute, `sha256` and `sha512`.
+	// Each is written next to the file, to `<export_path>.<checksum>`, in
+	// the format `sha256sum --check` reads, and is available to
+	// post-processors as the `ExportSha256` and `ExportSha512` generated
+	// data.
+	ExportChecksums []string `mapstructure:"export_checksums" required:"false"`
 	// The base image for the Docker container that will be started. This image
 	// will be pulled from the Docker registry if it doesn't already exist.
 	// Any value format that you can provide to `docker pull` is valid.
@@ -118,14 +342,51 @@ type Config struct {
 	// "--entrypoint=/bin/sh", "--", "{{.Image}}"]` if you are using a linux
 	// container, and `["-d", "-i", "-t", "--entrypoint=powershell", "--",
 	// "{{.Image}}"]` if you are running a windows container. `{{.Image}}` is a
-	// template variable that corresponds to the image template option. Passing
-	// the entrypoint option this way will make it the default entrypoint of
-	// the resulting image, so running docker run -it --rm  will start the
-	// docker image from the /bin/sh shell interpreter; you could run a script
-	// or another shell by running docker run -it --rm  -c /bin/bash. If your
-	// docker image embeds a binary intended to be run often, you should
-	// consider changing the default entrypoint to point to it.
+	// template variable that corresponds to the image template option.
+	//
+	// When the container is committed, the configuration of the image that
+	// the options of `run_command` override is restored: the entrypoint,
+	// command, user, working directory, environment variables, labels, stop
+	// signal and healthcheck. `changes` take precedence over it. Environment
+	// variables, labels, exposed ports and volumes added by `run_command`
+	// can't be removed from the committed image, a warning is printed for
+	// them.
+	//
+	// The default entrypoint follows `shell` if it is set, and runs
+	// `pause_command` instead if `no_shell` is set.
 	RunCommand []string `mapstructure:"run_command" required:"false"`
+	// The shell, and the arguments it needs, used to run remote commands and
+	// to fix the owner of uploaded files. The command to run is appended as
+	// the last argument. This defaults to `["/bin/sh", "-c"]`, other examples
+	// are `["/bin/bash", "-lc"]` or `["/bin/busybox", "sh", "-c"]`.
+	//
+	// When using the default `run_command`, the first element of `shell`
+	// is also used as the entrypoint of the container, and the following
+	// ones as its arguments, without the option the command is passed to,
+	// like `-c`.
+	Shell []string `mapstructure:"shell" required:"false"`
+	// If true, the image is assumed to have no shell at all, as is the case
+	// for distroless or `scratch` based images. Remote commands are then
+	// split into arguments and executed directly, uploads are done without
+	// running anything in the container, and the owner of uploaded files is
+	// set through the headers of the archive sent to `docker cp`.
+	//
+	// Since such images usually have nothing that can keep the container
+	// alive, the default `run_command` will run `pause_command` instead of a
+	// shell. This cannot be used with `shell` or `windows_container`.
+	NoShell bool `mapstructure:"no_shell" required:"false"`
+	// Path on the host to a statically linked binary to inject in the
+	// container when `no_shell` is set, like a static `pause` binary that
+	// runs until it is killed. It is copied to the directory shared with the
+	// container, and by default run as `<container_dir>/<binary name>` to
+	// keep the container alive.
+	PauseBinary string `mapstructure:"pause_binary" required:"false"`
+	// The command that keeps the container alive when `no_shell` is set; its
+	// first element is used as the entrypoint of the container. This defaults
+	// to running `pause_binary`. One of them is required with `no_shell`,
+	// unless `run_command` is set, for example `["sleep", "infinity"]` for
+	// images that have a `sleep` binary.
+	PauseCommand []string `mapstructure:"pause_command" required:"false"`
 	// An array of additional tmpfs volumes to mount into this container.
 	TmpFs []string `mapstructure:"tmpfs" required:"false"`
 	// A mapping of additional volumes to mount into this container. The key of
@@ -135,6 +396,27 @@ type Config struct {
 	// container is running as. If false, the owner will depend on the version
 	// of docker installed in the system. Defaults to true.
 	FixUploadOwner bool `mapstructure:"fix_upload_owner" required:"false"`
+	// The owner of the files uploaded to the container, as `user`,
+	// `user:group` or `uid:gid`. Names are resolved from the `/etc/passwd` and
+	// `/etc/group` files of the container. This defaults to the user the
+	// container is running as, and setting it implies `fix_upload_owner`.
+	UploadOwner string `mapstructure:"upload_owner" required:"false"`
+	// The permissions of the files uploaded to the container, as an octal
+	// string, for example `"0644"`. Directories keep the permissions they
+	// have on the host, and so do files by default.
+	UploadMode string `mapstructure:"upload_mode" required:"false"`
+	// How the owner of uploaded files is set, either `chown` or `archive`.
+	//
+	// With `chown`, the default, `chown -R` is run on the destination of the
+	// upload after it is done; this re-owns everything under the destination
+	// directory, including what was there before the upload. With `archive`,
+	// the owner is set in the headers of the archive sent to `docker cp`, so
+	// only the uploaded files and directories are affected, and nothing needs
+	// to run in the container. This is always the case with `no_shell`.
+	//
+	// Both methods work with rootless daemons and user namespace remapping,
+	// the owner being the one seen from inside the container.
+	UploadOwnerMethod string `mapstructure:"upload_owner_method" required:"false"`
 	// If "true", tells Packer that you are building a Windows container
 	// running on a windows host. This is necessary for building Windows
 	// containers, because our normal docker bindings do not work for them.
@@ -161,6 +443,12 @@ type Config struct {
 	AwsAccessConfig `mapstructure:",squash"`
 
 	ctx interpolate.Context
+
+	// The parsed upload_mode, as the mode of a tar header
+	uploadMode int64
+	// The parsed export_fs_size and export_fs_uuid
+	exportFSSize int64
+	exportFSUUID [16]byte
 }
 
 func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
@@ -183,11 +471,59 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		return nil, err
 	}
 
+	var errs *packersdk.MultiError
+	var warnings []string
+
 	// Defaults
-	if len(c.RunCommand) == 0 {
-		c.RunCommand = []string{"-d", "-i", "-t", "--entrypoint=/bin/sh", "--", "{{.Image}}"}
+	if c.ContainerDir == "" {
+		if c.WindowsContainer {
+			c.ContainerDir = "c:/packer-files"
+		} else {
+			c.ContainerDir = "/packer-files"
+		}
+	}
+
+	if c.NoShell {
+		if len(c.Shell) > 0 {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`shell` cannot be specified with `no_shell`"))
+		}
 		if c.WindowsContainer {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`no_shell` is not supported for windows containers"))
+		}
+		if c.PauseBinary != "" {
+			if fi, err := os.Stat(c.PauseBinary); err != nil {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("failed to stat pause_binary %q: %s", c.PauseBinary, err))
+			} else if !fi.Mode().IsRegular() {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("pause_binary %q is not a regular file", c.PauseBinary))
+			}
+		}
+		// Images without a shell rarely have a `sleep` to keep the container
+		// alive either, so what runs has to be given.
+		if len(c.PauseCommand) == 0 && c.PauseBinary != "" {
+			c.PauseCommand = []string{path.Join(c.ContainerDir, filepath.Base(c.PauseBinary))}
+		}
+		if len(c.PauseCommand) == 0 && len(c.RunCommand) == 0 {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`no_shell` requires `pause_binary`, `pause_command` or `run_command` to keep the container alive"))
+		}
+	} else if c.PauseBinary != "" || len(c.PauseCommand) > 0 {
+		warnings = append(warnings, "`pause_binary` and `pause_command` are only used with `no_shell`, they will be ignored")
+	}
+
+	if len(c.Shell) == 0 && !c.NoShell && !c.WindowsContainer {
+		c.Shell = []string{"/bin/sh", "-c"}
+	}
+
+	if len(c.RunCommand) == 0 {
+		switch {
+		case c.WindowsContainer:
 			c.RunCommand = []string{"-d", "-i", "-t", "--entrypoint=powershell", "--", "{{.Image}}"}
+		case c.NoShell:
+			// Without pause_command, which is an error reported above.
+			if len(c.PauseCommand) > 0 {
+				c.RunCommand = append([]string{"-d", "--entrypoint=" + c.PauseCommand[0], "--", "{{.Image}}"}, c.PauseCommand[1:]...)
+			}
+		default:
+			c.RunCommand = append([]string{"-d", "-i", "-t", "--entrypoint=" + c.Shell[0], "--", "{{.Image}}"}, shellArgs(c.Shell)...)
 		}
 	}
 
@@ -203,14 +539,20 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		}
 	}
 
-	var errs *packersdk.MultiError
-	var warnings []string
-
 	if !c.BuildConfig.IsDefault() {
-		_, err := c.BuildConfig.Prepare()
+		if c.BuildOnly {
+			// The bootstrap image is the artifact
+			if c.BuildConfig.Cleanup != "" && c.BuildConfig.Cleanup != BuildCleanupNever {
+				errs = packersdk.MultiErrorAppend(errs, errors.New("`build.cleanup` must be `never` with `build_only`"))
+			}
+			c.BuildConfig.Cleanup = BuildCleanupNever
+		}
+
+		buildWarnings, err := c.BuildConfig.Prepare()
 		if err != nil {
 			errs = packersdk.MultiErrorAppend(errs, err)
 		}
+		warnings = append(warnings, buildWarnings...)
 
 		if c.Image != "" {
 			errs = packersdk.MultiErrorAppend(errs, errors.New("`image` cannot be specified with a build config"))
@@ -251,21 +593,219 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 		errs = packersdk.MultiErrorAppend(errs, errArtifactUseConflict)
 	}
 
-	if c.ExportPath == "" && !c.Commit && !c.Discard {
+	if c.BuildOnly {
+		if c.ExportPath != "" || c.Commit || c.Discard {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`build_only` cannot be specified with commit, discard, or export_path"))
+		}
+		if c.BuildConfig.IsDefault() {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`build_only` requires a build config"))
+		}
+	} else if c.ExportPath == "" && !c.Commit && !c.Discard {
 		errs = packersdk.MultiErrorAppend(errs, errArtifactNotUsed)
 	}
 
+	switch c.CommitStop {
+	case "":
+		c.CommitStop = CommitStopNone
+		if c.WindowsContainer {
+			c.CommitStop = CommitStopStop
+		}
+	case CommitStopStop:
+	case CommitStopPause, CommitStopNone:
+		if c.WindowsContainer && c.Commit {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("commit_stop must be %q to commit a windows container", CommitStopStop))
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid commit_stop %q, expected %q, %q or %q", c.CommitStop, CommitStopStop, CommitStopPause, CommitStopNone))
+	}
+	if c.CommitStop != CommitStopStop && (c.CommitStopSignal != "" || c.CommitStopTimeout != 0) {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`commit_stop_signal` and `commit_stop_timeout` require `commit_stop` to be `stop`"))
+	}
+	if c.CommitStopSignal != "" && !changeSignal.MatchString(c.CommitStopSignal) {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid commit_stop_signal %q", c.CommitStopSignal))
+	}
+	if c.CommitStopTimeout < 0 {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`commit_stop_timeout` must not be negative"))
+	}
+
+	if len(c.CleanupPaths) > 0 || len(c.CleanupPresets) > 0 {
+		if c.WindowsContainer || c.NoShell {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`cleanup_paths` and `cleanup_presets` require a shell in a linux container"))
+		}
+		for _, p := range c.CleanupPaths {
+			if err := validateCleanupPath(p); err != nil {
+				errs = packersdk.MultiErrorAppend(errs, err)
+			}
+		}
+		for _, preset := range c.CleanupPresets {
+			if _, ok := cleanupPresets[preset]; !ok {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("unknown cleanup preset %q, expected apt, apk, yum, pip or npm", preset))
+			}
+		}
+	}
+
+	if (c.Squash || c.SquashAll) && !c.Commit {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`squash` and `squash_all` require `commit`"))
+	}
+
+	if c.OCIAnnotations && !c.Commit && !c.exportsImage() {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`oci_annotations` requires `commit` or an image export_format"))
+	}
+
+	if c.CommitPerProvisioner {
+		if !c.Commit {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`commit_per_provisioner` requires `commit`"))
+		}
+		if c.WindowsContainer {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`commit_per_provisioner` cannot be used with `windows_container`"))
+		} else if c.Comm.Type != "docker" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("`commit_per_provisioner` requires the docker communicator, not %q", c.Comm.Type))
+		}
+	}
+
+	if c.Checkpoint && !c.CommitPerProvisioner {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`checkpoint` requires `commit_per_provisioner`"))
+	}
+	if c.Checkpoint && len(c.CheckpointKeys) == 0 {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`checkpoint` requires `checkpoint_keys`"))
+	}
+
+	if c.SkipUnchanged {
+		if !c.Commit {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`skip_unchanged` requires `commit`"))
+		}
+		for _, file := range c.FingerprintFiles {
+			if _, err := os.Stat(file); err != nil {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("failed to stat fingerprint file %q: %s", file, err))
+			}
+		}
+	} else if len(c.FingerprintFiles) > 0 || len(c.FingerprintVariables) > 0 || c.FingerprintRepository != "" {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`fingerprint_files`, `fingerprint_variables` and `fingerprint_repository` require `skip_unchanged`"))
+	}
+
+	c.Changes = append(append(c.ImageConfig.Changes(), LabelChanges(c.Labels)...), c.Changes...)
+	if es := ValidateChanges(c.Changes); len(es) > 0 {
+		errs = packersdk.MultiErrorAppend(errs, es...)
+	}
+
 	if c.ExportPath != "" {
-		if fi, err := os.Stat(c.ExportPath); err == nil && fi.IsDir() {
+		switch c.ExportFormat {
+		case "":
+			c.ExportFormat = ExportFormatRootfs
+		case ExportFormatRootfs, ExportFormatOCILayout, ExportFormatOCIArchive, ExportFormatDockerArchive, ExportFormatExt4, ExportFormatSquashfs:
+		case ExportFormatLXC:
+			if _, err := lxcArchitecture(c.Platform); err != nil {
+				errs = packersdk.MultiErrorAppend(errs, err)
+			}
+			if c.WindowsContainer {
+				errs = packersdk.MultiErrorAppend(errs, errors.New("the lxc export_format cannot be used with `windows_container`"))
+			}
+		default:
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid export_format %q, expected %q, %q, %q, %q, %q, %q or %q", c.ExportFormat,
+				ExportFormatRootfs, ExportFormatOCILayout, ExportFormatOCIArchive, ExportFormatDockerArchive, ExportFormatExt4, ExportFormatSquashfs, ExportFormatLXC))
+		}
+
+		if c.exportsFilesystem() && c.WindowsContainer {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("the %s export_format cannot be used with `windows_container`", c.ExportFormat))
+		}
+		if c.ExportFormat == ExportFormatExt4 {
+			if c.ExportFSSize != "" {
+				size, err := parseExportFSSize(c.ExportFSSize)
+				if err != nil {
+					errs = packersdk.MultiErrorAppend(errs, err)
+				}
+				c.exportFSSize = size
+			}
+			if c.ExportFSHeadroom == 0 {
+				c.ExportFSHeadroom = 20
+			} else if c.ExportFSHeadroom < 0 {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid export_fs_headroom %d, expected a percentage", c.ExportFSHeadroom))
+			}
+			if len(c.ExportFSLabel) > 16 {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("export_fs_label %q is longer than 16 bytes", c.ExportFSLabel))
+			}
+			if c.ExportFSUUID != "" {
+				uuid, err := parseExportFSUUID(c.ExportFSUUID)
+				if err != nil {
+					errs = packersdk.MultiErrorAppend(errs, err)
+				}
+				c.exportFSUUID = uuid
+			} else if uuid, err := randomExportFSUUID(); err != nil {
+				errs = packersdk.MultiErrorAppend(errs, err)
+			} else {
+				c.exportFSUUID = uuid
+			}
+		} else if c.ExportFSSize != "" || c.ExportFSHeadroom != 0 || c.ExportFSLabel != "" || c.ExportFSUUID != "" {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("`export_fs_size`, `export_fs_headroom`, `export_fs_label` and `export_fs_uuid` require the ext4 export_format"))
+		}
+
+		if c.ExportFormat == ExportFormatOCILayout {
+			if err := checkOCILayoutPath(c.ExportPath); err != nil {
+				errs = packersdk.MultiErrorAppend(errs, err)
+			}
+			if c.ExportCompression != "" || c.ExportCompressionLevel != 0 || len(c.ExportChecksums) > 0 {
+				errs = packersdk.MultiErrorAppend(errs, errors.New("`export_compression`, `export_compression_level` and `export_checksums` cannot be used with the oci-layout export_format"))
+			}
+			c.ExportCompression = ExportCompressionNone
+		} else if fi, err := os.Stat(c.ExportPath); err == nil && fi.IsDir() {
 			errs = packersdk.MultiErrorAppend(errs, errExportPathNotFile)
 		}
+		if c.ExportCompression == "" {
+			c.ExportCompression = exportCompression(c.ExportPath)
+		}
+		if err := validateExportCompression(c.ExportCompression, c.ExportCompressionLevel); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+		seen := map[string]bool{}
+		for _, t := range c.ExportChecksums {
+			if _, ok := exportChecksums[t]; !ok || seen[t] {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid export_checksums %q, expected %s or %s once", t, ExportChecksumSha256, ExportChecksumSha512))
+			}
+			seen[t] = true
+		}
+	} else if c.ExportFormat != "" || c.ExportCompression != "" || c.ExportCompressionLevel != 0 || len(c.ExportChecksums) > 0 ||
+		c.ExportFSSize != "" || c.ExportFSHeadroom != 0 || c.ExportFSLabel != "" || c.ExportFSUUID != "" {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("`export_format`, `export_compression`, `export_compression_level`, `export_checksums` and the `export_fs_*` options require `export_path`"))
 	}
 
-	if c.ContainerDir == "" {
-		if c.WindowsContainer {
-			c.ContainerDir = "c:/packer-files"
-		} else {
-			c.ContainerDir = "/packer-files"
+	if c.UploadOwner != "" {
+		c.FixUploadOwner = true
+		parts := strings.Split(c.UploadOwner, ":")
+		if len(parts) > 2 || parts[0] == "" || len(parts) == 2 && parts[1] == "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid upload_owner %q, expected user, user:group or uid:gid", c.UploadOwner))
+		}
+	}
+
+	if c.UploadMode != "" {
+		mode, err := strconv.ParseUint(c.UploadMode, 8, 32)
+		if err != nil || mode == 0 || mode > 07777 {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid upload_mode %q, expected an octal mode like \"0644\"", c.UploadMode))
+		}
+		c.uploadMode = int64(mode)
+	}
+
+	switch c.UploadOwnerMethod {
+	case "":
+		c.UploadOwnerMethod = UploadOwnerChown
+		if c.NoShell {
+			c.UploadOwnerMethod = UploadOwnerArchive
+		}
+	case UploadOwnerArchive:
+	case UploadOwnerChown:
+		if c.NoShell {
+			errs = packersdk.MultiErrorAppend(errs, errors.New("upload_owner_method `chown` cannot be used with `no_shell`"))
+		}
+	default:
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid upload_owner_method %q, expected %q or %q", c.UploadOwnerMethod, UploadOwnerChown, UploadOwnerArchive))
+	}
+
+	if c.ExecWorkdir != "" && !c.WindowsContainer && !path.IsAbs(c.ExecWorkdir) {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("exec_workdir %q must be an absolute path", c.ExecWorkdir))
+	}
+
+	for name := range c.ExecEnv {
+		if name == "" || strings.ContainsAny(name, "=\x00") {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("invalid exec_env variable name %q", name))
 		}
 	}
 
@@ -279,3 +819,14 @@ func (c *Config) Prepare(raws ...interface{}) ([]string, error) {
 
 	return warnings, nil
 }
+
+// shellArgs returns the arguments the shell is started with to keep the
+// container alive: the ones of `shell`, without the last one if it is the
+// option commands are passed to, like `-c` or `-lc`.
+func shellArgs(shell []string) []string {
+	args := shell[1:]
+	if n := len(args); n > 0 && strings.HasPrefix(args[n-1], "-") && strings.HasSuffix(args[n-1], "c") {
+		args = args[:n-1]
+	}
+	return args
+}
@@ -99,9 +99,28 @@ type FlatConfig struct {
 	WinRMInsecure             *bool                          `mapstructure:"winrm_insecure" cty:"winrm_insecure" hcl:"winrm_insecure"`
 	WinRMUseNTLM              *bool                          `mapstructure:"winrm_use_ntlm" cty:"winrm_use_ntlm" hcl:"winrm_use_ntlm"`
 	BuildConfig               *FlatDockerfileBootstrapConfig `mapstructure:"build" cty:"build" hcl:"build"`
+	BuildOnly                 *bool                          `mapstructure:"build_only" required:"false" cty:"build_only" hcl:"build_only"`
 	Author                    *string                        `mapstructure:"author" cty:"author" hcl:"author"`
 	Changes                   []string                       `mapstructure:"changes" cty:"changes" hcl:"changes"`
+	ImageConfig               *FlatImageConfigChanges        `mapstructure:"image_config" required:"false" cty:"image_config" hcl:"image_config"`
+	Labels                    map[string]string              `mapstructure:"labels" required:"false" cty:"labels" hcl:"labels"`
+	OCIAnnotations            *bool                          `mapstructure:"oci_annotations" required:"false" cty:"oci_annotations" hcl:"oci_annotations"`
 	Commit                    *bool                          `mapstructure:"commit" required:"true" cty:"commit" hcl:"commit"`
+	CommitPerProvisioner      *bool                          `mapstructure:"commit_per_provisioner" required:"false" cty:"commit_per_provisioner" hcl:"commit_per_provisioner"`
+	Checkpoint                *bool                          `mapstructure:"checkpoint" required:"false" cty:"checkpoint" hcl:"checkpoint"`
+	CheckpointKeys            []string                       `mapstructure:"checkpoint_keys" required:"false" cty:"checkpoint_keys" hcl:"checkpoint_keys"`
+	SkipUnchanged             *bool                          `mapstructure:"skip_unchanged" required:"false" cty:"skip_unchanged" hcl:"skip_unchanged"`
+	FingerprintFiles          []string                       `mapstructure:"fingerprint_files" required:"false" cty:"fingerprint_files" hcl:"fingerprint_files"`
+	FingerprintVariables      map[string]string              `mapstructure:"fingerprint_variables" required:"false" cty:"fingerprint_variables" hcl:"fingerprint_variables"`
+	FingerprintRepository     *string                        `mapstructure:"fingerprint_repository" required:"false" cty:"fingerprint_repository" hcl:"fingerprint_repository"`
+	Squash                    *bool                          `mapstructure:"squash" required:"false" cty:"squash" hcl:"squash"`
+	SquashAll                 *bool                          `mapstructure:"squash_all" required:"false" cty:"squash_all" hcl:"squash_all"`
+	CommitStop                *string                        `mapstructure:"commit_stop" required:"false" cty:"commit_stop" hcl:"commit_stop"`
+	CommitStopSignal          *string                        `mapstructure:"commit_stop_signal" required:"false" cty:"commit_stop_signal" hcl:"commit_stop_signal"`
+	CommitStopTimeout         *string                        `mapstructure:"commit_stop_timeout" required:"false" cty:"commit_stop_timeout" hcl:"commit_stop_timeout"`
+	PreCommitCommand          *string                        `mapstructure:"pre_commit_command" required:"false" cty:"pre_commit_command" hcl:"pre_commit_command"`
+	CleanupPaths              []string                       `mapstructure:"cleanup_paths" required:"false" cty:"cleanup_paths" hcl:"cleanup_paths"`
+	CleanupPresets            []string                       `mapstructure:"cleanup_presets" required:"false" cty:"cleanup_presets" hcl:"cleanup_presets"`
 	ContainerDir              *string                        `mapstructure:"container_dir" required:"false" cty:"container_dir" hcl:"container_dir"`
 	Device                    []string                       `mapstructure:"device" required:"false" cty:"device" hcl:"device"`
 	Discard                   *bool                          `mapstructure:"discard" required:"true" cty:"discard" hcl:"discard"`
@@ -109,7 +128,17 @@ type FlatConfig struct {
 	CapDrop                   []string                       `mapstructure:"cap_drop" required:"false" cty:"cap_drop" hcl:"cap_drop"`
 	Executable                *string                        `mapstructure:"docker_path" cty:"docker_path" hcl:"docker_path"`
 	ExecUser                  *string                        `mapstructure:"exec_user" required:"false" cty:"exec_user" hcl:"exec_user"`
+	ExecWorkdir               *string                        `mapstructure:"exec_workdir" required:"false" cty:"exec_workdir" hcl:"exec_workdir"`
+	ExecEnv                   map[string]string              `mapstructure:"exec_env" required:"false" cty:"exec_env" hcl:"exec_env"`
 	ExportPath                *string                        `mapstructure:"export_path" required:"true" cty:"export_path" hcl:"export_path"`
+	ExportFormat              *string                        `mapstructure:"export_format" required:"false" cty:"export_format" hcl:"export_format"`
+	ExportFSSize              *string                        `mapstructure:"export_fs_size" required:"false" cty:"export_fs_size" hcl:"export_fs_size"`
+	ExportFSHeadroom          *int                           `mapstructure:"export_fs_headroom" required:"false" cty:"export_fs_headroom" hcl:"export_fs_headroom"`
+	ExportFSLabel             *string                        `mapstructure:"export_fs_label" required:"false" cty:"export_fs_label" hcl:"export_fs_label"`
+	ExportFSUUID              *string                        `mapstructure:"export_fs_uuid" required:"false" cty:"export_fs_uuid" hcl:"export_fs_uuid"`
+	ExportCompression         *string                        `mapstructure:"export_compression" required:"false" cty:"export_compression" hcl:"export_compression"`
+	ExportCompressionLevel    *int                           `mapstructure:"export_compression_level" required:"false" cty:"export_compression_level" hcl:"export_compression_level"`
+	ExportChecksums           []string                       `mapstructure:"export_checksums" required:"false" cty:"export_checksums" hcl:"export_checksums"`
 	Image                     *string                        `mapstructure:"image" required:"false" cty:"image" hcl:"image"`
 	Message                   *string                        `mapstructure:"message" required:"true" cty:"message" hcl:"message"`
 	Privileged                *bool                          `mapstructure:"privileged" required:"false" cty:"privileged" hcl:"privileged"`
@@ -117,9 +146,16 @@ type FlatConfig struct {
 	Runtime                   *string                        `mapstructure:"runtime" required:"false" cty:"runtime" hcl:"runtime"`
 	Pull                      *bool                          `mapstructure:"pull" required:"false" cty:"pull" hcl:"pull"`
 	RunCommand                []string                       `mapstructure:"run_command" required:"false" cty:"run_command" hcl:"run_command"`
+	Shell                     []string                       `mapstructure:"shell" required:"false" cty:"shell" hcl:"shell"`
+	NoShell                   *bool                          `mapstructure:"no_shell" required:"false" cty:"no_shell" hcl:"no_shell"`
+	PauseBinary               *string                        `mapstructure:"pause_binary" required:"false" cty:"pause_binary" hcl:"pause_binary"`
+	PauseCommand              []string                       `mapstructure:"pause_command" required:"false" cty:"pause_command" hcl:"pause_command"`
 	TmpFs                     []string                       `mapstructure:"tmpfs" required:"false" cty:"tmpfs" hcl:"tmpfs"`
 	Volumes                   map[string]string              `mapstructure:"volumes" required:"false" cty:"volumes" hcl:"volumes"`
 	FixUploadOwner            *bool                          `mapstructure:"fix_upload_owner" required:"false" cty:"fix_upload_owner" hcl:"fix_upload_owner"`
+	UploadOwner               *string                        `mapstructure:"upload_owner" required:"false" cty:"upload_owner" hcl:"upload_owner"`
+	UploadMode                *string                        `mapstructure:"upload_mode" required:"false" cty:"upload_mode" hcl:"upload_mode"`
+	UploadOwnerMethod         *string                        `mapstructure:"upload_owner_method" required:"false" cty:"upload_owner_method" hcl:"upload_owner_method"`
 	WindowsContainer          *bool                          `mapstructure:"windows_container" required:"false" cty:"windows_container" hcl:"windows_container"`
 	Platform                  *string                        `mapstructure:"platform" required:"false" cty:"platform" hcl:"platform"`
 	Login                     *bool                          `mapstructure:"login" required:"false" cty:"login" hcl:"login"`
@@ -204,9 +240,28 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"winrm_insecure":               &hcldec.AttrSpec{Name: "winrm_insecure", Type: cty.Bool, Required: false},
 		"winrm_use_ntlm":               &hcldec.AttrSpec{Name: "winrm_use_ntlm", Type: cty.Bool, Required: false},
 		"build":                        &hcldec.BlockSpec{TypeName: "build", Nested: hcldec.ObjectSpec((*FlatDockerfileBootstrapConfig)(nil).HCL2Spec())},
+		"build_only":                   &hcldec.AttrSpec{Name: "build_only", Type: cty.Bool, Required: false},
 		"author":                       &hcldec.AttrSpec{Name: "author", Type: cty.String, Required: false},
 		"changes":                      &hcldec.AttrSpec{Name: "changes", Type: cty.List(cty.String), Required: false},
+		"image_config":                 &hcldec.BlockSpec{TypeName: "image_config", Nested: hcldec.ObjectSpec((*FlatImageConfigChanges)(nil).HCL2Spec())},
+		"labels":                       &hcldec.AttrSpec{Name: "labels", Type: cty.Map(cty.String), Required: false},
+		"oci_annotations":              &hcldec.AttrSpec{Name: "oci_annotations", Type: cty.Bool, Required: false},
 		"commit":                       &hcldec.AttrSpec{Name: "commit", Type: cty.Bool, Required: false},
+		"commit_per_provisioner":       &hcldec.AttrSpec{Name: "commit_per_provisioner", Type: cty.Bool, Required: false},
+		"checkpoint":                   &hcldec.AttrSpec{Name: "checkpoint", Type: cty.Bool, Required: false},
+		"checkpoint_keys":              &hcldec.AttrSpec{Name: "checkpoint_keys", Type: cty.List(cty.String), Required: false},
+		"skip_unchanged":               &hcldec.AttrSpec{Name: "skip_unchanged", Type: cty.Bool, Required: false},
+		"fingerprint_files":            &hcldec.AttrSpec{Name: "fingerprint_files", Type: cty.List(cty.String), Required: false},
+		"fingerprint_variables":        &hcldec.AttrSpec{Name: "fingerprint_variables", Type: cty.Map(cty.String), Required: false},
+		"fingerprint_repository":       &hcldec.AttrSpec{Name: "fingerprint_repository", Type: cty.String, Required: false},
+		"squash":                       &hcldec.AttrSpec{Name: "squash", Type: cty.Bool, Required: false},
+		"squash_all":                   &hcldec.AttrSpec{Name: "squash_all", Type: cty.Bool, Required: false},
+		"commit_stop":                  &hcldec.AttrSpec{Name: "commit_stop", Type: cty.String, Required: false},
+		"commit_stop_signal":           &hcldec.AttrSpec{Name: "commit_stop_signal", Type: cty.String, Required: false},
+		"commit_stop_timeout":          &hcldec.AttrSpec{Name: "commit_stop_timeout", Type: cty.String, Required: false},
+		"pre_commit_command":           &hcldec.AttrSpec{Name: "pre_commit_command", Type: cty.String, Required: false},
+		"cleanup_paths":                &hcldec.AttrSpec{Name: "cleanup_paths", Type: cty.List(cty.String), Required: false},
+		"cleanup_presets":              &hcldec.AttrSpec{Name: "cleanup_presets", Type: cty.List(cty.String), Required: false},
 		"container_dir":                &hcldec.AttrSpec{Name: "container_dir", Type: cty.String, Required: false},
 		"device":                       &hcldec.AttrSpec{Name: "device", Type: cty.List(cty.String), Required: false},
 		"discard":                      &hcldec.AttrSpec{Name: "discard", Type: cty.Bool, Required: false},
@@ -214,7 +269,17 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"cap_drop":                     &hcldec.AttrSpec{Name: "cap_drop", Type: cty.List(cty.String), Required: false},
 		"docker_path":                  &hcldec.AttrSpec{Name: "docker_path", Type: cty.String, Required: false},
 		"exec_user":                    &hcldec.AttrSpec{Name: "exec_user", Type: cty.String, Required: false},
+		"exec_workdir":                 &hcldec.AttrSpec{Name: "exec_workdir", Type: cty.String, Required: false},
+		"exec_env":                     &hcldec.AttrSpec{Name: "exec_env", Type: cty.Map(cty.String), Required: false},
 		"export_path":                  &hcldec.AttrSpec{Name: "export_path", Type: cty.String, Required: false},
+		"export_format":                &hcldec.AttrSpec{Name: "export_format", Type: cty.String, Required: false},
+		"export_fs_size":               &hcldec.AttrSpec{Name: "export_fs_size", Type: cty.String, Required: false},
+		"export_fs_headroom":           &hcldec.AttrSpec{Name: "export_fs_headroom", Type: cty.Number, Required: false},
+		"export_fs_label":              &hcldec.AttrSpec{Name: "export_fs_label", Type: cty.String, Required: false},
+		"export_fs_uuid":               &hcldec.AttrSpec{Name: "export_fs_uuid", Type: cty.String, Required: false},
+		"export_compression":           &hcldec.AttrSpec{Name: "export_compression", Type: cty.String, Required: false},
+		"export_compression_level":     &hcldec.AttrSpec{Name: "export_compression_level", Type: cty.Number, Required: false},
+		"export_checksums":             &hcldec.AttrSpec{Name: "export_checksums", Type: cty.List(cty.String), Required: false},
 		"image":                        &hcldec.AttrSpec{Name: "image", Type: cty.String, Required: false},
 		"message":                      &hcldec.AttrSpec{Name: "message", Type: cty.String, Required: false},
 		"privileged":                   &hcldec.AttrSpec{Name: "privileged", Type: cty.Bool, Required: false},
@@ -222,9 +287,16 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"runtime":                      &hcldec.AttrSpec{Name: "runtime", Type: cty.String, Required: false},
 		"pull":                         &hcldec.AttrSpec{Name: "pull", Type: cty.Bool, Required: false},
 		"run_command":                  &hcldec.AttrSpec{Name: "run_command", Type: cty.List(cty.String), Required: false},
+		"shell":                        &hcldec.AttrSpec{Name: "shell", Type: cty.List(cty.String), Required: false},
+		"no_shell":                     &hcldec.AttrSpec{Name: "no_shell", Type: cty.Bool, Required: false},
+		"pause_binary":                 &hcldec.AttrSpec{Name: "pause_binary", Type: cty.String, Required: false},
+		"pause_command":                &hcldec.AttrSpec{Name: "pause_command", Type: cty.List(cty.String), Required: false},
 		"tmpfs":                        &hcldec.AttrSpec{Name: "tmpfs", Type: cty.List(cty.String), Required: false},
 		"volumes":                      &hcldec.AttrSpec{Name: "volumes", Type: cty.Map(cty.String), Required: false},
 		"fix_upload_owner":             &hcldec.AttrSpec{Name: "fix_upload_owner", Type: cty.Bool, Required: false},
+		"upload_owner":                 &hcldec.AttrSpec{Name: "upload_owner", Type: cty.String, Required: false},
+		"upload_mode":                  &hcldec.AttrSpec{Name: "upload_mode", Type: cty.String, Required: false},
+		"upload_owner_method":          &hcldec.AttrSpec{Name: "upload_owner_method", Type: cty.String, Required: false},
 		"windows_container":            &hcldec.AttrSpec{Name: "windows_container", Type: cty.Bool, Required: false},
 		"platform":                     &hcldec.AttrSpec{Name: "platform", Type: cty.String, Required: false},
 		"login":                        &hcldec.AttrSpec{Name: "login", Type: cty.Bool, Required: false},
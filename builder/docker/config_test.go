@@ -6,6 +6,8 @@ package docker
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -230,6 +232,79 @@ func TestConfigBuildBootstrapConfig(t *testing.T) {
 			false,
 			false,
 		},
+		{
+			"success - with inline content",
+			map[string]interface{}{
+				"content": "FROM alpine\n",
+			},
+			false,
+			false,
+		},
+		{
+			"error - build path and inline content",
+			map[string]interface{}{
+				"path":    "./test-fixtures/sample_dockerfile",
+				"content": "FROM alpine\n",
+			},
+			false,
+			true,
+		},
+		{
+			"error - unknown context tarball",
+			map[string]interface{}{
+				"content":         "FROM alpine\n",
+				"context_tarball": "./test-fixtures/no_such_context.tar",
+			},
+			false,
+			true,
+		},
+		{
+			"error - build dir and context tarball",
+			map[string]interface{}{
+				"content":         "FROM alpine\n",
+				"build_dir":       "./test-fixtures",
+				"context_tarball": "./test-fixtures/sample_dockerfile",
+			},
+			false,
+			true,
+		},
+		{
+			"error - invalid cleanup",
+			map[string]interface{}{
+				"path":    "./test-fixtures/sample_dockerfile",
+				"cleanup": "sometimes",
+			},
+			false,
+			true,
+		},
+		{
+			"error - inline content without FROM",
+			map[string]interface{}{
+				"content": "RUN true\n",
+			},
+			false,
+			true,
+		},
+		{
+			"error - unknown target stage",
+			map[string]interface{}{
+				"content": "FROM alpine AS build\n",
+				"target":  "release",
+			},
+			false,
+			true,
+		},
+		{
+			"error - git context without path",
+			map[string]interface{}{
+				"content": "FROM alpine\n",
+				"context_git": map[string]interface{}{
+					"ref": "main",
+				},
+			},
+			false,
+			true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -257,3 +332,758 @@ func TestConfigBuildBootstrapConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigBuildBootstrapConfig_warnings(t *testing.T) {
+	raw := map[string]interface{}{
+		"discard": true,
+		"build": map[string]interface{}{
+			"content":   "ARG VERSION\nFROM alpine\n",
+			"arguments": map[string]string{"VERSOIN": "1.0"},
+		},
+	}
+
+	var c Config
+	warns, errs := c.Prepare(raw)
+	if errs != nil {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+	if len(warns) != 2 {
+		t.Errorf("expected warnings for the unused and missing build arguments, got %v", warns)
+	}
+	if !reflect.DeepEqual(c.BuildConfig.baseImages, []string{"alpine"}) {
+		t.Errorf("unexpected base images %v", c.BuildConfig.baseImages)
+	}
+}
+
+func TestConfigPrepare_buildOnly(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			"build only",
+			map[string]interface{}{
+				"build_only": true,
+				"build":      map[string]interface{}{"path": "./test-fixtures/sample_dockerfile"},
+			},
+			false,
+		},
+		{
+			"without build config",
+			map[string]interface{}{
+				"build_only": true,
+				"image":      "alpine",
+			},
+			true,
+		},
+		{
+			"with cleanup",
+			map[string]interface{}{
+				"build_only": true,
+				"build": map[string]interface{}{
+					"path":    "./test-fixtures/sample_dockerfile",
+					"cleanup": "always",
+				},
+			},
+			true,
+		},
+		{
+			"with commit",
+			map[string]interface{}{
+				"build_only": true,
+				"commit":     true,
+				"build":      map[string]interface{}{"path": "./test-fixtures/sample_dockerfile"},
+			},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			warns, errs := c.Prepare(tt.config)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+		})
+	}
+}
+
+func TestConfigPrepare_commitPerProvisioner(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			"with commit",
+			map[string]interface{}{
+				"image":                  "alpine",
+				"commit":                 true,
+				"commit_per_provisioner": true,
+			},
+			false,
+		},
+		{
+			"with checkpoint",
+			map[string]interface{}{
+				"image":                  "alpine",
+				"commit":                 true,
+				"commit_per_provisioner": true,
+				"checkpoint":             true,
+				"checkpoint_keys":        []string{"setup", "install"},
+			},
+			false,
+		},
+		{
+			"checkpoint alone",
+			map[string]interface{}{
+				"image":           "alpine",
+				"commit":          true,
+				"checkpoint":      true,
+				"checkpoint_keys": []string{"setup"},
+			},
+			true,
+		},
+		{
+			"checkpoint without keys",
+			map[string]interface{}{
+				"image":                  "alpine",
+				"commit":                 true,
+				"commit_per_provisioner": true,
+				"checkpoint":             true,
+			},
+			true,
+		},
+		{
+			"without commit",
+			map[string]interface{}{
+				"image":                  "alpine",
+				"export_path":            "image.tar",
+				"commit_per_provisioner": true,
+			},
+			true,
+		},
+		{
+			"windows container",
+			map[string]interface{}{
+				"image":                  "mcr.microsoft.com/windows/servercore",
+				"commit":                 true,
+				"windows_container":      true,
+				"commit_per_provisioner": true,
+			},
+			true,
+		},
+		{
+			"ssh communicator",
+			map[string]interface{}{
+				"image":                  "alpine",
+				"commit":                 true,
+				"communicator":           "ssh",
+				"ssh_username":           "root",
+				"commit_per_provisioner": true,
+			},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			warns, errs := c.Prepare(tt.config)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+		})
+	}
+}
+
+func TestConfigPrepare_skipUnchanged(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{
+			"with commit",
+			map[string]interface{}{
+				"image":                  "alpine",
+				"commit":                 true,
+				"skip_unchanged":         true,
+				"fingerprint_files":      []string{"./test-fixtures/sample_dockerfile"},
+				"fingerprint_variables":  map[string]string{"nginx": "1.26"},
+				"fingerprint_repository": "registry.example.com/app",
+			},
+			false,
+		},
+		{
+			"without commit",
+			map[string]interface{}{
+				"image":          "alpine",
+				"discard":        true,
+				"skip_unchanged": true,
+			},
+			true,
+		},
+		{
+			"unknown file",
+			map[string]interface{}{
+				"image":             "alpine",
+				"commit":            true,
+				"skip_unchanged":    true,
+				"fingerprint_files": []string{"./test-fixtures/no_such_file"},
+			},
+			true,
+		},
+		{
+			"fingerprint without skip_unchanged",
+			map[string]interface{}{
+				"image":                 "alpine",
+				"commit":                true,
+				"fingerprint_variables": map[string]string{"nginx": "1.26"},
+			},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			warns, errs := c.Prepare(tt.config)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+		})
+	}
+}
+
+func TestConfigPrepare_shell(t *testing.T) {
+	raw := testConfig()
+
+	// Default shell
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if !reflect.DeepEqual(c.Shell, []string{"/bin/sh", "-c"}) {
+		t.Fatalf("bad default shell: %#v", c.Shell)
+	}
+	if c.RunCommand[3] != "--entrypoint=/bin/sh" {
+		t.Fatalf("bad default run command: %#v", c.RunCommand)
+	}
+
+	// Custom shell
+	raw["shell"] = []string{"/bin/bash", "-lc"}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	expected := []string{"-d", "-i", "-t", "--entrypoint=/bin/bash", "--", "{{.Image}}"}
+	if !reflect.DeepEqual(c.RunCommand, expected) {
+		t.Fatalf("bad run command: %#v", c.RunCommand)
+	}
+
+	// Shell with arguments, which keep it running as the entrypoint
+	raw["shell"] = []string{"/bin/busybox", "sh", "-c"}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	expected = []string{"-d", "-i", "-t", "--entrypoint=/bin/busybox", "--", "{{.Image}}", "sh"}
+	if !reflect.DeepEqual(c.RunCommand, expected) {
+		t.Fatalf("bad run command: %#v", c.RunCommand)
+	}
+
+	// Shell and no_shell (invalid)
+	raw["no_shell"] = true
+	warns, errs = (&Config{}).Prepare(raw)
+	testConfigErr(t, warns, errs)
+}
+
+func TestConfigPrepare_noShell(t *testing.T) {
+	raw := testConfig()
+	raw["no_shell"] = true
+
+	// Nothing to keep the container alive
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+
+	raw["pause_command"] = []string{"sleep", "infinity"}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if len(c.Shell) != 0 {
+		t.Fatalf("shell should not be set: %#v", c.Shell)
+	}
+	expected := []string{"-d", "--entrypoint=sleep", "--", "{{.Image}}", "infinity"}
+	if !reflect.DeepEqual(c.RunCommand, expected) {
+		t.Fatalf("bad run command: %#v", c.RunCommand)
+	}
+
+	// Pause binary
+	tf, err := ioutil.TempFile("", "pause")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	delete(raw, "pause_command")
+	raw["pause_binary"] = tf.Name()
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	expected = []string{"-d", "--entrypoint=/packer-files/" + filepath.Base(tf.Name()), "--", "{{.Image}}"}
+	if !reflect.DeepEqual(c.RunCommand, expected) {
+		t.Fatalf("bad run command: %#v", c.RunCommand)
+	}
+
+	// Missing pause binary
+	raw["pause_binary"] = tf.Name() + "-missing"
+	warns, errs = (&Config{}).Prepare(raw)
+	testConfigErr(t, warns, errs)
+
+	// Windows containers
+	delete(raw, "pause_binary")
+	raw["windows_container"] = true
+	warns, errs = (&Config{}).Prepare(raw)
+	testConfigErr(t, warns, errs)
+}
+
+func TestConfigPrepare_uploadOwner(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         map[string]interface{}
+		expectErr      bool
+		expectedMethod string
+		expectedMode   int64
+	}{
+		{"defaults", map[string]interface{}{}, false, UploadOwnerChown, 0},
+		{"no shell", map[string]interface{}{"no_shell": true, "pause_command": []string{"/pause"}}, false, UploadOwnerArchive, 0},
+		{"chown without shell", map[string]interface{}{"no_shell": true, "pause_command": []string{"/pause"}, "upload_owner_method": "chown"}, true, "", 0},
+		{"archive", map[string]interface{}{"upload_owner_method": "archive"}, false, UploadOwnerArchive, 0},
+		{"invalid method", map[string]interface{}{"upload_owner_method": "setfacl"}, true, "", 0},
+		{"user", map[string]interface{}{"upload_owner": "app"}, false, UploadOwnerChown, 0},
+		{"user and group", map[string]interface{}{"upload_owner": "1000:1000"}, false, UploadOwnerChown, 0},
+		{"missing group", map[string]interface{}{"upload_owner": "app:"}, true, "", 0},
+		{"missing user", map[string]interface{}{"upload_owner": ":app"}, true, "", 0},
+		{"too many parts", map[string]interface{}{"upload_owner": "a:b:c"}, true, "", 0},
+		{"mode", map[string]interface{}{"upload_mode": "0644"}, false, UploadOwnerChown, 0644},
+		{"setuid mode", map[string]interface{}{"upload_mode": "4755"}, false, UploadOwnerChown, 04755},
+		{"invalid mode", map[string]interface{}{"upload_mode": "rw-r--r--"}, true, "", 0},
+		{"out of range mode", map[string]interface{}{"upload_mode": "17777"}, true, "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			for k, v := range tt.config {
+				raw[k] = v
+			}
+
+			var c Config
+			warns, errs := c.Prepare(raw)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+
+			if c.UploadOwnerMethod != tt.expectedMethod {
+				t.Errorf("expected method %q, got %q", tt.expectedMethod, c.UploadOwnerMethod)
+			}
+			if c.uploadMode != tt.expectedMode {
+				t.Errorf("expected mode %o, got %o", tt.expectedMode, c.uploadMode)
+			}
+			if _, ok := tt.config["upload_owner"]; ok && !c.FixUploadOwner {
+				t.Errorf("upload_owner should imply fix_upload_owner")
+			}
+		})
+	}
+}
+
+func TestConfigPrepare_exec(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{"defaults", map[string]interface{}{}, false},
+		{"workdir", map[string]interface{}{"exec_workdir": "/app"}, false},
+		{"relative workdir", map[string]interface{}{"exec_workdir": "app"}, true},
+		{"relative workdir on windows", map[string]interface{}{"exec_workdir": "C:\\app", "windows_container": true}, false},
+		{"env", map[string]interface{}{"exec_env": map[string]string{"FOO": "bar", "EMPTY": ""}}, false},
+		{"invalid env name", map[string]interface{}{"exec_env": map[string]string{"FOO=BAR": "baz"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			for k, v := range tt.config {
+				raw[k] = v
+			}
+
+			var c Config
+			warns, errs := c.Prepare(raw)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+		})
+	}
+}
+
+func TestConfigPrepare_changes(t *testing.T) {
+	tests := []struct {
+		name            string
+		config          map[string]interface{}
+		expectedChanges []string
+		expectErr       bool
+	}{
+		{
+			"changes",
+			map[string]interface{}{"changes": []string{"USER nginx", "EXPOSE 8080"}},
+			[]string{"USER nginx", "EXPOSE 8080"},
+			false,
+		},
+		{
+			"unsupported instruction",
+			map[string]interface{}{"changes": []string{"RUN apt-get update"}},
+			nil,
+			true,
+		},
+		{
+			"missing value",
+			map[string]interface{}{"changes": []string{"ENV FOO"}},
+			nil,
+			true,
+		},
+		{
+			"image config before changes",
+			map[string]interface{}{
+				"image_config": map[string]interface{}{
+					"env":           map[string]string{"FOO": "bar"},
+					"exposed_ports": []string{"8080"},
+				},
+				"changes": []string{"USER nginx"},
+			},
+			[]string{`ENV FOO="bar"`, "EXPOSE 8080", "USER nginx"},
+			false,
+		},
+		{
+			"labels",
+			map[string]interface{}{
+				"labels":  map[string]string{"version": "1.0"},
+				"changes": []string{`LABEL version="1.1"`},
+			},
+			[]string{`LABEL "version"="1.0"`, `LABEL version="1.1"`},
+			false,
+		},
+		{
+			"invalid exposed port",
+			map[string]interface{}{
+				"image_config": map[string]interface{}{"exposed_ports": []string{"http"}},
+			},
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			for k, v := range tt.config {
+				raw[k] = v
+			}
+
+			var c Config
+			warns, errs := c.Prepare(raw)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+
+			if !reflect.DeepEqual(c.Changes, tt.expectedChanges) {
+				t.Fatalf("bad: %#v", c.Changes)
+			}
+		})
+	}
+}
+
+func TestConfigPrepare_ociAnnotations(t *testing.T) {
+	raw := testConfig()
+	raw["oci_annotations"] = true
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+
+	delete(raw, "export_path")
+	raw["commit"] = true
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+}
+
+func TestConfigPrepare_commitStop(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     map[string]interface{}
+		expectStop string
+		expectErr  bool
+	}{
+		{"defaults", map[string]interface{}{}, CommitStopNone, false},
+		{"windows defaults", map[string]interface{}{"windows_container": true}, CommitStopStop, false},
+		{"pause", map[string]interface{}{"commit_stop": "pause"}, CommitStopPause, false},
+		{"stop with options", map[string]interface{}{"commit_stop": "stop", "commit_stop_signal": "SIGINT", "commit_stop_timeout": "30s"}, CommitStopStop, false},
+		{"invalid", map[string]interface{}{"commit_stop": "freeze"}, "", true},
+		{"signal without stop", map[string]interface{}{"commit_stop_signal": "SIGINT"}, "", true},
+		{"invalid signal", map[string]interface{}{"commit_stop": "stop", "commit_stop_signal": "SIG INT"}, "", true},
+		{"commit running windows container", map[string]interface{}{"windows_container": true, "commit_stop": "none", "commit": true, "export_path": ""}, "", true},
+		{"pre-commit command", map[string]interface{}{"pre_commit_command": "sync; apt-get clean"}, CommitStopNone, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			for k, v := range tt.config {
+				raw[k] = v
+			}
+
+			var c Config
+			warns, errs := c.Prepare(raw)
+			if tt.expectErr {
+				testConfigErr(t, warns, errs)
+				return
+			}
+			testConfigOk(t, warns, errs)
+
+			if c.CommitStop != tt.expectStop {
+				t.Fatalf("expected commit_stop %q, got %q", tt.expectStop, c.CommitStop)
+			}
+		})
+	}
+}
+
+func TestConfigPrepare_cleanup(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]interface{}
+		expectErr bool
+	}{
+		{"paths", map[string]interface{}{"cleanup_paths": []string{"/tmp/*", "~/.cache"}}, false},
+		{"presets", map[string]interface{}{"cleanup_presets": []string{"apt", "pip", "npm"}}, false},
+		{"packer-files preset", map[string]interface{}{"cleanup_presets": []string{"packer-files"}}, true},
+		{"unknown preset", map[string]interface{}{"cleanup_presets": []string{"brew"}}, true},
+		{"relative path", map[string]interface{}{"cleanup_paths": []string{"tmp/*"}}, true},
+		{"root", map[string]interface{}{"cleanup_paths": []string{"/"}}, true},
+		{"top-level glob", map[string]interface{}{"cleanup_paths": []string{"/*"}}, true},
+		{"parent of home", map[string]interface{}{"cleanup_paths": []string{"~/.."}}, true},
+		{"no shell", map[string]interface{}{"cleanup_paths": []string{"/tmp/*"}, "no_shell": true, "pause_command": []string{"/pause"}}, true},
+		{"windows", map[string]interface{}{"cleanup_paths": []string{"/tmp/*"}, "windows_container": true}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := testConfig()
+			for k, v := range tt.config {
+				raw[k] = v
+			}
+
+			var c Config
+			warns, errs := c.Prepare(raw)
+			if tt.expectErr {
+				if errs == nil {
+					t.Fatal("should error")
+				}
+				return
+			}
+			testConfigOk(t, warns, errs)
+		})
+	}
+}
+
+func TestConfigPrepare_squash(t *testing.T) {
+	for _, key := range []string{"squash", "squash_all"} {
+		raw := testConfig()
+		raw[key] = true
+		var c Config
+		warns, errs := c.Prepare(raw)
+		testConfigErr(t, warns, errs)
+
+		delete(raw, "export_path")
+		raw["commit"] = true
+		c = Config{}
+		warns, errs = c.Prepare(raw)
+		testConfigOk(t, warns, errs)
+	}
+}
+
+func TestConfigPrepare_exportCompression(t *testing.T) {
+	raw := testConfig()
+	raw["export_path"] = "rootfs.tar.zst"
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.ExportCompression != ExportCompressionZstd {
+		t.Fatalf("should infer the compression from the extension: %s", c.ExportCompression)
+	}
+
+	raw["export_compression"] = "gzip"
+	raw["export_compression_level"] = 9
+	raw["export_checksums"] = []string{"sha256", "sha512"}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.ExportCompression != ExportCompressionGzip {
+		t.Fatalf("bad: %s", c.ExportCompression)
+	}
+
+	for key, value := range map[string]interface{}{
+		"export_compression":       "bzip2",
+		"export_compression_level": 23,
+		"export_checksums":         []string{"md5"},
+	} {
+		raw := testConfig()
+		raw["export_compression"] = "zstd"
+		raw[key] = value
+		c = Config{}
+		warns, errs = c.Prepare(raw)
+		testConfigErr(t, warns, errs)
+	}
+
+	raw = testConfig()
+	delete(raw, "export_path")
+	raw["commit"] = true
+	raw["export_checksums"] = []string{"sha256"}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+}
+
+func TestConfigPrepare_exportFormat(t *testing.T) {
+	raw := testConfig()
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.ExportFormat != ExportFormatRootfs {
+		t.Fatalf("bad: %s", c.ExportFormat)
+	}
+
+	raw["export_format"] = "oci-archive"
+	raw["export_path"] = "image.tar.gz"
+	raw["oci_annotations"] = true
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.ExportCompression != ExportCompressionGzip {
+		t.Fatalf("bad: %s", c.ExportCompression)
+	}
+
+	raw["export_format"] = "oci-layout"
+	raw["export_path"] = t.TempDir()
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.ExportCompression != ExportCompressionNone {
+		t.Fatalf("bad: %s", c.ExportCompression)
+	}
+
+	// Existing layouts are not overwritten
+	if err := os.WriteFile(filepath.Join(raw["export_path"].(string), "index.json"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+
+	raw["export_path"] = t.TempDir()
+	raw["export_checksums"] = []string{"sha256"}
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+
+	raw = testConfig()
+	raw["export_format"] = "tarball"
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+
+	raw = testConfig()
+	raw["oci_annotations"] = true
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+}
+
+func TestConfigPrepare_exportFilesystem(t *testing.T) {
+	raw := testConfig()
+	raw["export_format"] = "ext4"
+	raw["export_fs_size"] = "512M"
+	raw["export_fs_label"] = "rootfs"
+	raw["export_fs_uuid"] = "2b8b4c3e-9f4e-4d3c-8f6b-6a1e0c9d7f21"
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.exportFSSize != 512<<20 || c.ExportFSHeadroom != 20 {
+		t.Fatalf("bad: %d, %d", c.exportFSSize, c.ExportFSHeadroom)
+	}
+	if c.exportFSUUID[0] != 0x2b || c.exportFSUUID[15] != 0x21 {
+		t.Fatalf("bad: %x", c.exportFSUUID)
+	}
+
+	// The UUID is random by default.
+	delete(raw, "export_fs_uuid")
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+	if c.exportFSUUID == [16]byte{} {
+		t.Fatal("should have a UUID")
+	}
+
+	for k, v := range map[string]interface{}{
+		"export_fs_size":     "4X",
+		"export_fs_headroom": -1,
+		"export_fs_label":    "a label longer than 16 bytes",
+		"export_fs_uuid":     "2b8b4c3e9f4e4d3c8f6b6a1e0c9d7f21",
+	} {
+		raw := testConfig()
+		raw["export_format"] = "ext4"
+		raw[k] = v
+		c = Config{}
+		warns, errs = c.Prepare(raw)
+		testConfigErr(t, warns, errs)
+	}
+
+	// The options are specific to ext4.
+	raw = testConfig()
+	raw["export_format"] = "squashfs"
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+
+	raw["export_fs_label"] = "rootfs"
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+}
+
+func TestConfigPrepare_exportLXC(t *testing.T) {
+	raw := testConfig()
+	raw["export_format"] = "lxc"
+	raw["platform"] = "linux/arm/v7"
+	var c Config
+	warns, errs := c.Prepare(raw)
+	testConfigOk(t, warns, errs)
+
+	raw["platform"] = "linux/sparc"
+	c = Config{}
+	warns, errs = c.Prepare(raw)
+	testConfigErr(t, warns, errs)
+}
@@ -2,20 +2,36 @@
 // SPDX-License-Identifier: MPL-2.0
 
 //go:generate packer-sdc struct-markdown
-//go:generate packer-sdc mapstructure-to-hcl2 -type DockerfileBootstrapConfig
+//go:generate packer-sdc mapstructure-to-hcl2 -type DockerfileBootstrapConfig,BuildSecret,BuildContextGit
 
 package docker
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 )
 
+const (
+	// BuildCleanupAlways removes the bootstrap image once the build is done
+	BuildCleanupAlways = "always"
+	// BuildCleanupOnSuccess removes the bootstrap image only if the build
+	// succeeded, so it can be inspected otherwise
+	BuildCleanupOnSuccess = "on_success"
+	// BuildCleanupNever keeps the bootstrap image
+	BuildCleanupNever = "never"
+)
+
 // DockerfileBootstrapConfig is the configuration for bootstrapping a docker
 // builder with a user-provided Dockerfile.
 //
@@ -27,12 +43,43 @@ type DockerfileBootstrapConfig struct {
 	// If set, the builder will invoke `docker build` on it, and use the
 	// produced image to continue the build afterwards.
 	//
-	// Note: Mutually exclusive with "image"
+	// Note: Mutually exclusive with "image", and with "content", one of
+	// "path" or "content" is required.
 	DockerfilePath string `mapstructure:"path" required:"true"`
+	// The contents of the Dockerfile to use for building the base image,
+	// instead of reading it from `path`. Templates are interpolated in it
+	// like in any other option.
+	//
+	// ```hcl
+	// content = <<-EOF
+	//   FROM ${local.base_image}
+	//   RUN apk add --no-cache curl
+	// EOF
+	// ```
+	Content string `mapstructure:"content" required:"false"`
 	// Directory to invoke `docker build` from
 	//
 	// Defaults to the directory from which we invoke packer.
 	BuildDir string `mapstructure:"build_dir"`
+	// A tar archive, optionally gzip-compressed, holding the build context.
+	// It is extracted to a temporary directory the build is run from.
+	//
+	// Note: Mutually exclusive with "build_dir" and "context_git".
+	ContextTarball string `mapstructure:"context_tarball" required:"false"`
+	// A git checkout to use the files of a given revision from as build
+	// context. The files are extracted to a temporary directory with
+	// `git archive`, so uncommitted changes, ignored files and submodules
+	// are not part of the context.
+	//
+	// ```hcl
+	// context_git {
+	//   path = "../app"
+	//   ref  = "v1.2.0"
+	// }
+	// ```
+	//
+	// Note: Mutually exclusive with "build_dir" and "context_tarball".
+	ContextGit BuildContextGit `mapstructure:"context_git" required:"false"`
 
 	// A mapping of additional build args to provide. The key of
 	// the object is the argument name, the value is the argument value.
@@ -51,6 +98,90 @@ type DockerfileBootstrapConfig struct {
 	// can take a significant amount of time, while once compressed, this
 	// can make builds faster, at the price of extra CPU resources.
 	Compress bool `mapstructure:"compress"`
+	// Only display the output of the build if it fails. By default, the
+	// output is displayed as the build goes.
+	Quiet bool `mapstructure:"quiet" required:"false"`
+
+	// The stage of a multi-stage Dockerfile to build, instead of the last
+	// one.
+	Target string `mapstructure:"target" required:"false"`
+	// Secrets exposed to the build, for use with `RUN --mount=type=secret`
+	// instructions. Requires BuildKit.
+	//
+	// ```hcl
+	// secrets {
+	//   id  = "npmrc"
+	//   src = "${env("HOME")}/.npmrc"
+	// }
+	// ```
+	Secrets []BuildSecret `mapstructure:"secrets" required:"false"`
+	// SSH agent sockets or keys exposed to the build, for use with
+	// `RUN --mount=type=ssh` instructions, in the format of the `--ssh` option
+	// of `docker build`: `default` forwards the agent of `SSH_AUTH_SOCK`, and
+	// `id=path` exposes the given socket or keys. Requires BuildKit.
+	SSH []string `mapstructure:"ssh" required:"false"`
+	// External cache sources, in the format of the `--cache-from` option of
+	// `docker build`, e.g. `type=registry,ref=registry.example.com/app:cache`.
+	CacheFrom []string `mapstructure:"cache_from" required:"false"`
+	// Cache export destinations, in the format of the `--cache-to` option of
+	// `docker buildx build`. Only the `inline` type is supported by the
+	// default builder, other types need a `builder` using another driver.
+	CacheTo []string `mapstructure:"cache_to" required:"false"`
+	// Do not use the cache when building the image.
+	NoCache bool `mapstructure:"no_cache" required:"false"`
+	// Labels to set on the built image.
+	Labels map[string]string `mapstructure:"labels" required:"false"`
+	// The networking mode for the `RUN` instructions of the build, e.g.
+	// `host` or `none`.
+	Network string `mapstructure:"network" required:"false"`
+	// Additional named build contexts, the key is the name of the context,
+	// and the value a local directory, an image with the `docker-image://`
+	// prefix, or an URL. Requires BuildKit.
+	//
+	// ```hcl
+	// build_contexts = {
+	//   shared = "../shared"
+	//   alpine = "docker-image://alpine:3.20"
+	// }
+	// ```
+	BuildContexts map[string]string `mapstructure:"build_contexts" required:"false"`
+	// The buildx builder instance to use. If set, the image is built with
+	// `docker buildx build --builder <builder> --load` rather than
+	// `docker build`, and loaded in the image store of the docker daemon.
+	Builder string `mapstructure:"builder" required:"false"`
+	// Tags to give to the bootstrap image, in the `name:tag` format.
+	Tags []string `mapstructure:"tags" required:"false"`
+	// What to do with the bootstrap image once the build is done: `always`
+	// remove it, only remove it `on_success`, or `never` remove it.
+	//
+	// When the container is committed, the image built from it depends on
+	// the bootstrap image, which is then only untagged, its layers are kept.
+	//
+	// Defaults to `always`, or `never` with `build_only`.
+	Cleanup string `mapstructure:"cleanup" required:"false"`
+
+	// baseImages are the images the Dockerfile is based on, if it could be
+	// parsed.
+	baseImages []string
+}
+
+// BuildContextGit is a revision of a local git repository used as build
+// context.
+type BuildContextGit struct {
+	// The path to the git checkout.
+	Path string `mapstructure:"path" required:"true"`
+	// The revision to use the files of, like a branch, a tag or a commit.
+	// Defaults to `HEAD`.
+	Ref string `mapstructure:"ref" required:"false"`
+}
+
+// BuildSecret is a secret file exposed to a `docker build`.
+type BuildSecret struct {
+	// The ID of the secret, as referenced by the `id` of the secret mounts
+	// in the Dockerfile.
+	ID string `mapstructure:"id" required:"true"`
+	// The path to the file holding the secret.
+	Src string `mapstructure:"src" required:"true"`
 }
 
 func (c *DockerfileBootstrapConfig) Prepare() ([]string, error) {
@@ -60,39 +191,164 @@ func (c *DockerfileBootstrapConfig) Prepare() ([]string, error) {
 		return nil, nil
 	}
 
-	if c.DockerfilePath == "" {
-		return nil, fmt.Errorf("`path` is required for bootstrapping a build with `docker build`")
+	if c.DockerfilePath == "" && c.Content == "" {
+		return nil, fmt.Errorf("`path` or `content` is required for bootstrapping a build with `docker build`")
+	}
+
+	if c.DockerfilePath != "" && c.Content != "" {
+		return nil, fmt.Errorf("`path` and `content` cannot both be specified")
 	}
 
-	if c.BuildDir == "" {
+	contexts := 0
+	for _, set := range []bool{c.BuildDir != "", c.ContextTarball != "", c.ContextGit != BuildContextGit{}} {
+		if set {
+			contexts++
+		}
+	}
+	if contexts > 1 {
+		return nil, fmt.Errorf("only one of `build_dir`, `context_tarball` and `context_git` can be specified")
+	}
+
+	if contexts == 0 {
 		c.BuildDir = "."
 	}
 
-	st, err := os.Stat(c.DockerfilePath)
+	if c.DockerfilePath != "" {
+		st, err := os.Stat(c.DockerfilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file %q: %s", c.DockerfilePath, err)
+		}
+
+		if !st.Mode().IsRegular() {
+			return nil, fmt.Errorf("dockerfile %q is not a regular file", c.DockerfilePath)
+		}
+
+		dockerfileAbsPath, err := filepath.Abs(c.DockerfilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute absolute path for %q: %s", c.DockerfilePath, err)
+		}
+
+		c.DockerfilePath = dockerfileAbsPath
+	}
+
+	switch {
+	case c.BuildDir != "":
+		st, err := os.Stat(c.BuildDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat build directory %q: %s", c.BuildDir, err)
+		}
+		if !st.IsDir() {
+			return nil, fmt.Errorf("specified build_dir %q is not a directory", c.BuildDir)
+		}
+	case c.ContextTarball != "":
+		st, err := os.Stat(c.ContextTarball)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat context tarball %q: %s", c.ContextTarball, err)
+		}
+		if !st.Mode().IsRegular() {
+			return nil, fmt.Errorf("context tarball %q is not a regular file", c.ContextTarball)
+		}
+		if c.ContextTarball, err = filepath.Abs(c.ContextTarball); err != nil {
+			return nil, fmt.Errorf("failed to compute absolute path for %q: %s", c.ContextTarball, err)
+		}
+	default:
+		if err := c.ContextGit.Prepare(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch c.Cleanup {
+	case "":
+		c.Cleanup = BuildCleanupAlways
+	case BuildCleanupAlways, BuildCleanupOnSuccess, BuildCleanupNever:
+	default:
+		return nil, fmt.Errorf("invalid cleanup %q, expected %q, %q or %q", c.Cleanup, BuildCleanupAlways, BuildCleanupOnSuccess, BuildCleanupNever)
+	}
+
+	warnings, err := c.lintDockerfile()
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat file %q: %s", c.DockerfilePath, err)
+		return nil, err
 	}
 
-	if !st.Mode().IsRegular() {
-		return nil, fmt.Errorf("dockerfile %q is not a regular file", c.DockerfilePath)
+	for i, secret := range c.Secrets {
+		if secret.ID == "" {
+			return nil, fmt.Errorf("`id` is required for build secret %d", i)
+		}
+		if secret.Src == "" {
+			return nil, fmt.Errorf("`src` is required for build secret %q", secret.ID)
+		}
+		if _, err := os.Stat(secret.Src); err != nil {
+			return nil, fmt.Errorf("failed to stat build secret %q: %s", secret.ID, err)
+		}
+		src, err := filepath.Abs(secret.Src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute absolute path for %q: %s", secret.Src, err)
+		}
+		c.Secrets[i].Src = src
 	}
 
-	dockerfileAbsPath, err := filepath.Abs(c.DockerfilePath)
+	for name := range c.BuildContexts {
+		if name == "" || strings.Contains(name, "=") {
+			return nil, fmt.Errorf("invalid build context name %q", name)
+		}
+	}
+
+	return warnings, nil
+}
+
+// lintDockerfile parses the Dockerfile to check the build arguments and the
+// target against it, and find the base images of the build.
+func (c *DockerfileBootstrapConfig) lintDockerfile() ([]string, error) {
+	var r io.Reader = strings.NewReader(c.Content)
+	if c.Content == "" {
+		f, err := os.Open(c.DockerfilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dockerfile %q: %s", c.DockerfilePath, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	df, err := parseDockerfile(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to compute absolute path for %q: %s", c.DockerfilePath, err)
+		return nil, fmt.Errorf("invalid dockerfile: %s", err)
 	}
+	if df == nil {
+		log.Printf("Dockerfile uses a custom frontend, skipping validation")
+		return nil, nil
+	}
+
+	c.baseImages = df.baseImages(c.Arguments)
+	return df.lint(c.Arguments, c.Target)
+}
 
-	c.DockerfilePath = dockerfileAbsPath
+// Prepare validates the git context, and ensures the revision exists in the
+// repository.
+func (c *BuildContextGit) Prepare() error {
+	if c.Path == "" {
+		return fmt.Errorf("`path` is required for `context_git`")
+	}
+	if c.Ref == "" {
+		c.Ref = "HEAD"
+	}
 
-	st, err = os.Stat(c.BuildDir)
+	st, err := os.Stat(c.Path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to stat build directory %q: %s", c.BuildDir, err)
+		return fmt.Errorf("failed to stat git context %q: %s", c.Path, err)
 	}
 	if !st.IsDir() {
-		return nil, fmt.Errorf("specified build_dir %q is not a directory", c.BuildDir)
+		return fmt.Errorf("git context %q is not a directory", c.Path)
+	}
+	if c.Path, err = filepath.Abs(c.Path); err != nil {
+		return fmt.Errorf("failed to compute absolute path for %q: %s", c.Path, err)
+	}
+
+	cmd := exec.Command("git", "-C", c.Path, "rev-parse", "--verify", "--quiet", c.Ref+"^{commit}")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git context %q has no revision %q: %s %s", c.Path, c.Ref, err, bytes.TrimSpace(output))
 	}
 
-	return nil, nil
+	return nil
 }
 
 // BuildArgs returns the list of arguments to pass to docker build.
@@ -111,22 +367,78 @@ func (c DockerfileBootstrapConfig) BuildArgs() []string {
 		retArgs = append(retArgs, "--compress")
 	}
 
+	for _, tag := range c.Tags {
+		retArgs = append(retArgs, "--tag", tag)
+	}
+
 	// Loops through map of build arguments to add to build command
-	for key, value := range c.Arguments {
-		arg := key + "=" + value
+	for _, key := range sortedKeys(c.Arguments) {
+		arg := key + "=" + c.Arguments[key]
 		retArgs = append(retArgs, "--build-arg", arg)
 	}
 
+	if c.Target != "" {
+		retArgs = append(retArgs, "--target", c.Target)
+	}
+
+	for _, secret := range c.Secrets {
+		retArgs = append(retArgs, "--secret", fmt.Sprintf("id=%s,src=%s", secret.ID, secret.Src))
+	}
+
+	for _, ssh := range c.SSH {
+		retArgs = append(retArgs, "--ssh", ssh)
+	}
+
+	for _, cache := range c.CacheFrom {
+		retArgs = append(retArgs, "--cache-from", cache)
+	}
+
+	for _, cache := range c.CacheTo {
+		retArgs = append(retArgs, "--cache-to", cache)
+	}
+
+	if c.NoCache {
+		retArgs = append(retArgs, "--no-cache")
+	}
+
+	for _, key := range sortedKeys(c.Labels) {
+		retArgs = append(retArgs, "--label", key+"="+c.Labels[key])
+	}
+
+	if c.Network != "" {
+		retArgs = append(retArgs, "--network", c.Network)
+	}
+
+	for _, name := range sortedKeys(c.BuildContexts) {
+		retArgs = append(retArgs, "--build-context", name+"="+c.BuildContexts[name])
+	}
+
 	return append(retArgs, c.BuildDir)
 }
 
-// IsDefault returns whether the DockerfileBootstrapConfig is the empty one or not
-func (c DockerfileBootstrapConfig) IsDefault() bool {
-	// We can ignore arguments for the comparison since a map can be either
-	// nil or empty, and should be considered equal in either case.
-	cmpOpts := []cmp.Option{
-		cmpopts.IgnoreFields(DockerfileBootstrapConfig{}, "Arguments"),
+// BuildCommand returns the docker subcommand building the image, the
+// arguments from BuildArgs come after it.
+func (c DockerfileBootstrapConfig) BuildCommand() []string {
+	if c.Builder != "" {
+		return []string{"buildx", "build", "--builder", c.Builder, "--load"}
 	}
+	return []string{"build"}
+}
 
-	return cmp.Equal(c, DockerfileBootstrapConfig{}, cmpOpts...) && len(c.Arguments) == 0
+// sortedKeys returns the keys of the map in a stable order, so that the
+// commands built from it are reproducible.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// IsDefault returns whether the DockerfileBootstrapConfig is the empty one or not
+func (c DockerfileBootstrapConfig) IsDefault() bool {
+	// Maps and slices can be either nil or empty, and should be considered
+	// equal in either case.
+	return cmp.Equal(c, DockerfileBootstrapConfig{}, cmpopts.EquateEmpty(), cmpopts.IgnoreUnexported(DockerfileBootstrapConfig{}))
 }
@@ -7,14 +7,80 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+// FlatBuildContextGit is an auto-generated flat version of BuildContextGit.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatBuildContextGit struct {
+	Path *string `mapstructure:"path" required:"true" cty:"path" hcl:"path"`
+	Ref  *string `mapstructure:"ref" required:"false" cty:"ref" hcl:"ref"`
+}
+
+// FlatMapstructure returns a new FlatBuildContextGit.
+// FlatBuildContextGit is an auto-generated flat version of BuildContextGit.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*BuildContextGit) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatBuildContextGit)
+}
+
+// HCL2Spec returns the hcl spec of a BuildContextGit.
+// This spec is used by HCL to read the fields of BuildContextGit.
+// The decoded values from this spec will then be applied to a FlatBuildContextGit.
+func (*FlatBuildContextGit) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"path": &hcldec.AttrSpec{Name: "path", Type: cty.String, Required: false},
+		"ref":  &hcldec.AttrSpec{Name: "ref", Type: cty.String, Required: false},
+	}
+	return s
+}
+
+// FlatBuildSecret is an auto-generated flat version of BuildSecret.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatBuildSecret struct {
+	ID  *string `mapstructure:"id" required:"true" cty:"id" hcl:"id"`
+	Src *string `mapstructure:"src" required:"true" cty:"src" hcl:"src"`
+}
+
+// FlatMapstructure returns a new FlatBuildSecret.
+// FlatBuildSecret is an auto-generated flat version of BuildSecret.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*BuildSecret) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatBuildSecret)
+}
+
+// HCL2Spec returns the hcl spec of a BuildSecret.
+// This spec is used by HCL to read the fields of BuildSecret.
+// The decoded values from this spec will then be applied to a FlatBuildSecret.
+func (*FlatBuildSecret) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"id":  &hcldec.AttrSpec{Name: "id", Type: cty.String, Required: false},
+		"src": &hcldec.AttrSpec{Name: "src", Type: cty.String, Required: false},
+	}
+	return s
+}
+
 // FlatDockerfileBootstrapConfig is an auto-generated flat version of DockerfileBootstrapConfig.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatDockerfileBootstrapConfig struct {
-	DockerfilePath *string           `mapstructure:"path" required:"true" cty:"path" hcl:"path"`
-	BuildDir       *string           `mapstructure:"build_dir" cty:"build_dir" hcl:"build_dir"`
-	Arguments      map[string]string `mapstructure:"arguments" required:"false" cty:"arguments" hcl:"arguments"`
-	Pull           *bool             `mapstructure:"pull" cty:"pull" hcl:"pull"`
-	Compress       *bool             `mapstructure:"compress" cty:"compress" hcl:"compress"`
+	DockerfilePath *string              `mapstructure:"path" required:"true" cty:"path" hcl:"path"`
+	Content        *string              `mapstructure:"content" required:"false" cty:"content" hcl:"content"`
+	BuildDir       *string              `mapstructure:"build_dir" cty:"build_dir" hcl:"build_dir"`
+	ContextTarball *string              `mapstructure:"context_tarball" required:"false" cty:"context_tarball" hcl:"context_tarball"`
+	ContextGit     *FlatBuildContextGit `mapstructure:"context_git" required:"false" cty:"context_git" hcl:"context_git"`
+	Arguments      map[string]string    `mapstructure:"arguments" required:"false" cty:"arguments" hcl:"arguments"`
+	Pull           *bool                `mapstructure:"pull" cty:"pull" hcl:"pull"`
+	Compress       *bool                `mapstructure:"compress" cty:"compress" hcl:"compress"`
+	Quiet          *bool                `mapstructure:"quiet" required:"false" cty:"quiet" hcl:"quiet"`
+	Target         *string              `mapstructure:"target" required:"false" cty:"target" hcl:"target"`
+	Secrets        []FlatBuildSecret    `mapstructure:"secrets" required:"false" cty:"secrets" hcl:"secrets"`
+	SSH            []string             `mapstructure:"ssh" required:"false" cty:"ssh" hcl:"ssh"`
+	CacheFrom      []string             `mapstructure:"cache_from" required:"false" cty:"cache_from" hcl:"cache_from"`
+	CacheTo        []string             `mapstructure:"cache_to" required:"false" cty:"cache_to" hcl:"cache_to"`
+	NoCache        *bool                `mapstructure:"no_cache" required:"false" cty:"no_cache" hcl:"no_cache"`
+	Labels         map[string]string    `mapstructure:"labels" required:"false" cty:"labels" hcl:"labels"`
+	Network        *string              `mapstructure:"network" required:"false" cty:"network" hcl:"network"`
+	BuildContexts  map[string]string    `mapstructure:"build_contexts" required:"false" cty:"build_contexts" hcl:"build_contexts"`
+	Builder        *string              `mapstructure:"builder" required:"false" cty:"builder" hcl:"builder"`
+	Tags           []string             `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
+	Cleanup        *string              `mapstructure:"cleanup" required:"false" cty:"cleanup" hcl:"cleanup"`
 }
 
 // FlatMapstructure returns a new FlatDockerfileBootstrapConfig.
@@ -29,11 +95,27 @@ func (*DockerfileBootstrapConfig) FlatMapstructure() interface{ HCL2Spec() map[s
 // The decoded values from this spec will then be applied to a FlatDockerfileBootstrapConfig.
 func (*FlatDockerfileBootstrapConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"path":      &hcldec.AttrSpec{Name: "path", Type: cty.String, Required: false},
-		"build_dir": &hcldec.AttrSpec{Name: "build_dir", Type: cty.String, Required: false},
-		"arguments": &hcldec.AttrSpec{Name: "arguments", Type: cty.Map(cty.String), Required: false},
-		"pull":      &hcldec.AttrSpec{Name: "pull", Type: cty.Bool, Required: false},
-		"compress":  &hcldec.AttrSpec{Name: "compress", Type: cty.Bool, Required: false},
+		"path":            &hcldec.AttrSpec{Name: "path", Type: cty.String, Required: false},
+		"content":         &hcldec.AttrSpec{Name: "content", Type: cty.String, Required: false},
+		"build_dir":       &hcldec.AttrSpec{Name: "build_dir", Type: cty.String, Required: false},
+		"context_tarball": &hcldec.AttrSpec{Name: "context_tarball", Type: cty.String, Required: false},
+		"context_git":     &hcldec.BlockSpec{TypeName: "context_git", Nested: hcldec.ObjectSpec((*FlatBuildContextGit)(nil).HCL2Spec())},
+		"arguments":       &hcldec.AttrSpec{Name: "arguments", Type: cty.Map(cty.String), Required: false},
+		"pull":            &hcldec.AttrSpec{Name: "pull", Type: cty.Bool, Required: false},
+		"compress":        &hcldec.AttrSpec{Name: "compress", Type: cty.Bool, Required: false},
+		"quiet":           &hcldec.AttrSpec{Name: "quiet", Type: cty.Bool, Required: false},
+		"target":          &hcldec.AttrSpec{Name: "target", Type: cty.String, Required: false},
+		"secrets":         &hcldec.BlockListSpec{TypeName: "secrets", Nested: hcldec.ObjectSpec((*FlatBuildSecret)(nil).HCL2Spec())},
+		"ssh":             &hcldec.AttrSpec{Name: "ssh", Type: cty.List(cty.String), Required: false},
+		"cache_from":      &hcldec.AttrSpec{Name: "cache_from", Type: cty.List(cty.String), Required: false},
+		"cache_to":        &hcldec.AttrSpec{Name: "cache_to", Type: cty.List(cty.String), Required: false},
+		"no_cache":        &hcldec.AttrSpec{Name: "no_cache", Type: cty.Bool, Required: false},
+		"labels":          &hcldec.AttrSpec{Name: "labels", Type: cty.Map(cty.String), Required: false},
+		"network":         &hcldec.AttrSpec{Name: "network", Type: cty.String, Required: false},
+		"build_contexts":  &hcldec.AttrSpec{Name: "build_contexts", Type: cty.Map(cty.String), Required: false},
+		"builder":         &hcldec.AttrSpec{Name: "builder", Type: cty.String, Required: false},
+		"tags":            &hcldec.AttrSpec{Name: "tags", Type: cty.List(cty.String), Required: false},
+		"cleanup":         &hcldec.AttrSpec{Name: "cleanup", Type: cty.String, Required: false},
 	}
 	return s
 }
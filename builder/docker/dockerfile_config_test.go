@@ -4,6 +4,8 @@
 package docker
 
 import (
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
@@ -48,6 +50,21 @@ func TestBuildConfigIsDefault(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"empty lists - should be default",
+			DockerfileBootstrapConfig{
+				Secrets:   []BuildSecret{},
+				CacheFrom: []string{},
+			},
+			true,
+		},
+		{
+			"target set - should not be default",
+			DockerfileBootstrapConfig{
+				Target: "build",
+			},
+			false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -60,3 +77,84 @@ func TestBuildConfigIsDefault(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildConfigBuildArgs(t *testing.T) {
+	c := DockerfileBootstrapConfig{
+		DockerfilePath: "/src/Dockerfile",
+		BuildDir:       "/src",
+		Arguments:      map[string]string{"VERSION": "1.0", "ARCH": "amd64"},
+		Pull:           config.TriFalse,
+		Tags:           []string{"app:bootstrap"},
+		Target:         "release",
+		Secrets: []BuildSecret{
+			{ID: "npmrc", Src: "/home/user/.npmrc"},
+		},
+		SSH:           []string{"default"},
+		CacheFrom:     []string{"type=registry,ref=example.com/app:cache"},
+		CacheTo:       []string{"type=inline"},
+		NoCache:       true,
+		Labels:        map[string]string{"org.opencontainers.image.title": "app"},
+		Network:       "host",
+		BuildContexts: map[string]string{"shared": "/shared", "base": "docker-image://alpine"},
+	}
+
+	expected := []string{
+		"-f", "/src/Dockerfile",
+		"--tag", "app:bootstrap",
+		"--build-arg", "ARCH=amd64",
+		"--build-arg", "VERSION=1.0",
+		"--target", "release",
+		"--secret", "id=npmrc,src=/home/user/.npmrc",
+		"--ssh", "default",
+		"--cache-from", "type=registry,ref=example.com/app:cache",
+		"--cache-to", "type=inline",
+		"--no-cache",
+		"--label", "org.opencontainers.image.title=app",
+		"--network", "host",
+		"--build-context", "base=docker-image://alpine",
+		"--build-context", "shared=/shared",
+		"/src",
+	}
+	if args := c.BuildArgs(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+
+	if cmd := c.BuildCommand(); !reflect.DeepEqual(cmd, []string{"build"}) {
+		t.Errorf("unexpected build command %q", cmd)
+	}
+
+	c.Builder = "remote"
+	expected = []string{"buildx", "build", "--builder", "remote", "--load"}
+	if cmd := c.BuildCommand(); !reflect.DeepEqual(cmd, expected) {
+		t.Errorf("expected build command %q, got %q", expected, cmd)
+	}
+}
+
+func TestBuildConfigPrepare_secrets(t *testing.T) {
+	tests := []struct {
+		name      string
+		secrets   []BuildSecret
+		expectErr bool
+	}{
+		{"valid", []BuildSecret{{ID: "secret", Src: "./test-fixtures/sample_dockerfile"}}, false},
+		{"missing id", []BuildSecret{{Src: "./test-fixtures/sample_dockerfile"}}, true},
+		{"missing src", []BuildSecret{{ID: "secret"}}, true},
+		{"unknown src", []BuildSecret{{ID: "secret", Src: "./test-fixtures/no_such_secret"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DockerfileBootstrapConfig{
+				DockerfilePath: "./test-fixtures/sample_dockerfile",
+				Secrets:        tt.secrets,
+			}
+			_, err := c.Prepare()
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("unexpected error status: %v", err)
+			}
+			if err == nil && !filepath.IsAbs(c.Secrets[0].Src) {
+				t.Errorf("secret source should be made absolute, got %q", c.Secrets[0].Src)
+			}
+		})
+	}
+}
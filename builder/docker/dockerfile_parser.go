@@ -0,0 +1,431 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// dockerfile is the subset of a Dockerfile needed to validate a build before
+// running it: its build arguments and its stages.
+type dockerfile struct {
+	// globalArgs are the ARG instructions before the first FROM, which can
+	// be used in the FROM instructions.
+	globalArgs []dockerfileArg
+	stages     []dockerfileStage
+}
+
+// dockerfileArg is a build argument declared by an ARG instruction.
+type dockerfileArg struct {
+	name       string
+	value      string
+	hasDefault bool
+}
+
+// dockerfileStage is a stage of the build, starting with a FROM instruction.
+type dockerfileStage struct {
+	// name is the name given with `AS`, if any.
+	name  string
+	image string
+	args  []dockerfileArg
+}
+
+// dockerfileInstruction is an instruction of a Dockerfile, with its line
+// continuations joined.
+type dockerfileInstruction struct {
+	keyword string
+	args    string
+	line    int
+}
+
+// predefinedBuildArgs are the build arguments docker knows about without
+// them being declared with ARG.
+var predefinedBuildArgs = map[string]bool{
+	"HTTP_PROXY":  true,
+	"http_proxy":  true,
+	"HTTPS_PROXY": true,
+	"https_proxy": true,
+	"FTP_PROXY":   true,
+	"ftp_proxy":   true,
+	"NO_PROXY":    true,
+	"no_proxy":    true,
+	"ALL_PROXY":   true,
+	"all_proxy":   true,
+
+	"SOURCE_DATE_EPOCH": true,
+}
+
+// automaticBuildArgs are set by BuildKit for every build, they never need to
+// be provided.
+var automaticBuildArgs = map[string]bool{
+	"TARGETPLATFORM": true,
+	"TARGETOS":       true,
+	"TARGETARCH":     true,
+	"TARGETVARIANT":  true,
+	"BUILDPLATFORM":  true,
+	"BUILDOS":        true,
+	"BUILDARCH":      true,
+	"BUILDVARIANT":   true,
+}
+
+var (
+	dockerfileDirective = regexp.MustCompile(`^#\s*([a-zA-Z][a-zA-Z0-9]*)\s*=\s*(.+?)\s*$`)
+	dockerfileHeredoc   = regexp.MustCompile(`^(\d*)<<(-?)([^<]*)$`)
+	dockerfileVariable  = regexp.MustCompile(`\$(?:([a-zA-Z_][a-zA-Z0-9_]*)|\{([a-zA-Z_][a-zA-Z0-9_]*)(?::([-+])([^}]*))?\})`)
+)
+
+// parseDockerfile parses a Dockerfile, following the parser directives at its
+// top, its comments, line continuations and heredocs.
+//
+// Dockerfiles using a custom frontend through the `syntax` directive may not
+// follow the usual syntax, nil is returned for them.
+func parseDockerfile(r io.Reader) (*dockerfile, error) {
+	instructions, directives, err := readInstructions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if syntax := directives["syntax"]; syntax != "" && !strings.Contains(syntax, "docker/dockerfile") {
+		return nil, nil
+	}
+
+	df := &dockerfile{}
+	for _, instruction := range instructions {
+		switch instruction.keyword {
+		case "ARG":
+			args := parseArgInstruction(instruction.args)
+			if len(df.stages) == 0 {
+				df.globalArgs = append(df.globalArgs, args...)
+			} else {
+				stage := &df.stages[len(df.stages)-1]
+				stage.args = append(stage.args, args...)
+			}
+		case "FROM":
+			stage, err := parseFromInstruction(instruction.args)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", instruction.line, err)
+			}
+			df.stages = append(df.stages, stage)
+		default:
+			if len(df.stages) == 0 {
+				return nil, fmt.Errorf("line %d: %s instruction before the first FROM", instruction.line, instruction.keyword)
+			}
+		}
+	}
+
+	if len(df.stages) == 0 {
+		return nil, fmt.Errorf("no FROM instruction")
+	}
+
+	return df, nil
+}
+
+// readInstructions splits a Dockerfile into its instructions, and returns
+// them with its parser directives.
+func readInstructions(r io.Reader) ([]dockerfileInstruction, map[string]string, error) {
+	var instructions []dockerfileInstruction
+
+	escape := `\`
+	directives := map[string]string{}
+	inDirectives := true
+	var current *dockerfileInstruction
+	var heredocs []string
+	var heredocTabs []bool
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		// Heredoc bodies are part of the instruction, and are not
+		// interpreted in any way.
+		if len(heredocs) > 0 {
+			body := line
+			if heredocTabs[0] {
+				body = strings.TrimLeft(body, "\t")
+			}
+			if body == heredocs[0] {
+				heredocs, heredocTabs = heredocs[1:], heredocTabs[1:]
+			}
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+
+		// Parser directives are only recognized at the very top.
+		if inDirectives {
+			if match := dockerfileDirective.FindStringSubmatch(trimmed); match != nil {
+				name := strings.ToLower(match[1])
+				if name == "escape" {
+					if match[2] != `\` && match[2] != "`" {
+						return nil, nil, fmt.Errorf("line %d: invalid escape character %q", lineNumber, match[2])
+					}
+					escape = match[2]
+				}
+				directives[name] = match[2]
+				continue
+			}
+			inDirectives = false
+		}
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			// Comments and empty lines are skipped, even in the
+			// middle of a continued instruction.
+			continue
+		}
+
+		continued := strings.HasSuffix(trimmed, escape)
+		if continued {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, escape))
+		}
+
+		if current == nil {
+			keyword, args := trimmed, ""
+			if i := strings.IndexFunc(trimmed, unicode.IsSpace); i >= 0 {
+				keyword, args = trimmed[:i], trimmed[i:]
+			}
+			current = &dockerfileInstruction{
+				keyword: strings.ToUpper(keyword),
+				args:    strings.TrimSpace(args),
+				line:    lineNumber,
+			}
+		} else {
+			current.args = strings.TrimSpace(current.args + " " + trimmed)
+		}
+
+		if continued {
+			continue
+		}
+
+		switch current.keyword {
+		case "RUN", "COPY", "ADD":
+			for _, word := range heredocWords(current.args) {
+				match := dockerfileHeredoc.FindStringSubmatch(word)
+				if match == nil {
+					continue
+				}
+				if name := unquoteHeredoc(match[3]); name != "" {
+					heredocTabs = append(heredocTabs, match[2] == "-")
+					heredocs = append(heredocs, name)
+				}
+			}
+		}
+
+		instructions = append(instructions, *current)
+		current = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if current != nil {
+		instructions = append(instructions, *current)
+	}
+	if len(heredocs) > 0 {
+		return nil, nil, fmt.Errorf("unterminated heredoc %q", heredocs[0])
+	}
+
+	return instructions, directives, nil
+}
+
+// heredocWords splits the arguments of an instruction in shell words, with
+// their quotes kept. As with BuildKit, only a whole word like `<<EOF`,
+// `<<-"EOF"` or `3<<EOF` starts a heredoc, so that here-strings (`<<<`) and
+// shifts in arithmetic expansions (`$((1<<N))`) don't.
+func heredocWords(args string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range args {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' {
+				escaped = true
+			}
+		case r == '\\':
+			escaped = true
+		case r == '"' || r == '\'':
+			quote = r
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		}
+		word.WriteRune(r)
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// unquoteHeredoc returns the delimiter of a heredoc, without its quotes.
+func unquoteHeredoc(s string) string {
+	return strings.NewReplacer(`"`, "", "'", "").Replace(s)
+}
+
+// parseArgInstruction returns the build arguments declared by the arguments
+// of an ARG instruction, in the `name[=default] ...` form.
+func parseArgInstruction(args string) []dockerfileArg {
+	words, err := splitCommand(args)
+	if err != nil {
+		words = strings.Fields(args)
+	}
+
+	var ret []dockerfileArg
+	for _, word := range words {
+		name, value, hasDefault := strings.Cut(word, "=")
+		ret = append(ret, dockerfileArg{
+			name:       name,
+			value:      value,
+			hasDefault: hasDefault,
+		})
+	}
+	return ret
+}
+
+// parseFromInstruction parses the arguments of a FROM instruction, in the
+// `[--platform=<platform>] <image> [AS <name>]` form.
+func parseFromInstruction(args string) (dockerfileStage, error) {
+	var words []string
+	for _, word := range strings.Fields(args) {
+		if strings.HasPrefix(word, "--") {
+			continue
+		}
+		words = append(words, word)
+	}
+
+	switch {
+	case len(words) == 1:
+		return dockerfileStage{image: words[0]}, nil
+	case len(words) == 3 && strings.EqualFold(words[1], "AS"):
+		return dockerfileStage{image: words[0], name: strings.ToLower(words[2])}, nil
+	}
+	return dockerfileStage{}, fmt.Errorf("invalid FROM instruction %q", args)
+}
+
+// hasStage returns whether the Dockerfile has a stage with this name.
+func (df *dockerfile) hasStage(name string) bool {
+	for _, stage := range df.stages {
+		if stage.name != "" && stage.name == strings.ToLower(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseImages returns the images the stages of the Dockerfile are based on,
+// with the global build arguments expanded. Stages based on other stages and
+// `scratch` are left out, as no image is pulled for them.
+func (df *dockerfile) baseImages(arguments map[string]string) []string {
+	values := map[string]string{}
+	for _, arg := range df.globalArgs {
+		if value, ok := arguments[arg.name]; ok {
+			values[arg.name] = value
+		} else if arg.hasDefault {
+			values[arg.name] = expandBuildArgs(arg.value, values)
+		}
+	}
+
+	var images []string
+	seen := map[string]bool{}
+	stages := map[string]bool{}
+	for _, stage := range df.stages {
+		image := expandBuildArgs(stage.image, values)
+		if !stages[strings.ToLower(image)] && image != "scratch" && !seen[image] {
+			images = append(images, image)
+			seen[image] = true
+		}
+		if stage.name != "" {
+			stages[stage.name] = true
+		}
+	}
+	return images
+}
+
+// lint checks the build arguments and target of a build against the
+// Dockerfile. Problems that docker tolerates are returned as warnings.
+func (df *dockerfile) lint(arguments map[string]string, target string) ([]string, error) {
+	if target != "" && !df.hasStage(target) {
+		var names []string
+		for _, stage := range df.stages {
+			if stage.name != "" {
+				names = append(names, stage.name)
+			}
+		}
+		return nil, fmt.Errorf("target stage %q not found in Dockerfile, stages are: %s", target, strings.Join(names, ", "))
+	}
+
+	declared := map[string]bool{}
+	missing := map[string]bool{}
+	checkArgs := func(args []dockerfileArg) {
+		for _, arg := range args {
+			declared[arg.name] = true
+			if _, provided := arguments[arg.name]; !provided && !arg.hasDefault &&
+				!predefinedBuildArgs[arg.name] && !automaticBuildArgs[arg.name] {
+				missing[arg.name] = true
+			}
+		}
+	}
+	checkArgs(df.globalArgs)
+	for _, stage := range df.stages {
+		checkArgs(stage.args)
+	}
+
+	var warnings []string
+	for _, name := range sortedKeys(arguments) {
+		if !declared[name] && !predefinedBuildArgs[name] && !strings.HasPrefix(name, "BUILDKIT_") {
+			warnings = append(warnings, fmt.Sprintf("build argument %q is not declared by any ARG in the Dockerfile", name))
+		}
+	}
+
+	var missingNames []string
+	for name := range missing {
+		missingNames = append(missingNames, name)
+	}
+	sort.Strings(missingNames)
+	for _, name := range missingNames {
+		warnings = append(warnings, fmt.Sprintf("build argument %q has no default value in the Dockerfile and is not set in `arguments`", name))
+	}
+
+	return warnings, nil
+}
+
+// expandBuildArgs replaces the references to build arguments in s, in the
+// `$name`, `${name}`, `${name:-default}` and `${name:+value}` forms.
+func expandBuildArgs(s string, values map[string]string) string {
+	return dockerfileVariable.ReplaceAllStringFunc(s, func(ref string) string {
+		match := dockerfileVariable.FindStringSubmatch(ref)
+		name := match[1] + match[2]
+		value, set := values[name]
+		switch match[3] {
+		case "-":
+			if !set || value == "" {
+				return match[4]
+			}
+		case "+":
+			if set && value != "" {
+				return match[4]
+			}
+			return ""
+		}
+		return value
+	})
+}
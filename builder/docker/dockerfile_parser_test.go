@@ -0,0 +1,195 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testDockerfile = "# syntax=docker/dockerfile:1\n" +
+	"# escape=`\n" +
+	`
+ARG GO_VERSION=1.22
+ARG REGISTRY
+
+FROM ${REGISTRY:-docker.io}/library/golang:${GO_VERSION} AS build
+ARG VERSION
+ARG CGO_ENABLED=0 GOOS=linux
+# A comment in the middle of a continuation
+RUN go build ` + "`" + `
+    # skipped
+    -ldflags "-X main.version=${VERSION}" ` + "`" + `
+    -o /app .
+
+RUN <<SCRIPT
+FROM this-is-not-an-instruction
+SCRIPT
+
+from --platform=$BUILDPLATFORM alpine:3.20 as Certs
+RUN apk add ca-certificates
+
+FROM scratch AS release
+COPY --from=build /app /app
+COPY --from=certs /etc/ssl /etc/ssl
+ARG TARGETARCH
+`
+
+func TestParseDockerfile(t *testing.T) {
+	df, err := parseDockerfile(strings.NewReader(testDockerfile))
+	if err != nil {
+		t.Fatalf("failed to parse Dockerfile: %s", err)
+	}
+
+	expectedGlobal := []dockerfileArg{
+		{name: "GO_VERSION", value: "1.22", hasDefault: true},
+		{name: "REGISTRY"},
+	}
+	if !reflect.DeepEqual(df.globalArgs, expectedGlobal) {
+		t.Errorf("expected global args %#v, got %#v", expectedGlobal, df.globalArgs)
+	}
+
+	var names []string
+	for _, stage := range df.stages {
+		names = append(names, stage.name)
+	}
+	if expected := []string{"build", "certs", "release"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected stages %v, got %v", expected, names)
+	}
+
+	expectedArgs := []dockerfileArg{
+		{name: "VERSION"},
+		{name: "CGO_ENABLED", value: "0", hasDefault: true},
+		{name: "GOOS", value: "linux", hasDefault: true},
+	}
+	if !reflect.DeepEqual(df.stages[0].args, expectedArgs) {
+		t.Errorf("expected stage args %#v, got %#v", expectedArgs, df.stages[0].args)
+	}
+
+	images := df.baseImages(map[string]string{"GO_VERSION": "1.23"})
+	if expected := []string{"docker.io/library/golang:1.23", "alpine:3.20"}; !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected base images %v, got %v", expected, images)
+	}
+
+	images = df.baseImages(map[string]string{"REGISTRY": "registry.example.com"})
+	if expected := []string{"registry.example.com/library/golang:1.22", "alpine:3.20"}; !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected base images %v, got %v", expected, images)
+	}
+}
+
+func TestParseDockerfile_invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"empty", "# just a comment\n"},
+		{"instruction before FROM", "RUN true\nFROM alpine\n"},
+		{"invalid FROM", "FROM alpine AS\n"},
+		{"invalid escape", "# escape=!\nFROM alpine\n"},
+		{"unterminated heredoc", "FROM alpine\nRUN <<SCRIPT\ntrue\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseDockerfile(strings.NewReader(tt.content)); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+
+	// Custom frontends are not parsed
+	df, err := parseDockerfile(strings.NewReader("# syntax=example.com/frontend\nanything goes\n"))
+	if df != nil || err != nil {
+		t.Errorf("expected custom frontend to be skipped, got %v, %v", df, err)
+	}
+}
+
+func TestParseDockerfile_heredocs(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		instructions int
+	}{
+		{"here-string", "FROM alpine\nRUN cat <<<\"$X\"\nRUN true\n", 3},
+		{"arithmetic shift", "FROM alpine\nRUN echo $((1<<FOO))\nRUN true\n", 3},
+		{"quoted operator", "FROM alpine\nRUN echo '<<EOF'\nRUN true\n", 3},
+		{"heredoc", "FROM alpine\nRUN <<EOF\nRUN true\nEOF\nRUN true\n", 3},
+		{"quoted heredoc", "FROM alpine\nRUN <<-\"EOF\" cat\n\tRUN true\n\tEOF\nRUN true\n", 3},
+		{"heredoc to descriptor", "FROM alpine\nRUN 3<<EOF cat /dev/fd/3\nRUN true\nEOF\n", 2},
+		{"two heredocs", "FROM alpine\nCOPY <<A <<B /dst/\na\nA\nb\nB\nRUN true\n", 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instructions, _, err := readInstructions(strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(instructions) != tt.instructions {
+				t.Errorf("expected %d instructions, got %#v", tt.instructions, instructions)
+			}
+		})
+	}
+}
+
+func TestDockerfileLint(t *testing.T) {
+	df, err := parseDockerfile(strings.NewReader(testDockerfile))
+	if err != nil {
+		t.Fatalf("failed to parse Dockerfile: %s", err)
+	}
+
+	tests := []struct {
+		name             string
+		arguments        map[string]string
+		target           string
+		expectedWarnings []string
+		expectErr        bool
+	}{
+		{
+			"all set",
+			map[string]string{"REGISTRY": "docker.io", "VERSION": "1.0", "HTTP_PROXY": "http://proxy:3128"},
+			"release",
+			nil,
+			false,
+		},
+		{
+			"unused and missing arguments",
+			map[string]string{"VERSOIN": "1.0"},
+			"",
+			[]string{
+				`build argument "VERSOIN" is not declared by any ARG in the Dockerfile`,
+				"build argument \"REGISTRY\" has no default value in the Dockerfile and is not set in `arguments`",
+				"build argument \"VERSION\" has no default value in the Dockerfile and is not set in `arguments`",
+			},
+			false,
+		},
+		{
+			"target is case insensitive",
+			map[string]string{"REGISTRY": "docker.io", "VERSION": "1.0"},
+			"CERTS",
+			nil,
+			false,
+		},
+		{
+			"unknown target",
+			map[string]string{"REGISTRY": "docker.io", "VERSION": "1.0"},
+			"test",
+			nil,
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := df.lint(tt.arguments, tt.target)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("unexpected error status: %v", err)
+			}
+			if !reflect.DeepEqual(warnings, tt.expectedWarnings) {
+				t.Errorf("expected warnings:\n%s\ngot:\n%s", strings.Join(tt.expectedWarnings, "\n"), strings.Join(warnings, "\n"))
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ package docker
 
 import (
 	"io"
+	"time"
 
 	"github.com/hashicorp/go-version"
 )
@@ -19,6 +20,11 @@ type Driver interface {
 	// `DockerfileBootstrapConfig.BuildArgs` function.
 	Build(args []string) (string, error)
 
+	// BuildImage runs `docker build` on a Dockerfile, or `docker buildx
+	// build` if a builder is set in the config, shows its output, and
+	// returns the ID of the image.
+	BuildImage(config DockerfileBootstrapConfig) (string, error)
+
 	// Commit the container to a tag
 	Commit(id string, author string, changes []string, message string) (string, error)
 
@@ -38,6 +44,21 @@ type Driver interface {
 	// Sha256 returns the sha256 id of the image
 	Sha256(id string) (string, error)
 
+	// ImageSize returns the size of the image in bytes, with the layers it
+	// shares with its parents.
+	ImageSize(id string) (int64, error)
+
+	// ImageLayers returns the digests of the uncompressed layers of the
+	// image, from the bottom one up.
+	ImageLayers(id string) ([]string, error)
+
+	// FindImage returns the ID of a local image with the given label value,
+	// or an empty string if there is none.
+	FindImage(label string, value string) (string, error)
+
+	// InspectConfig returns the configuration of an image or a container.
+	InspectConfig(id string) (*ImageConfig, error)
+
 	// Retrieve the repo digest of the image.
 	Digest(id string) (string, error)
 
@@ -54,6 +75,10 @@ type Driver interface {
 	// Push pushes an image to a Docker index/registry.
 	Push(name string, platform string) error
 
+	// LoadImage loads the images of a `docker save` archive, and returns
+	// the ID of the image it holds.
+	LoadImage(src io.Reader) (string, error)
+
 	// Save an image with the given ID to the given writer.
 	SaveImage(id string, dst io.Writer) error
 
@@ -67,6 +92,17 @@ type Driver interface {
 	// StopContainer gently stops a container.
 	StopContainer(id string) error
 
+	// StopContainerWithSignal gently stops a container, with the given
+	// signal, or its stop signal if empty, and kills it after timeout, or the
+	// default timeout of Docker if zero.
+	StopContainerWithSignal(id string, signal string, timeout time.Duration) error
+
+	// PauseContainer suspends the processes of a container.
+	PauseContainer(id string) error
+
+	// UnpauseContainer resumes the processes of a paused container.
+	UnpauseContainer(id string) error
+
 	// TagImage tags the image with the given ID
 	TagImage(id string, repo string, force bool) error
 
@@ -91,6 +127,32 @@ type ContainerConfig struct {
 	Platform   string
 }
 
+// ImageConfig is the part of the configuration of an image, or of a
+// container, that can be changed when committing it.
+type ImageConfig struct {
+	User         string
+	WorkingDir   string
+	Env          []string
+	Cmd          []string
+	Entrypoint   []string
+	StopSignal   string
+	ExposedPorts map[string]struct{}
+	Volumes      map[string]struct{}
+	Labels       map[string]string
+	Healthcheck  *HealthConfig
+}
+
+// HealthConfig is the healthcheck of an image.
+type HealthConfig struct {
+	// Test is the command to run, prefixed with CMD or CMD-SHELL, or NONE
+	// to disable the healthcheck of the parent image.
+	Test        []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
 // This is the template that is used for the RunCommand in the ContainerConfig.
 type startContainerTemplate struct {
 	Image string
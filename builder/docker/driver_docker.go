@@ -4,7 +4,9 @@
 package docker
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -12,8 +14,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/go-version"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -68,6 +72,81 @@ func (d *DockerDriver) Build(args []string) (string, error) {
 	return strings.TrimSpace(string(imageId)), nil
 }
 
+func (d *DockerDriver) BuildImage(config DockerfileBootstrapConfig) (string, error) {
+	imageIdFile, err := os.CreateTemp("", "")
+	if err != nil {
+		return "", fmt.Errorf("failed to create image ID file: %s", err)
+	}
+	imageIdFilePath := imageIdFile.Name()
+	imageIdFile.Close()
+	defer os.Remove(imageIdFilePath)
+
+	args := config.BuildArgs()
+	log.Printf("Building container with args: %v", args)
+	cmd := exec.Command(d.Executable, config.BuildCommand()...)
+	cmd.Args = append(cmd.Args, "--iidfile", imageIdFilePath)
+	cmd.Args = append(cmd.Args, args...)
+	// BuildKit only shows the output of the steps with the plain progress
+	cmd.Env = append(os.Environ(), "BUILDKIT_PROGRESS=plain")
+
+	// Both streams are read as one, to keep the lines in order
+	outputR, outputW := io.Pipe()
+	cmd.Stdout = outputW
+	cmd.Stderr = outputW
+
+	progress := newBuildProgress()
+	var output []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reader := bufio.NewReader(outputR)
+		for {
+			line, err := reader.ReadString('\n')
+			if line = strings.TrimRight(line, "\r\n"); line != "" {
+				log.Printf("[build] %s", line)
+				progress.parseLine(line)
+				if config.Quiet {
+					output = append(output, line)
+				} else {
+					d.Ui.Message(line)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	err = cmd.Run()
+	outputW.Close()
+	<-done
+
+	if err != nil {
+		for _, line := range output {
+			d.Ui.Message(line)
+		}
+		if failure := progress.failure(); failure != "" {
+			return "", fmt.Errorf("%s build failed: %s", d.Executable, failure)
+		}
+		return "", fmt.Errorf("%s build failed: %s", d.Executable, err)
+	}
+
+	if summary := progress.summary(); len(summary) > 0 && !config.Quiet {
+		cached, total := progress.cacheHits()
+		d.Ui.Message(fmt.Sprintf("Build steps (%d of %d cached):", cached, total))
+		for _, line := range summary {
+			d.Ui.Message("  " + line)
+		}
+	}
+
+	imageId, err := os.ReadFile(imageIdFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read image ID from file %q: %s", imageIdFilePath, err)
+	}
+
+	return strings.TrimSpace(string(imageId)), nil
+}
+
 func (d *DockerDriver) DeleteImage(id string) error {
 	var stderr bytes.Buffer
 	cmd := exec.Command(d.Executable, "rmi", id)
@@ -227,6 +306,71 @@ func (d *DockerDriver) Sha256(id string) (string, error) {
 	return strings.TrimSpace(stdout.String()), nil
 }
 
+// ImageSize retrieves the size of the image using Docker inspect.
+func (d *DockerDriver) ImageSize(id string) (int64, error) {
+	var stderr, stdout bytes.Buffer
+	cmd := exec.Command(
+		d.Executable,
+		"inspect",
+		"--format",
+		"{{ .Size }}",
+		id)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("Error: %s\n\nStderr: %s", err, stderr.String())
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(stdout.String()), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing size of image %s: %s", id, err)
+	}
+	return size, nil
+}
+
+func (d *DockerDriver) ImageLayers(id string) ([]string, error) {
+	var stderr, stdout bytes.Buffer
+	cmd := exec.Command(
+		d.Executable,
+		"inspect",
+		"--format",
+		"{{ json .RootFS.Layers }}",
+		id)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Error: %s\n\nStderr: %s", err, stderr.String())
+	}
+
+	var layers []string
+	if err := json.Unmarshal(stdout.Bytes(), &layers); err != nil {
+		return nil, fmt.Errorf("Error parsing layers of image %s: %s", id, err)
+	}
+	return layers, nil
+}
+
+// FindImage looks for an image by label using Docker images.
+func (d *DockerDriver) FindImage(label string, value string) (string, error) {
+	var stderr, stdout bytes.Buffer
+	cmd := exec.Command(
+		d.Executable,
+		"images",
+		"--no-trunc",
+		"--filter", fmt.Sprintf("label=%s=%s", label, value),
+		"--format", "{{ .ID }}")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Error: %s\n\nStderr: %s", err, stderr.String())
+	}
+
+	ids := strings.Fields(stdout.String())
+	if len(ids) == 0 {
+		return "", nil
+	}
+	return ids[0], nil
+}
+
 // Digest retrieves the digest of the image using Docker inspect.
 // Format for the digest is: <repo>@sha256:<shasum>
 // For example:
@@ -284,6 +428,29 @@ func (d *DockerDriver) Entrypoint(id string) (string, error) {
 	return strings.TrimSpace(stdout.String()), nil
 }
 
+// InspectConfig reads the configuration of an image or a container using
+// Docker inspect.
+func (d *DockerDriver) InspectConfig(id string) (*ImageConfig, error) {
+	var stderr, stdout bytes.Buffer
+	cmd := exec.Command(
+		d.Executable,
+		"inspect",
+		"--format",
+		"{{ json .Config }}",
+		id)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("Error: %s\n\nStderr: %s", err, stderr.String())
+	}
+
+	config := &ImageConfig{}
+	if err := json.Unmarshal(stdout.Bytes(), config); err != nil {
+		return nil, fmt.Errorf("Error parsing configuration of %s: %s", id, err)
+	}
+	return config, nil
+}
+
 func (d *DockerDriver) Login(repo, user, pass string) error {
 	d.l.Lock()
 
@@ -373,6 +540,30 @@ func (d *DockerDriver) Push(name string, platform string) error {
 	return runAndStream(cmd, d.Ui)
 }
 
+func (d *DockerDriver) LoadImage(src io.Reader) (string, error) {
+	var stderr, stdout bytes.Buffer
+	cmd := exec.Command(d.Executable, "load")
+	cmd.Stdin = src
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	log.Print("Loading image")
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("Error loading image: %s\nStderr: %s", err, stderr.String())
+	}
+
+	// Untagged images are reported as "Loaded image ID: sha256:...", tagged
+	// ones as "Loaded image: name:tag".
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		for _, prefix := range []string{"Loaded image ID:", "Loaded image:"} {
+			if id, ok := strings.CutPrefix(line, prefix); ok {
+				return strings.TrimSpace(id), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Error reading ID of the loaded image: %s", stdout.String())
+}
+
 func (d *DockerDriver) SaveImage(id string, dst io.Writer) error {
 	var stderr bytes.Buffer
 	cmd := exec.Command(d.Executable, "save", id)
@@ -467,15 +658,57 @@ func (d *DockerDriver) StartContainer(config *ContainerConfig) (string, error) {
 }
 
 func (d *DockerDriver) StopContainer(id string) error {
-	if err := exec.Command(d.Executable, "stop", id).Run(); err != nil {
-		return err
+	return d.StopContainerWithSignal(id, "", 0)
+}
+
+func (d *DockerDriver) StopContainerWithSignal(id string, signal string, timeout time.Duration) error {
+	args := []string{"stop"}
+	if signal != "" {
+		args = append(args, "--signal", signal)
+	}
+	if timeout > 0 {
+		// docker stop takes whole seconds
+		args = append(args, "--time", strconv.Itoa(int((timeout+time.Second-1)/time.Second)))
+	}
+	args = append(args, id)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(d.Executable, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error stopping container: %s\nStderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (d *DockerDriver) PauseContainer(id string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(d.Executable, "pause", id)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error pausing container: %s\nStderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (d *DockerDriver) UnpauseContainer(id string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(d.Executable, "unpause", id)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Error unpausing container: %s\nStderr: %s", err, stderr.String())
 	}
 	return nil
 }
 
 func (d *DockerDriver) KillContainer(id string) error {
-	if err := exec.Command(d.Executable, "kill", id).Run(); err != nil {
-		return err
+	// The container may have been stopped already, by commit_stop for
+	// instance, in which case it only needs to be removed.
+	running, err := exec.Command(d.Executable, "inspect", "--format", "{{ .State.Running }}", id).Output()
+	if err != nil || strings.TrimSpace(string(running)) != "false" {
+		if err := exec.Command(d.Executable, "kill", id).Run(); err != nil {
+			return err
+		}
 	}
 
 	return exec.Command(d.Executable, "rm", id).Run()
@@ -3,8 +3,114 @@
 
 package docker
 
-import "testing"
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
 
 func TestDockerDriver_impl(t *testing.T) {
 	var _ Driver = new(DockerDriver)
 }
+
+// testBuildDriver returns a driver whose docker executable prints a BuildKit
+// progress, and fails if the build arguments contain FAIL.
+func testBuildDriver(t *testing.T) (*DockerDriver, *bytes.Buffer) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+
+	executable := filepath.Join(t.TempDir(), "docker")
+	script := `#!/bin/sh
+while [ "$1" != "--iidfile" ]; do shift; done
+echo "#1 [1/1] FROM alpine" >&2
+echo "#1 CACHED" >&2
+case "$*" in
+*FAIL*)
+  echo "#2 [2/2] RUN false" >&2
+  echo "#2 ERROR: process did not complete successfully" >&2
+  exit 1
+  ;;
+esac
+printf "sha256:1234\n" > "$2"
+`
+	if err := os.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake docker: %s", err)
+	}
+
+	output := new(bytes.Buffer)
+	return &DockerDriver{
+		Executable: executable,
+		Ui: &packersdk.BasicUi{
+			Reader: new(bytes.Buffer),
+			Writer: output,
+		},
+	}, output
+}
+
+func TestDockerDriver_Build(t *testing.T) {
+	driver, output := testBuildDriver(t)
+
+	imageId, err := driver.Build([]string{"-f", "Dockerfile", "."})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if imageId != "sha256:1234" {
+		t.Errorf("unexpected image ID %q", imageId)
+	}
+	if output.Len() != 0 {
+		t.Errorf("should not display the output, got:\n%s", output)
+	}
+
+	_, err = driver.Build([]string{"-f", "Dockerfile", "FAIL"})
+	if err == nil || !strings.Contains(err.Error(), "RUN false") {
+		t.Errorf("error should have the output of the build, got %v", err)
+	}
+}
+
+func TestDockerDriver_BuildImage(t *testing.T) {
+	driver, output := testBuildDriver(t)
+
+	imageId, err := driver.BuildImage(DockerfileBootstrapConfig{DockerfilePath: "Dockerfile", BuildDir: "."})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if imageId != "sha256:1234" {
+		t.Errorf("unexpected image ID %q", imageId)
+	}
+
+	for _, expected := range []string{"#1 [1/1] FROM alpine", "(1 of 1 cached)"} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("missing %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestDockerDriver_BuildImageQuiet(t *testing.T) {
+	driver, output := testBuildDriver(t)
+
+	_, err := driver.BuildImage(DockerfileBootstrapConfig{DockerfilePath: "Dockerfile", BuildDir: ".", Quiet: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if output.Len() != 0 {
+		t.Errorf("quiet build should not display anything, got:\n%s", output)
+	}
+
+	// The output is displayed if the build fails
+	_, err = driver.BuildImage(DockerfileBootstrapConfig{DockerfilePath: "Dockerfile", BuildDir: "FAIL", Quiet: true})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.Contains(err.Error(), "[2/2] RUN false: ERROR: process did not complete successfully") {
+		t.Errorf("error should name the failed step, got %q", err)
+	}
+	if !strings.Contains(output.String(), "#2 [2/2] RUN false") {
+		t.Errorf("missing build output after a failure:\n%s", output)
+	}
+}
@@ -6,6 +6,7 @@ package docker
 import (
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/hashicorp/go-version"
 )
@@ -13,16 +14,21 @@ import (
 // MockDriver is a driver implementation that can be used for tests.
 type MockDriver struct {
 	BuildCalled     bool
+	BuildArgs       []string
+	BuildConfig     DockerfileBootstrapConfig
 	BuildImageId    string
 	BuildImageError error
 
 	CommitCalled      bool
 	CommitContainerId string
+	CommitChanges     []string
+	CommitMessages    []string
 	CommitImageId     string
 	CommitErr         error
 
 	DeleteImageCalled bool
 	DeleteImageId     string
+	DeleteImageIds    []string
 	DeleteImageErr    error
 
 	ImportCalled   bool
@@ -42,6 +48,26 @@ type MockDriver struct {
 	Sha256Result string
 	Sha256Err    error
 
+	ImageSizeCalled bool
+	ImageSizeId     string
+	ImageSizeResult int64
+	ImageSizeErr    error
+
+	ImageLayersCalled bool
+	ImageLayersId     string
+	ImageLayersResult []string
+	ImageLayersErr    error
+
+	FindImageCalled bool
+	FindImageLabel  string
+	FindImageValue  string
+	FindImageResult string
+	FindImageErr    error
+
+	// InspectConfigResults are the configurations returned by ID
+	InspectConfigResults map[string]*ImageConfig
+	InspectConfigErr     error
+
 	DigestCalled bool
 	DigestId     string
 	DigestResult string
@@ -66,6 +92,11 @@ type MockDriver struct {
 	PushPlatform string
 	PushErr      error
 
+	LoadImageCalled bool
+	LoadImageWriter io.Writer
+	LoadImageId     string
+	LoadImageErr    error
+
 	SaveImageCalled bool
 	SaveImageId     string
 	SaveImageReader io.Reader
@@ -94,14 +125,39 @@ type MockDriver struct {
 	StartConfig  *ContainerConfig
 	StopCalled   bool
 	StopID       string
+	StopSignal   string
+	StopTimeout  time.Duration
 	VerifyCalled bool
 
+	PauseCalled   bool
+	PauseID       string
+	PauseError    error
+	UnpauseCalled bool
+	UnpauseID     string
+	UnpauseError  error
+
 	VersionCalled  bool
 	VersionVersion string
 }
 
 func (d *MockDriver) Build(args []string) (string, error) {
 	d.BuildCalled = true
+	d.BuildArgs = args
+
+	if d.BuildImageError != nil {
+		return "", d.BuildImageError
+	}
+
+	if d.BuildImageId == "" {
+		return "", fmt.Errorf("missing config argument for mock driver: BuildImageId")
+	}
+
+	return d.BuildImageId, nil
+}
+
+func (d *MockDriver) BuildImage(config DockerfileBootstrapConfig) (string, error) {
+	d.BuildCalled = true
+	d.BuildConfig = config
 
 	if d.BuildImageError != nil {
 		return "", d.BuildImageError
@@ -117,12 +173,15 @@ func (d *MockDriver) Build(args []string) (string, error) {
 func (d *MockDriver) Commit(id string, author string, changes []string, message string) (string, error) {
 	d.CommitCalled = true
 	d.CommitContainerId = id
+	d.CommitChanges = changes
+	d.CommitMessages = append(d.CommitMessages, message)
 	return d.CommitImageId, d.CommitErr
 }
 
 func (d *MockDriver) DeleteImage(id string) error {
 	d.DeleteImageCalled = true
 	d.DeleteImageId = id
+	d.DeleteImageIds = append(d.DeleteImageIds, id)
 	return d.DeleteImageErr
 }
 
@@ -160,6 +219,36 @@ func (d *MockDriver) Sha256(id string) (string, error) {
 	return d.Sha256Result, d.Sha256Err
 }
 
+func (d *MockDriver) ImageSize(id string) (int64, error) {
+	d.ImageSizeCalled = true
+	d.ImageSizeId = id
+	return d.ImageSizeResult, d.ImageSizeErr
+}
+
+func (d *MockDriver) ImageLayers(id string) ([]string, error) {
+	d.ImageLayersCalled = true
+	d.ImageLayersId = id
+	return d.ImageLayersResult, d.ImageLayersErr
+}
+
+func (d *MockDriver) FindImage(label string, value string) (string, error) {
+	d.FindImageCalled = true
+	d.FindImageLabel = label
+	d.FindImageValue = value
+	return d.FindImageResult, d.FindImageErr
+}
+
+func (d *MockDriver) InspectConfig(id string) (*ImageConfig, error) {
+	if d.InspectConfigErr != nil {
+		return nil, d.InspectConfigErr
+	}
+	config, ok := d.InspectConfigResults[id]
+	if !ok {
+		return nil, fmt.Errorf("No such object: %s", id)
+	}
+	return config, nil
+}
+
 func (d *MockDriver) Digest(id string) (string, error) {
 	d.DigestCalled = true
 	d.DigestId = id
@@ -194,6 +283,16 @@ func (d *MockDriver) Push(name string, platform string) error {
 	return d.PushErr
 }
 
+func (d *MockDriver) LoadImage(src io.Reader) (string, error) {
+	d.LoadImageCalled = true
+	if d.LoadImageWriter != nil {
+		if _, err := io.Copy(d.LoadImageWriter, src); err != nil {
+			return "", err
+		}
+	}
+	return d.LoadImageId, d.LoadImageErr
+}
+
 func (d *MockDriver) SaveImage(id string, dst io.Writer) error {
 	d.SaveImageCalled = true
 	d.SaveImageId = id
@@ -226,6 +325,26 @@ func (d *MockDriver) StopContainer(id string) error {
 	return d.StopError
 }
 
+func (d *MockDriver) StopContainerWithSignal(id string, signal string, timeout time.Duration) error {
+	d.StopCalled = true
+	d.StopID = id
+	d.StopSignal = signal
+	d.StopTimeout = timeout
+	return d.StopError
+}
+
+func (d *MockDriver) PauseContainer(id string) error {
+	d.PauseCalled = true
+	d.PauseID = id
+	return d.PauseError
+}
+
+func (d *MockDriver) UnpauseContainer(id string) error {
+	d.UnpauseCalled = true
+	d.UnpauseID = id
+	return d.UnpauseError
+}
+
 func (d *MockDriver) TagImage(id string, repo string, force bool) error {
 	d.TagImageCalled += 1
 	d.TagImageImageId = id
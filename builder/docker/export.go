@@ -0,0 +1,318 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	ExportFormatRootfs        = "rootfs"
+	ExportFormatOCILayout     = "oci-layout"
+	ExportFormatOCIArchive    = "oci-archive"
+	ExportFormatDockerArchive = "docker-archive"
+	ExportFormatExt4          = "ext4"
+	ExportFormatSquashfs      = "squashfs"
+	ExportFormatLXC           = "lxc"
+
+	ExportCompressionNone = "none"
+	ExportCompressionGzip = "gzip"
+	ExportCompressionZstd = "zstd"
+	ExportCompressionXz   = "xz"
+
+	ExportChecksumSha256 = "sha256"
+	ExportChecksumSha512 = "sha512"
+)
+
+// exportExtensions maps the extensions of export paths to the compression
+// they stand for.
+var exportExtensions = []struct {
+	suffix      string
+	compression string
+}{
+	{".tar.gz", ExportCompressionGzip},
+	{".tgz", ExportCompressionGzip},
+	{".gz", ExportCompressionGzip},
+	{".tar.zst", ExportCompressionZstd},
+	{".tzst", ExportCompressionZstd},
+	{".zst", ExportCompressionZstd},
+	{".tar.xz", ExportCompressionXz},
+	{".txz", ExportCompressionXz},
+	{".xz", ExportCompressionXz},
+}
+
+// exportCompressionLevels are the ranges of levels of each compression.
+var exportCompressionLevels = map[string][2]int{
+	ExportCompressionGzip: {gzip.BestSpeed, gzip.BestCompression},
+	ExportCompressionZstd: {1, 22},
+	ExportCompressionXz:   {1, 9},
+}
+
+// xzDictCaps are the dictionary sizes of the presets of xz(1), by level.
+var xzDictCaps = []int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+var exportChecksums = map[string]func() hash.Hash{
+	ExportChecksumSha256: sha256.New,
+	ExportChecksumSha512: sha512.New,
+}
+
+// exportChecksumData are the generated data the checksums are put in.
+var exportChecksumData = map[string]string{
+	ExportChecksumSha256: "ExportSha256",
+	ExportChecksumSha512: "ExportSha512",
+}
+
+// exportCompression returns the compression of the exports to path, from
+// its extension.
+func exportCompression(path string) string {
+	path = strings.ToLower(path)
+	for _, e := range exportExtensions {
+		if strings.HasSuffix(path, e.suffix) {
+			return e.compression
+		}
+	}
+	return ExportCompressionNone
+}
+
+// validateExportCompression returns an error if the compression or its
+// level are invalid. A level of 0 is the default level of the compression.
+func validateExportCompression(compression string, level int) error {
+	if compression == ExportCompressionNone {
+		if level != 0 {
+			return fmt.Errorf("export_compression_level requires export_compression")
+		}
+		return nil
+	}
+	levels, ok := exportCompressionLevels[compression]
+	if !ok {
+		return fmt.Errorf("invalid export_compression %q, expected %s, %s, %s or %s", compression,
+			ExportCompressionNone, ExportCompressionGzip, ExportCompressionZstd, ExportCompressionXz)
+	}
+	if level != 0 && (level < levels[0] || level > levels[1]) {
+		return fmt.Errorf("invalid export_compression_level %d, %s expects a level from %d to %d",
+			level, compression, levels[0], levels[1])
+	}
+	return nil
+}
+
+// compressExport returns a writer compressing to dst. Closing it flushes
+// the compressed data, but does not close dst.
+func compressExport(dst io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch compression {
+	case ExportCompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(dst, level)
+	case ExportCompressionZstd:
+		var opts []zstd.EOption
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(dst, opts...)
+	case ExportCompressionXz:
+		var c xz.WriterConfig
+		if level != 0 {
+			c.DictCap = xzDictCaps[level]
+		}
+		return c.NewWriter(dst)
+	default:
+		return nopWriteCloser{dst}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// exportChecksumFile returns the contents of the checksum file of the
+// export named name, in the format of sha256sum(1).
+func exportChecksumFile(sum, name string) string {
+	return fmt.Sprintf("%s  %s\n", sum, name)
+}
+
+// exportsImage returns whether the image committed from the container is
+// exported, rather than the filesystem of the container.
+func (c *Config) exportsImage() bool {
+	switch c.ExportFormat {
+	case ExportFormatOCILayout, ExportFormatOCIArchive, ExportFormatDockerArchive:
+		return c.ExportPath != ""
+	}
+	return false
+}
+
+// exportsFilesystem returns whether the container is exported as a
+// filesystem image.
+func (c *Config) exportsFilesystem() bool {
+	return c.ExportPath != "" && (c.ExportFormat == ExportFormatExt4 || c.ExportFormat == ExportFormatSquashfs)
+}
+
+// parseExportFSSize parses a filesystem size, in bytes or with a K, M, G or
+// T suffix for binary units.
+func parseExportFSSize(s string) (int64, error) {
+	n := strings.TrimSuffix(strings.ToUpper(s), "B")
+	shift := 0
+	if i := strings.IndexAny(n, "KMGT"); i >= 0 && i == len(n)-1 {
+		shift = 10 * (strings.Index("KMGT", n[i:]) + 1)
+		n = n[:i]
+	}
+	size, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || size <= 0 || size > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid export_fs_size %q, expected a size like \"512M\" or \"4G\"", s)
+	}
+	return size << shift, nil
+}
+
+// parseExportFSUUID parses a filesystem UUID, like
+// "2b8b4c3e-9f4e-4d3c-8f6b-6a1e0c9d7f21".
+func parseExportFSUUID(s string) ([16]byte, error) {
+	var uuid [16]byte
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 || len(s) != 36 || strings.Count(s, "-") != 4 ||
+		s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return uuid, fmt.Errorf("invalid export_fs_uuid %q, expected a UUID like \"2b8b4c3e-9f4e-4d3c-8f6b-6a1e0c9d7f21\"", s)
+	}
+	copy(uuid[:], b)
+	return uuid, nil
+}
+
+// randomExportFSUUID returns a random version 4 UUID.
+func randomExportFSUUID() ([16]byte, error) {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		return uuid, err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return uuid, nil
+}
+
+// exportFilesystem exports the container as the filesystem image of the
+// export_format to w. The image is written to a temporary file next to the
+// export path first, as filesystems aren't written sequentially.
+func exportFilesystem(driver Driver, container string, c *Config, w io.Writer) error {
+	tmp, err := writeFilesystem(driver, container, c)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, tmp)
+	return err
+}
+
+// exportFilesystemFile exports the container as the filesystem image of
+// the export_format to the export path, renaming the temporary file it is
+// written to into place rather than copying it.
+func exportFilesystemFile(driver Driver, container string, c *Config) error {
+	tmp, err := writeFilesystem(driver, container, c)
+	if err != nil {
+		return err
+	}
+	err = tmp.Chmod(0644)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.ExportPath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// writeFilesystem writes the filesystem image of the export_format to a
+// temporary file next to the export path.
+func writeFilesystem(driver Driver, container string, c *Config) (*os.File, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(c.ExportPath), ".packer-export-")
+	if err != nil {
+		return nil, err
+	}
+
+	err = readExport(driver, container, func(r io.Reader) error {
+		if c.ExportFormat == ExportFormatExt4 {
+			return writeExt4(r, tmp, ext4Options{
+				Size:     c.exportFSSize,
+				Headroom: c.ExportFSHeadroom,
+				Label:    c.ExportFSLabel,
+				UUID:     c.exportFSUUID,
+				Now:      time.Now(),
+			})
+		}
+		return writeSquashfs(r, tmp, time.Now())
+	})
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// exportLXCImage exports the container as the unified tarball of an LXC
+// image to w.
+func exportLXCImage(driver Driver, container string, c *Config, w io.Writer) error {
+	arch, err := lxcArchitecture(c.Platform)
+	if err != nil {
+		return err
+	}
+	meta := lxcMetadata{
+		Architecture: arch,
+		CreationDate: time.Now(),
+		Description:  c.Message,
+	}
+	return readExport(driver, container, func(r io.Reader) error {
+		return writeLXCImage(r, w, meta)
+	})
+}
+
+// readExport exports the container, and passes the tar stream to read.
+func readExport(driver Driver, container string, read func(io.Reader) error) error {
+	r, w := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := driver.Export(container, w)
+		w.CloseWithError(err)
+		errCh <- err
+	}()
+
+	err := read(r)
+	if err == nil {
+		// Read the padding after the end of the archive.
+		_, err = io.Copy(io.Discard, r)
+	}
+	// Let Export return if reading stopped early.
+	r.CloseWithError(errors.New("reading of the exported container stopped"))
+	if exportErr := <-errCh; exportErr != nil {
+		return exportErr
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestExportCompression(t *testing.T) {
+	tests := map[string]string{
+		"rootfs.tar":      ExportCompressionNone,
+		"rootfs.tar.gz":   ExportCompressionGzip,
+		"rootfs.TGZ":      ExportCompressionGzip,
+		"rootfs.tar.zst":  ExportCompressionZstd,
+		"rootfs.tar.xz":   ExportCompressionXz,
+		"rootfs.gz.d/foo": ExportCompressionNone,
+	}
+	for path, expected := range tests {
+		if actual := exportCompression(path); actual != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, actual)
+		}
+	}
+}
+
+func TestValidateExportCompression(t *testing.T) {
+	tests := []struct {
+		compression string
+		level       int
+		valid       bool
+	}{
+		{ExportCompressionNone, 0, true},
+		{ExportCompressionNone, 1, false},
+		{ExportCompressionGzip, 0, true},
+		{ExportCompressionGzip, 9, true},
+		{ExportCompressionGzip, 10, false},
+		{ExportCompressionZstd, 22, true},
+		{ExportCompressionZstd, -1, false},
+		{ExportCompressionXz, 1, true},
+		{"bzip2", 0, false},
+	}
+	for _, tt := range tests {
+		if err := validateExportCompression(tt.compression, tt.level); (err == nil) != tt.valid {
+			t.Errorf("%s %d: bad: %v", tt.compression, tt.level, err)
+		}
+	}
+}
+
+func TestParseExportFSSize(t *testing.T) {
+	tests := map[string]int64{
+		"1048576": 1 << 20,
+		"512M":    512 << 20,
+		"4g":      4 << 30,
+		"2GB":     2 << 30,
+		"1T":      1 << 40,
+		"0":       0,
+		"-1G":     0,
+		"G":       0,
+		"1.5G":    0,
+		"1GiB":    0,
+	}
+	for s, expected := range tests {
+		actual, err := parseExportFSSize(s)
+		if actual != expected || (err == nil) != (expected != 0) {
+			t.Errorf("%s: expected %d, got %d, %v", s, expected, actual, err)
+		}
+	}
+}
+
+func TestCompressExport(t *testing.T) {
+	decompress := map[string]func(io.Reader) (io.Reader, error){
+		ExportCompressionNone: func(r io.Reader) (io.Reader, error) { return r, nil },
+		ExportCompressionGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		ExportCompressionZstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		ExportCompressionXz:   func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+	}
+	for compression, newReader := range decompress {
+		for _, level := range []int{0, 9} {
+			var buf bytes.Buffer
+			w, err := compressExport(&buf, compression, level)
+			if err != nil {
+				t.Fatalf("%s: %s", compression, err)
+			}
+			if _, err := w.Write([]byte("data!")); err != nil {
+				t.Fatalf("%s: %s", compression, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%s: %s", compression, err)
+			}
+
+			r, err := newReader(&buf)
+			if err != nil {
+				t.Fatalf("%s: %s", compression, err)
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%s: %s", compression, err)
+			}
+			if string(data) != "data!" {
+				t.Fatalf("%s: bad: %q", compression, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// fingerprintLabel is the label of the committed images holding the
+// fingerprint of the inputs of their build.
+const fingerprintLabel = "packer.fingerprint"
+
+// fingerprint computes the fingerprint of the inputs of a build: its source
+// image, the Dockerfile and context of the bootstrap image, the changes made
+// to the image on commit, the options changing the image, and the files and
+// variables listed in the config.
+func fingerprint(config *Config, source string) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "source\x00%s\x00", source)
+
+	if !config.BuildConfig.IsDefault() {
+		if err := writeBuildInputs(h, &config.BuildConfig); err != nil {
+			return "", err
+		}
+	}
+
+	for _, change := range config.Changes {
+		fmt.Fprintf(h, "change\x00%s\x00", change)
+	}
+
+	options, err := json.Marshal(fingerprintOptions(config))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "options\x00%s\x00", options)
+
+	for _, file := range config.FingerprintFiles {
+		fmt.Fprintf(h, "file\x00%s\x00", filepath.ToSlash(file))
+		if err := hashPath(h, file); err != nil {
+			return "", err
+		}
+	}
+
+	for _, name := range sortedKeys(config.FingerprintVariables) {
+		fmt.Fprintf(h, "variable\x00%s\x00%s\x00", name, config.FingerprintVariables[name])
+	}
+
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// fingerprintOptions returns the options of the config that change what is
+// built: how the container is run and provisioned, and how it is committed.
+func fingerprintOptions(c *Config) interface{} {
+	return struct {
+		Author               string
+		ImageConfig          ImageConfigChanges
+		Labels               map[string]string
+		OCIAnnotations       bool
+		CommitPerProvisioner bool
+		Squash               bool
+		SquashAll            bool
+		PreCommitCommand     string
+		CleanupPaths         []string
+		CleanupPresets       []string
+		ContainerDir         string
+		Device               []string
+		CapAdd               []string
+		CapDrop              []string
+		Privileged           bool
+		Runtime              string
+		ExecUser             string
+		ExecWorkdir          string
+		ExecEnv              map[string]string
+		Message              string
+		RunCommand           []string
+		Shell                []string
+		NoShell              bool
+		PauseCommand         []string
+		TmpFs                []string
+		Volumes              map[string]string
+		FixUploadOwner       bool
+		UploadOwner          string
+		UploadMode           string
+		UploadOwnerMethod    string
+		WindowsContainer     bool
+		Platform             string
+	}{
+		c.Author, c.ImageConfig, c.Labels, c.OCIAnnotations, c.CommitPerProvisioner,
+		c.Squash, c.SquashAll, c.PreCommitCommand, c.CleanupPaths, c.CleanupPresets,
+		c.ContainerDir, c.Device, c.CapAdd, c.CapDrop, c.Privileged, c.Runtime,
+		c.ExecUser, c.ExecWorkdir, c.ExecEnv, c.Message, c.RunCommand, c.Shell,
+		c.NoShell, c.PauseCommand, c.TmpFs, c.Volumes, c.FixUploadOwner,
+		c.UploadOwner, c.UploadMode, c.UploadOwnerMethod, c.WindowsContainer,
+		c.Platform,
+	}
+}
+
+// writeBuildInputs writes the Dockerfile, build arguments and context of the
+// bootstrap image to w.
+func writeBuildInputs(w io.Writer, c *DockerfileBootstrapConfig) error {
+	dockerfile := []byte(c.Content)
+	if c.DockerfilePath != "" {
+		var err error
+		if dockerfile, err = os.ReadFile(c.DockerfilePath); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "dockerfile\x00%s\x00", dockerfile)
+
+	for _, name := range sortedKeys(c.Arguments) {
+		fmt.Fprintf(w, "argument\x00%s\x00%s\x00", name, c.Arguments[name])
+	}
+	fmt.Fprintf(w, "target\x00%s\x00platform\x00%s\x00", c.Target, c.Platform)
+
+	switch {
+	case c.ContextTarball != "":
+		fmt.Fprint(w, "context_tarball\x00")
+		return hashPath(w, c.ContextTarball)
+	case c.ContextGit.Path != "":
+		// The files of a revision are identified by its commit.
+		cmd := exec.Command("git", "-C", c.ContextGit.Path, "rev-parse", "--verify", c.ContextGit.Ref+"^{commit}")
+		output, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("failed to resolve revision %q of %q: %s", c.ContextGit.Ref, c.ContextGit.Path, err)
+		}
+		fmt.Fprintf(w, "context_git\x00%s\x00", bytes.TrimSpace(output))
+	default:
+		exclude, err := readDockerignore(c.BuildDir)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(w, "build_dir\x00")
+		return hashDir(w, c.BuildDir, exclude)
+	}
+	return nil
+}
+
+// readDockerignore returns the patterns of the .dockerignore file of a build
+// context, which docker doesn't send to the build.
+func readDockerignore(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Exceptions can't be expressed with exclusions, the whole
+		// context is hashed then.
+		if strings.HasPrefix(line, "!") {
+			return nil, nil
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// hashPath writes the contents of a file, or of the files of a directory, to
+// w.
+func hashPath(w io.Writer, p string) error {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return hashDir(w, p, nil)
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// hashDir writes the names, modes and contents of the files of a directory to
+// w, leaving out the excluded ones.
+func hashDir(w io.Writer, src string, exclude []string) error {
+	return filepath.Walk(src, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath != "." && isExcluded(relPath, exclude) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		fmt.Fprintf(w, "%s\x00%s\x00", relPath, fi.Mode())
+		switch {
+		case fi.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(w, f); err != nil {
+				return err
+			}
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(w, target)
+		}
+		fmt.Fprint(w, "\x00")
+		return nil
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "setup.sh")
+	os.WriteFile(script, []byte("apt-get install -y nginx"), 0644)
+
+	testFingerprint := func(config *Config, source string) string {
+		t.Helper()
+		fp, err := fingerprint(config, source)
+		if err != nil {
+			t.Fatalf("failed to compute fingerprint: %s", err)
+		}
+		return fp
+	}
+
+	config := &Config{
+		Changes:              []string{"EXPOSE 80"},
+		FingerprintFiles:     []string{script},
+		FingerprintVariables: map[string]string{"nginx": "1.26", "debian": "12"},
+	}
+	expected := testFingerprint(config, "ubuntu@sha256:1234")
+
+	if testFingerprint(config, "ubuntu@sha256:1234") != expected {
+		t.Errorf("fingerprint should be stable")
+	}
+	if testFingerprint(config, "ubuntu@sha256:5678") == expected {
+		t.Errorf("source image should change the fingerprint")
+	}
+
+	config.Changes = []string{"EXPOSE 8080"}
+	if testFingerprint(config, "ubuntu@sha256:1234") == expected {
+		t.Errorf("changes should change the fingerprint")
+	}
+	config.Changes = []string{"EXPOSE 80"}
+
+	config.FingerprintVariables["nginx"] = "1.27"
+	if testFingerprint(config, "ubuntu@sha256:1234") == expected {
+		t.Errorf("variables should change the fingerprint")
+	}
+	config.FingerprintVariables["nginx"] = "1.26"
+
+	os.WriteFile(script, []byte("apt-get install -y nginx-full"), 0644)
+	if testFingerprint(config, "ubuntu@sha256:1234") == expected {
+		t.Errorf("files should change the fingerprint")
+	}
+}
+
+func TestFingerprint_options(t *testing.T) {
+	options := map[string]func(*Config){
+		"author":                 func(c *Config) { c.Author = "ops@example.com" },
+		"image_config":           func(c *Config) { c.ImageConfig.Cmd = []string{"nginx"} },
+		"labels":                 func(c *Config) { c.Labels = map[string]string{"team": "web"} },
+		"oci_annotations":        func(c *Config) { c.OCIAnnotations = true },
+		"commit_per_provisioner": func(c *Config) { c.CommitPerProvisioner = true },
+		"squash":                 func(c *Config) { c.Squash = true },
+		"squash_all":             func(c *Config) { c.SquashAll = true },
+		"pre_commit_command":     func(c *Config) { c.PreCommitCommand = "apt-get clean" },
+		"cleanup_paths":          func(c *Config) { c.CleanupPaths = []string{"/var/cache/apt"} },
+		"cleanup_presets":        func(c *Config) { c.CleanupPresets = []string{"apt"} },
+		"container_dir":          func(c *Config) { c.ContainerDir = "/tmp/packer" },
+		"device":                 func(c *Config) { c.Device = []string{"/dev/fuse"} },
+		"cap_add":                func(c *Config) { c.CapAdd = []string{"SYS_ADMIN"} },
+		"cap_drop":               func(c *Config) { c.CapDrop = []string{"NET_RAW"} },
+		"privileged":             func(c *Config) { c.Privileged = true },
+		"runtime":                func(c *Config) { c.Runtime = "runsc" },
+		"exec_user":              func(c *Config) { c.ExecUser = "app" },
+		"exec_workdir":           func(c *Config) { c.ExecWorkdir = "/app" },
+		"exec_env":               func(c *Config) { c.ExecEnv = map[string]string{"DEBIAN_FRONTEND": "noninteractive"} },
+		"message":                func(c *Config) { c.Message = "release" },
+		"run_command":            func(c *Config) { c.RunCommand = []string{"-d", "-i", "-t", "{{.Image}}", "/bin/bash"} },
+		"shell":                  func(c *Config) { c.Shell = []string{"/bin/bash", "-c"} },
+		"no_shell":               func(c *Config) { c.NoShell = true },
+		"pause_command":          func(c *Config) { c.PauseCommand = []string{"sleep", "infinity"} },
+		"tmpfs":                  func(c *Config) { c.TmpFs = []string{"/run"} },
+		"volumes":                func(c *Config) { c.Volumes = map[string]string{"/cache": "/var/cache"} },
+		"fix_upload_owner":       func(c *Config) { c.FixUploadOwner = true },
+		"upload_owner":           func(c *Config) { c.UploadOwner = "app" },
+		"upload_mode":            func(c *Config) { c.UploadMode = "0600" },
+		"upload_owner_method":    func(c *Config) { c.UploadOwnerMethod = UploadOwnerArchive },
+		"windows_container":      func(c *Config) { c.WindowsContainer = true },
+		"platform":               func(c *Config) { c.Platform = "linux/arm64" },
+	}
+
+	expected, err := fingerprint(&Config{}, "ubuntu@sha256:1234")
+	if err != nil {
+		t.Fatalf("failed to compute fingerprint: %s", err)
+	}
+	for name, set := range options {
+		t.Run(name, func(t *testing.T) {
+			config := &Config{}
+			set(config)
+			fp, err := fingerprint(config, "ubuntu@sha256:1234")
+			if err != nil {
+				t.Fatalf("failed to compute fingerprint: %s", err)
+			}
+			if fp == expected {
+				t.Errorf("%s should change the fingerprint", name)
+			}
+		})
+	}
+}
+
+func TestFingerprint_buildContext(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "app.conf"), []byte("port = 80"), 0644)
+	os.WriteFile(filepath.Join(dir, "debug.log"), []byte("noise"), 0644)
+	os.WriteFile(filepath.Join(dir, ".dockerignore"), []byte("# logs\n*.log\n"), 0644)
+
+	config := &Config{
+		BuildConfig: DockerfileBootstrapConfig{
+			Content:  "FROM alpine\nCOPY app.conf /etc/app.conf\n",
+			BuildDir: dir,
+		},
+	}
+	fingerprints := map[string]bool{}
+	testFingerprint := func() string {
+		t.Helper()
+		fp, err := fingerprint(config, "sha256:built")
+		if err != nil {
+			t.Fatalf("failed to compute fingerprint: %s", err)
+		}
+		fingerprints[fp] = true
+		return fp
+	}
+
+	expected := testFingerprint()
+
+	os.WriteFile(filepath.Join(dir, "debug.log"), []byte("more noise"), 0644)
+	if testFingerprint() != expected {
+		t.Errorf("ignored files should not change the fingerprint")
+	}
+
+	os.WriteFile(filepath.Join(dir, "app.conf"), []byte("port = 8080"), 0644)
+	testFingerprint()
+
+	config.BuildConfig.Content = "FROM alpine:3.20\nCOPY app.conf /etc/app.conf\n"
+	testFingerprint()
+
+	config.BuildConfig.Arguments = map[string]string{"VERSION": "1.0"}
+	testFingerprint()
+
+	if len(fingerprints) != 4 {
+		t.Errorf("context, Dockerfile and arguments should change the fingerprint")
+	}
+}
@@ -0,0 +1,833 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ext4 filesystems are written without a journal, with 4 KiB blocks and
+// 256-byte inodes. Files are mapped with extents, and the bitmaps and
+// inode tables of all the groups are packed at the start of the
+// filesystem, as allowed by flex_bg. Extended attributes are stored in the
+// inodes, or in a block of their own when they don't fit.
+const (
+	ext4BlockSize       = 4096
+	ext4BlocksPerGroup  = 8 * ext4BlockSize
+	ext4InodeSize       = 256
+	ext4ExtraInodeSize  = 32
+	ext4InodesPerBlock  = ext4BlockSize / ext4InodeSize
+	ext4DescSize        = 32
+	ext4RootIno         = 2
+	ext4FirstIno        = 11
+	ext4InodeRatio      = 16384
+	ext4MaxExtentLen    = 32768
+	ext4ExtentsPerBlock = (ext4BlockSize - 12) / 12
+	ext4ExtentMagic     = 0xf30a
+	ext4ExtentsFlag     = 0x80000
+	ext4MaxLinks        = 65000
+	ext4XattrMagic      = 0xea020000
+	ext4XattrHeaderSize = 32
+	// ext4IbodyXattrSize is the space left for extended attributes after
+	// the extra fields of inodes and the magic number.
+	ext4IbodyXattrSize = ext4InodeSize - 0x80 - ext4ExtraInodeSize - 4
+
+	ext4FeatureCompatExtAttr = 0x8
+
+	ext4FeatureIncompatFiletype  = 0x2
+	ext4FeatureIncompatExtents   = 0x40
+	ext4FeatureIncompatFlexBG    = 0x200
+	ext4FeatureROCompatSparse    = 0x1
+	ext4FeatureROCompatLargeFile = 0x2
+	ext4FeatureROCompatDirNlink  = 0x20
+	ext4FeatureROCompatExtraSize = 0x40
+)
+
+var errExt4TooSmall = errors.New("filesystem too small")
+
+// ext4XattrIndexes are the indexes of the namespaces of fsXattrPrefixes.
+var ext4XattrIndexes = []uint8{1, 4, 6}
+
+// ext4Options are the options of the ext4 filesystems written from
+// exported containers.
+type ext4Options struct {
+	// Size is the size of the filesystem, or 0 to size it from the files
+	// and Headroom.
+	Size int64
+	// Headroom is the percentage of free space and inodes added to the
+	// ones the files take.
+	Headroom int
+	Label    string
+	UUID     [16]byte
+	Now      time.Time
+}
+
+type ext4Extent struct {
+	start uint32
+	len   uint32
+}
+
+type ext4Inode struct {
+	ino   uint32
+	node  *fsNode
+	nlink uint32
+	// data is the contents of directories and long symlinks.
+	data []byte
+	// offset is the offset of the contents of regular files in the spool.
+	offset  int64
+	blocks  uint32
+	extents []ext4Extent
+	// leaves are the blocks of the extents, when they don't fit in the
+	// inode.
+	leaves []uint32
+	// ibodyXattrs are the extended attributes stored in the inode, and
+	// xattrBlock the block at xattrLoc holding them when they don't fit.
+	ibodyXattrs []byte
+	xattrBlock  []byte
+	xattrLoc    uint32
+}
+
+func (in *ext4Inode) size() int64 {
+	switch {
+	case in.data != nil:
+		return int64(len(in.data))
+	case in.node.mode&modeTypeMask == modeSymlink:
+		return int64(len(in.node.target))
+	}
+	return in.node.size
+}
+
+// ext4Alloc allocates the blocks of a filesystem in order, around the
+// blocks reserved for the superblock and group descriptors.
+type ext4Alloc struct {
+	next     uint64
+	reserved [][2]uint64
+	used     [][2]uint64
+}
+
+func (a *ext4Alloc) skip() {
+	for _, r := range a.reserved {
+		if a.next >= r[0] && a.next < r[1] {
+			a.next = r[1]
+		}
+	}
+}
+
+// run allocates n blocks, in as few extents as possible.
+func (a *ext4Alloc) run(n uint64) []ext4Extent {
+	var extents []ext4Extent
+	for n > 0 {
+		a.skip()
+		end := a.next + n
+		for _, r := range a.reserved {
+			if r[0] > a.next && r[0] < end {
+				end = r[0]
+			}
+		}
+		if end-a.next > ext4MaxExtentLen {
+			end = a.next + ext4MaxExtentLen
+		}
+		extents = append(extents, ext4Extent{start: uint32(a.next), len: uint32(end - a.next)})
+		a.used = append(a.used, [2]uint64{a.next, end})
+		n -= end - a.next
+		a.next = end
+	}
+	return extents
+}
+
+// contiguous allocates n contiguous blocks.
+func (a *ext4Alloc) contiguous(n uint64) uint32 {
+	for moved := true; moved; {
+		moved = false
+		a.skip()
+		for _, r := range a.reserved {
+			if r[0] > a.next && r[0] < a.next+n {
+				a.next, moved = r[1], true
+			}
+		}
+	}
+	start := a.next
+	a.used = append(a.used, [2]uint64{start, start + n})
+	a.next += n
+	return uint32(start)
+}
+
+// ext4HasSuper returns whether a group holds a copy of the superblock, with
+// sparse_super: groups 0, 1 and the powers of 3, 5 and 7.
+func ext4HasSuper(group uint32) bool {
+	if group <= 1 {
+		return true
+	}
+	for _, base := range []uint32{3, 5, 7} {
+		n := base
+		for n < group {
+			n *= base
+		}
+		if n == group {
+			return true
+		}
+	}
+	return false
+}
+
+type ext4Writer struct {
+	opts   ext4Options
+	spool  *os.File
+	inodes []*ext4Inode
+
+	blocks       uint64
+	groups       uint32
+	inodesPerGrp uint32
+	gdtBlocks    uint32
+	blockBitmaps []uint32
+	inodeBitmaps []uint32
+	inodeTables  []uint32
+	alloc        *ext4Alloc
+}
+
+// writeExt4 writes an ext4 filesystem with the files of the `docker export`
+// tar stream r to dst.
+func writeExt4(r io.Reader, dst *os.File, opts ext4Options) error {
+	spool, err := os.CreateTemp(filepath.Dir(dst.Name()), ".packer-ext4-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	offsets := map[*fsNode]int64{}
+	var offset int64
+	root, err := readFSTree(r, func(n *fsNode, r io.Reader) error {
+		offsets[n] = offset
+		written, err := io.Copy(spool, r)
+		offset += written
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	w := &ext4Writer{opts: opts, spool: spool}
+	if err := w.addInodes(root, offsets); err != nil {
+		return err
+	}
+	if err := w.layout(); err != nil {
+		return err
+	}
+	return w.write(dst)
+}
+
+// addInodes numbers the inodes of the files, from the root and
+// lost+found, and builds the contents of the directories and the extended
+// attributes.
+func (w *ext4Writer) addInodes(root *fsNode, offsets map[*fsNode]int64) error {
+	if _, ok := root.children["lost+found"]; !ok {
+		root.children["lost+found"] = &fsNode{
+			name:     "lost+found",
+			mode:     modeDir | 0700,
+			mtime:    w.opts.Now,
+			children: map[string]*fsNode{},
+		}
+	}
+
+	inodes := map[*fsNode]*ext4Inode{}
+	add := func(n *fsNode, ino uint32) *ext4Inode {
+		in := &ext4Inode{ino: ino, node: n, nlink: n.nlink, offset: offsets[n]}
+		if n.mode&modeTypeMask == modeSymlink && len(n.target) >= 60 {
+			in.data = []byte(n.target)
+		}
+		inodes[n] = in
+		w.inodes = append(w.inodes, in)
+		return in
+	}
+	add(root, ext4RootIno)
+	add(root.children["lost+found"], ext4FirstIno)
+
+	next := uint32(ext4FirstIno + 1)
+	var walk func(n *fsNode)
+	walk = func(n *fsNode) {
+		for _, c := range n.sortedChildren() {
+			if _, ok := inodes[c.inode()]; !ok {
+				add(c.inode(), next)
+				next++
+			}
+			if c.isDir() {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+
+	// The directories link to themselves, their parent, and their
+	// subdirectories, with "." and "..".
+	var dirs func(n *fsNode, parent uint32)
+	dirs = func(n *fsNode, parent uint32) {
+		in := inodes[n]
+		entries := []ext4DirEntry{{".", in.ino, n.mode}, {"..", parent, modeDir}}
+		subdirs := uint32(0)
+		for _, c := range n.sortedChildren() {
+			child := inodes[c.inode()]
+			entries = append(entries, ext4DirEntry{c.name, child.ino, child.node.mode})
+			if c.isDir() {
+				subdirs++
+				dirs(c, in.ino)
+			}
+		}
+		in.data = ext4DirBlocks(entries)
+		in.nlink = 2 + subdirs
+		if in.nlink >= ext4MaxLinks {
+			in.nlink = 1
+		}
+	}
+	dirs(root, ext4RootIno)
+
+	for _, in := range w.inodes {
+		in.blocks = uint32((in.size() + ext4BlockSize - 1) / ext4BlockSize)
+		if in.node.mode&modeTypeMask != modeRegular && in.data == nil {
+			in.blocks = 0
+		}
+
+		if len(in.node.xattrs) == 0 {
+			continue
+		}
+		in.ibodyXattrs = ext4Xattrs(in.node.xattrs, ext4IbodyXattrSize, 0)
+		if in.ibodyXattrs == nil {
+			in.xattrBlock = ext4XattrBlock(in.node.xattrs)
+			if in.xattrBlock == nil {
+				return fmt.Errorf("the extended attributes of %s are too large", in.node.name)
+			}
+		}
+	}
+	return nil
+}
+
+// ext4Xattrs lays out extended attributes in size bytes, with the entries
+// from start and the values at the end, as in inodes and blocks. It returns
+// nil if they don't fit.
+func ext4Xattrs(xattrs map[string]string, size, start int) []byte {
+	type entry struct {
+		index       uint8
+		name, value string
+	}
+	var entries []entry
+	for name, value := range xattrs {
+		i, rest := xattrPrefix(name)
+		entries = append(entries, entry{ext4XattrIndexes[i], rest, value})
+	}
+	// The entries are sorted like the kernel looks them up.
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		if len(a.name) != len(b.name) {
+			return len(a.name) < len(b.name)
+		}
+		return a.name < b.name
+	})
+
+	le := binary.LittleEndian
+	b := make([]byte, size)
+	pos, end := start, size
+	for _, e := range entries {
+		entrySize := (16 + len(e.name) + 3) &^ 3
+		valueSize := (len(e.value) + 3) &^ 3
+		// The last entry is followed by 4 zero bytes.
+		if len(e.name) > 255 || pos+entrySize+4 > end-valueSize {
+			return nil
+		}
+		end -= valueSize
+		copy(b[end:], e.value)
+
+		x := b[pos:]
+		x[0x0] = uint8(len(e.name))
+		x[0x1] = e.index
+		if len(e.value) > 0 {
+			le.PutUint16(x[0x2:], uint16(end))
+		}
+		le.PutUint32(x[0x8:], uint32(len(e.value)))
+		le.PutUint32(x[0xc:], ext4XattrHash(e.name, e.value))
+		copy(x[0x10:], e.name)
+		pos += entrySize
+	}
+	return b
+}
+
+// ext4XattrBlock returns a block holding extended attributes, or nil if
+// they don't fit.
+func ext4XattrBlock(xattrs map[string]string) []byte {
+	b := ext4Xattrs(xattrs, ext4BlockSize, ext4XattrHeaderSize)
+	if b == nil {
+		return nil
+	}
+	le := binary.LittleEndian
+	var hash uint32
+	for x := b[ext4XattrHeaderSize:]; le.Uint32(x) != 0; x = x[(16+int(x[0])+3)&^3:] {
+		hash = hash<<16 ^ hash>>16 ^ le.Uint32(x[0xc:])
+	}
+	le.PutUint32(b[0x0:], ext4XattrMagic)
+	le.PutUint32(b[0x4:], 1)
+	le.PutUint32(b[0x8:], 1)
+	le.PutUint32(b[0xc:], hash)
+	return b
+}
+
+// ext4XattrHash returns the hash of an extended attribute, from its name in
+// its namespace and its value.
+func ext4XattrHash(name, value string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		hash = hash<<5 ^ hash>>27 ^ uint32(name[i])
+	}
+	for i := 0; i < len(value); i += 4 {
+		var word [4]byte
+		copy(word[:], value[i:])
+		hash = hash<<16 ^ hash>>16 ^ binary.LittleEndian.Uint32(word[:])
+	}
+	return hash
+}
+
+// layout sizes the filesystem and allocates its blocks.
+func (w *ext4Writer) layout() error {
+	var data uint64
+	for _, in := range w.inodes {
+		data += uint64(in.blocks)
+		if in.xattrBlock != nil {
+			data++
+		}
+	}
+
+	blocks := uint64(w.opts.Size / ext4BlockSize)
+	if w.opts.Size == 0 {
+		blocks = data + data*uint64(w.opts.Headroom)/100 + 64
+	}
+	for {
+		used, err := w.allocate(blocks)
+		// Without a size, the headroom is left free once the metadata is
+		// allocated.
+		want := used + used*uint64(w.opts.Headroom)/100
+		if err == nil && used <= w.blocks && (w.opts.Size != 0 || w.blocks >= want) {
+			return nil
+		}
+		if w.opts.Size != 0 {
+			return fmt.Errorf("export_fs_size is too small, the files need %s", formatBytes(int64(used*ext4BlockSize)))
+		}
+		if want <= blocks {
+			want = blocks + blocks/10 + 1
+		}
+		blocks = want
+	}
+}
+
+// allocate lays out a filesystem of the given number of blocks, and
+// returns the number of blocks used. It returns errExt4TooSmall if the
+// filesystem has too few groups for its inodes.
+func (w *ext4Writer) allocate(blocks uint64) (uint64, error) {
+	if blocks > 1<<32-1 {
+		return 0, fmt.Errorf("filesystems larger than 16 TiB are not supported")
+	}
+	groups := uint32((blocks + ext4BlocksPerGroup - 1) / ext4BlocksPerGroup)
+	gdtBlocks := (groups*ext4DescSize + ext4BlockSize - 1) / ext4BlockSize
+	// The last group must be large enough for its copy of the superblock.
+	last := blocks - uint64(groups-1)*ext4BlocksPerGroup
+	if groups > 1 && ext4HasSuper(groups-1) && last < uint64(gdtBlocks)+2 {
+		blocks += uint64(gdtBlocks) + 2 - last
+	}
+
+	// Inodes are added for the headroom, and at least one per 16 KiB like
+	// mke2fs does.
+	minInodes := uint64(len(w.inodes) + ext4FirstIno)
+	inodes := minInodes + minInodes*uint64(w.opts.Headroom)/100
+	if n := blocks * ext4BlockSize / ext4InodeRatio; n > inodes {
+		inodes = n
+	}
+	perGroup := (inodes + uint64(groups) - 1) / uint64(groups)
+	perGroup = (perGroup + ext4InodesPerBlock - 1) / ext4InodesPerBlock * ext4InodesPerBlock
+	if perGroup > ext4BlocksPerGroup {
+		if minInodes > uint64(groups)*ext4BlocksPerGroup {
+			return (minInodes + ext4BlocksPerGroup - 1) / ext4BlocksPerGroup * ext4BlocksPerGroup, errExt4TooSmall
+		}
+		perGroup = ext4BlocksPerGroup
+	}
+
+	w.blocks, w.groups, w.inodesPerGrp, w.gdtBlocks = blocks, groups, uint32(perGroup), gdtBlocks
+	w.alloc = &ext4Alloc{}
+	for g := uint32(0); g < groups; g++ {
+		if ext4HasSuper(g) {
+			start := uint64(g) * ext4BlocksPerGroup
+			w.alloc.reserved = append(w.alloc.reserved, [2]uint64{start, start + 1 + uint64(gdtBlocks)})
+		}
+	}
+
+	w.blockBitmaps = make([]uint32, groups)
+	w.inodeBitmaps = make([]uint32, groups)
+	w.inodeTables = make([]uint32, groups)
+	for g := range w.blockBitmaps {
+		w.blockBitmaps[g] = w.alloc.contiguous(1)
+	}
+	for g := range w.inodeBitmaps {
+		w.inodeBitmaps[g] = w.alloc.contiguous(1)
+	}
+	for g := range w.inodeTables {
+		w.inodeTables[g] = w.alloc.contiguous(uint64(w.inodesPerGrp / ext4InodesPerBlock))
+	}
+
+	for _, in := range w.inodes {
+		in.extents = w.alloc.run(uint64(in.blocks))
+		in.leaves = nil
+		if len(in.extents) > 4 {
+			leaves := (len(in.extents) + ext4ExtentsPerBlock - 1) / ext4ExtentsPerBlock
+			if leaves > 4 {
+				return 0, fmt.Errorf("%s is too large", in.node.name)
+			}
+			for i := 0; i < leaves; i++ {
+				in.leaves = append(in.leaves, w.alloc.contiguous(1))
+			}
+		}
+		if in.xattrBlock != nil {
+			in.xattrLoc = w.alloc.contiguous(1)
+		}
+	}
+	return w.alloc.next, nil
+}
+
+type ext4DirEntry struct {
+	name string
+	ino  uint32
+	mode uint32
+}
+
+// ext4DirBlocks returns the blocks of a directory with the entries.
+func ext4DirBlocks(entries []ext4DirEntry) []byte {
+	var buf []byte
+	blockStart, last := 0, 0
+	pad := func() {
+		binary.LittleEndian.PutUint16(buf[last+4:], uint16(blockStart+ext4BlockSize-last))
+		buf = append(buf, make([]byte, blockStart+ext4BlockSize-len(buf))...)
+		blockStart += ext4BlockSize
+	}
+	for _, e := range entries {
+		recLen := 8 + (len(e.name)+3)&^3
+		if len(buf)+recLen > blockStart+ext4BlockSize {
+			pad()
+		}
+		last = len(buf)
+		entry := make([]byte, recLen)
+		binary.LittleEndian.PutUint32(entry[0:], e.ino)
+		binary.LittleEndian.PutUint16(entry[4:], uint16(recLen))
+		entry[6] = uint8(len(e.name))
+		entry[7] = ext4FileType(e.mode)
+		copy(entry[8:], e.name)
+		buf = append(buf, entry...)
+	}
+	pad()
+	return buf
+}
+
+func ext4FileType(mode uint32) uint8 {
+	switch mode & modeTypeMask {
+	case modeRegular:
+		return 1
+	case modeDir:
+		return 2
+	case modeChar:
+		return 3
+	case modeBlock:
+		return 4
+	case modeFifo:
+		return 5
+	case modeSocket:
+		return 6
+	case modeSymlink:
+		return 7
+	}
+	return 0
+}
+
+// write writes the laid out filesystem to dst.
+func (w *ext4Writer) write(dst *os.File) error {
+	if err := dst.Truncate(int64(w.blocks) * ext4BlockSize); err != nil {
+		return err
+	}
+
+	tables := make([][]byte, w.groups)
+	for g := range tables {
+		tables[g] = make([]byte, w.inodesPerGrp*ext4InodeSize)
+	}
+	usedDirs := make([]uint32, w.groups)
+	for _, in := range w.inodes {
+		if err := w.writeData(dst, in); err != nil {
+			return err
+		}
+		g, i := (in.ino-1)/w.inodesPerGrp, (in.ino-1)%w.inodesPerGrp
+		if in.xattrBlock != nil {
+			if _, err := dst.WriteAt(in.xattrBlock, int64(in.xattrLoc)*ext4BlockSize); err != nil {
+				return err
+			}
+		}
+		w.putInode(tables[g][i*ext4InodeSize:], in)
+		if in.node.isDir() {
+			usedDirs[g]++
+		}
+	}
+	for g := range tables {
+		if _, err := dst.WriteAt(tables[g], int64(w.inodeTables[g])*ext4BlockSize); err != nil {
+			return err
+		}
+	}
+
+	// The inodes are used up to the last one, and the blocks allocated or
+	// reserved. The bits past the end of the groups are set.
+	lastIno := w.inodes[len(w.inodes)-1].ino
+	freeInodes := make([]uint32, w.groups)
+	var totalFreeInodes uint64
+	for g := uint32(0); g < w.groups; g++ {
+		bitmap := make([]byte, ext4BlockSize)
+		first := g * w.inodesPerGrp
+		for i := uint32(0); i < ext4BlockSize*8; i++ {
+			if i >= w.inodesPerGrp || first+i < lastIno {
+				bitmap[i/8] |= 1 << (i % 8)
+			} else {
+				freeInodes[g]++
+			}
+		}
+		totalFreeInodes += uint64(freeInodes[g])
+		if _, err := dst.WriteAt(bitmap, int64(w.inodeBitmaps[g])*ext4BlockSize); err != nil {
+			return err
+		}
+	}
+
+	bitmaps := make([]byte, uint64(w.groups)*ext4BlockSize)
+	for _, r := range append(w.alloc.reserved, w.alloc.used...) {
+		for b := r[0]; b < r[1]; b++ {
+			bitmaps[b/8] |= 1 << (b % 8)
+		}
+	}
+	for b := w.blocks; b < uint64(w.groups)*ext4BlocksPerGroup; b++ {
+		bitmaps[b/8] |= 1 << (b % 8)
+	}
+	freeBlocks := make([]uint32, w.groups)
+	var totalFreeBlocks uint64
+	for g := uint32(0); g < w.groups; g++ {
+		bitmap := bitmaps[g*ext4BlockSize : (g+1)*ext4BlockSize]
+		freeBlocks[g] = ext4BlocksPerGroup
+		for _, c := range bitmap {
+			freeBlocks[g] -= uint32(bits.OnesCount8(c))
+		}
+		totalFreeBlocks += uint64(freeBlocks[g])
+		if _, err := dst.WriteAt(bitmap, int64(w.blockBitmaps[g])*ext4BlockSize); err != nil {
+			return err
+		}
+	}
+
+	gdt := make([]byte, w.gdtBlocks*ext4BlockSize)
+	for g := uint32(0); g < w.groups; g++ {
+		d := gdt[g*ext4DescSize:]
+		binary.LittleEndian.PutUint32(d[0x0:], w.blockBitmaps[g])
+		binary.LittleEndian.PutUint32(d[0x4:], w.inodeBitmaps[g])
+		binary.LittleEndian.PutUint32(d[0x8:], w.inodeTables[g])
+		binary.LittleEndian.PutUint16(d[0xc:], uint16(freeBlocks[g]))
+		binary.LittleEndian.PutUint16(d[0xe:], uint16(freeInodes[g]))
+		binary.LittleEndian.PutUint16(d[0x10:], uint16(usedDirs[g]))
+	}
+
+	// The superblock is 1024 bytes into the first block, and at the start
+	// of its copies.
+	for g := uint32(0); g < w.groups; g++ {
+		if !ext4HasSuper(g) {
+			continue
+		}
+		start := int64(g) * ext4BlocksPerGroup * ext4BlockSize
+		offset := start
+		if g == 0 {
+			offset += 1024
+		}
+		if _, err := dst.WriteAt(w.superblock(g, totalFreeBlocks, totalFreeInodes), offset); err != nil {
+			return err
+		}
+		if _, err := dst.WriteAt(gdt, start+ext4BlockSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeData writes the contents of an inode to its blocks, and the leaves
+// of its extents.
+func (w *ext4Writer) writeData(dst *os.File, in *ext4Inode) error {
+	var r io.Reader
+	switch {
+	case in.data != nil:
+		r = bytes.NewReader(in.data)
+	case in.node.mode&modeTypeMask == modeRegular:
+		r = io.NewSectionReader(w.spool, in.offset, in.node.size)
+	default:
+		return nil
+	}
+	for _, e := range in.extents {
+		_, err := io.CopyN(io.NewOffsetWriter(dst, int64(e.start)*ext4BlockSize), r, int64(e.len)*ext4BlockSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	logical := uint32(0)
+	for i, leaf := range in.leaves {
+		block := make([]byte, ext4BlockSize)
+		logical = ext4PutExtents(block, ext4LeafExtents(in.extents, i), logical, ext4ExtentsPerBlock)
+		if _, err := dst.WriteAt(block, int64(leaf)*ext4BlockSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ext4LeafExtents returns the extents of the ith leaf.
+func ext4LeafExtents(extents []ext4Extent, i int) []ext4Extent {
+	extents = extents[i*ext4ExtentsPerBlock:]
+	if len(extents) > ext4ExtentsPerBlock {
+		extents = extents[:ext4ExtentsPerBlock]
+	}
+	return extents
+}
+
+// ext4PutExtents puts an extent header and the extents, which start at the
+// logical block, in b. It returns the logical block following them.
+func ext4PutExtents(b []byte, extents []ext4Extent, logical uint32, max int) uint32 {
+	binary.LittleEndian.PutUint16(b[0x0:], ext4ExtentMagic)
+	binary.LittleEndian.PutUint16(b[0x2:], uint16(len(extents)))
+	binary.LittleEndian.PutUint16(b[0x4:], uint16(max))
+	for i, e := range extents {
+		x := b[12+12*i:]
+		binary.LittleEndian.PutUint32(x[0x0:], logical)
+		binary.LittleEndian.PutUint16(x[0x4:], uint16(e.len))
+		binary.LittleEndian.PutUint32(x[0x8:], e.start)
+		logical += e.len
+	}
+	return logical
+}
+
+func (w *ext4Writer) putInode(b []byte, in *ext4Inode) {
+	le := binary.LittleEndian
+	n := in.node
+	le.PutUint16(b[0x0:], uint16(n.mode))
+	le.PutUint16(b[0x2:], uint16(n.uid))
+	le.PutUint32(b[0x4:], uint32(in.size()))
+	le.PutUint16(b[0x18:], uint16(n.gid))
+	le.PutUint16(b[0x1a:], uint16(in.nlink))
+	blocks := in.blocks + uint32(len(in.leaves))
+	if in.xattrBlock != nil {
+		blocks++
+	}
+	le.PutUint32(b[0x1c:], blocks*(ext4BlockSize/512))
+	le.PutUint32(b[0x68:], in.xattrLoc)
+	le.PutUint32(b[0x6c:], uint32(in.size()>>32))
+	le.PutUint16(b[0x78:], uint16(n.uid>>16))
+	le.PutUint16(b[0x7a:], uint16(n.gid>>16))
+	le.PutUint16(b[0x80:], ext4ExtraInodeSize)
+	if in.ibodyXattrs != nil {
+		le.PutUint32(b[0x80+ext4ExtraInodeSize:], ext4XattrMagic)
+		copy(b[0x80+ext4ExtraInodeSize+4:], in.ibodyXattrs)
+	}
+
+	// The access, change, modification and creation times.
+	sec, extra := ext4Time(n.mtime)
+	for _, off := range [][2]int{{0x8, 0x8c}, {0xc, 0x84}, {0x10, 0x88}, {0x90, 0x94}} {
+		le.PutUint32(b[off[0]:], sec)
+		le.PutUint32(b[off[1]:], extra)
+	}
+
+	iblock := b[0x28 : 0x28+60]
+	switch n.mode & modeTypeMask {
+	case modeSymlink:
+		if in.data == nil {
+			copy(iblock, n.target)
+			return
+		}
+	case modeChar, modeBlock:
+		if n.devmajor < 256 && n.devminor < 256 {
+			le.PutUint32(iblock[0x0:], n.devmajor<<8|n.devminor)
+		} else {
+			le.PutUint32(iblock[0x4:], n.devminor&0xff|n.devmajor<<8|(n.devminor&^0xff)<<12)
+		}
+		return
+	case modeFifo, modeSocket:
+		return
+	}
+
+	le.PutUint32(b[0x20:], ext4ExtentsFlag)
+	if len(in.leaves) == 0 {
+		ext4PutExtents(iblock, in.extents, 0, 4)
+		return
+	}
+	// The inode indexes the leaves holding the extents.
+	le.PutUint16(iblock[0x0:], ext4ExtentMagic)
+	le.PutUint16(iblock[0x2:], uint16(len(in.leaves)))
+	le.PutUint16(iblock[0x4:], 4)
+	le.PutUint16(iblock[0x6:], 1)
+	logical := uint32(0)
+	for i, leaf := range in.leaves {
+		x := iblock[12+12*i:]
+		le.PutUint32(x[0x0:], logical)
+		le.PutUint32(x[0x4:], leaf)
+		for _, e := range ext4LeafExtents(in.extents, i) {
+			logical += e.len
+		}
+	}
+}
+
+// ext4Time returns the seconds and extra field of a timestamp, which holds
+// its nanoseconds and the epoch of its seconds.
+func ext4Time(t time.Time) (uint32, uint32) {
+	sec := t.Unix()
+	epoch := uint32((sec-int64(int32(sec)))>>32) & 3
+	return uint32(sec), uint32(t.Nanosecond())<<2 | epoch
+}
+
+func (w *ext4Writer) superblock(group uint32, freeBlocks, freeInodes uint64) []byte {
+	le := binary.LittleEndian
+	sb := make([]byte, 1024)
+	now := uint32(w.opts.Now.Unix())
+	le.PutUint32(sb[0x0:], w.groups*w.inodesPerGrp)
+	le.PutUint32(sb[0x4:], uint32(w.blocks))
+	le.PutUint32(sb[0xc:], uint32(freeBlocks))
+	le.PutUint32(sb[0x10:], uint32(freeInodes))
+	// 4 KiB blocks and clusters.
+	le.PutUint32(sb[0x18:], 2)
+	le.PutUint32(sb[0x1c:], 2)
+	le.PutUint32(sb[0x20:], ext4BlocksPerGroup)
+	le.PutUint32(sb[0x24:], ext4BlocksPerGroup)
+	le.PutUint32(sb[0x28:], w.inodesPerGrp)
+	le.PutUint32(sb[0x30:], now)
+	le.PutUint16(sb[0x36:], 0xffff)
+	le.PutUint16(sb[0x38:], 0xef53)
+	// Clean, and continue on errors.
+	le.PutUint16(sb[0x3a:], 1)
+	le.PutUint16(sb[0x3c:], 1)
+	le.PutUint32(sb[0x40:], now)
+	le.PutUint32(sb[0x4c:], 1)
+	le.PutUint32(sb[0x54:], ext4FirstIno)
+	le.PutUint16(sb[0x58:], ext4InodeSize)
+	le.PutUint16(sb[0x5a:], uint16(group))
+	le.PutUint32(sb[0x5c:], ext4FeatureCompatExtAttr)
+	le.PutUint32(sb[0x60:], ext4FeatureIncompatFiletype|ext4FeatureIncompatExtents|ext4FeatureIncompatFlexBG)
+	le.PutUint32(sb[0x64:], ext4FeatureROCompatSparse|ext4FeatureROCompatLargeFile|ext4FeatureROCompatDirNlink|ext4FeatureROCompatExtraSize)
+	copy(sb[0x68:0x78], w.opts.UUID[:])
+	copy(sb[0x78:0x88], w.opts.Label)
+	le.PutUint32(sb[0x108:], now)
+	le.PutUint16(sb[0x15c:], ext4ExtraInodeSize)
+	le.PutUint16(sb[0x15e:], ext4ExtraInodeSize)
+	// Groups of 16 groups for flex_bg.
+	sb[0x174] = 4
+	return sb
+}
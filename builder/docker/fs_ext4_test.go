@@ -0,0 +1,110 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCapability is a security.capability extended attribute, granting
+// cap_net_raw.
+const testCapability = "\x01\x00\x00\x02\x00\x20\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+
+// testExportedContainer returns a `docker export` archive with files of
+// each type, and extended attributes.
+func testExportedContainer(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	mtime := time.Unix(1700000000, 0)
+	for _, hdr := range []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "bin/sh", Typeflag: tar.TypeReg, Mode: 0755, Size: 2},
+		{Name: "bin/bash", Typeflag: tar.TypeLink, Linkname: "bin/sh"},
+		{Name: "bin/ping", Typeflag: tar.TypeReg, Mode: 0755, PAXRecords: map[string]string{
+			"SCHILY.xattr.security.capability": testCapability,
+		}},
+		{Name: "etc/hostname", Typeflag: tar.TypeReg, Mode: 0644, Size: 5, Uid: 1000, Gid: 1000},
+		// The attributes don't fit in the inode of ext4.
+		{Name: "etc/big", Typeflag: tar.TypeReg, Mode: 0600, Size: 3*ext4BlockSize + 1, PAXRecords: map[string]string{
+			"SCHILY.xattr.user.comment": strings.Repeat("c", 200),
+			"SCHILY.xattr.user.empty":   "",
+		}},
+		{Name: "etc/empty", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/localtime", Typeflag: tar.TypeSymlink, Linkname: "/usr/share/zoneinfo/UTC", PAXRecords: map[string]string{
+			"SCHILY.xattr.security.selinux": "system_u:object_r:locale_t:s0\x00",
+		}},
+		{Name: "etc/long", Typeflag: tar.TypeSymlink, Linkname: "/" + strings.Repeat("long/", 20)},
+		{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+		{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0660, Devmajor: 8, Devminor: 300},
+		{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0600},
+	} {
+		hdr.ModTime = mtime
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		switch hdr.Name {
+		case "bin/sh":
+			tw.Write([]byte("sh"))
+		case "etc/hostname":
+			tw.Write([]byte("host\n"))
+		case "etc/big":
+			tw.Write(bytes.Repeat([]byte("b"), int(hdr.Size)))
+		}
+	}
+	for i := 0; i < 200; i++ {
+		name := "usr/share/many/" + strings.Repeat("x", 40) + string(rune('a'+i%26)) + strings.Repeat("y", i%7) + string(rune('0'+i/26))
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, ModTime: mtime}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriteExt4(t *testing.T) {
+	dst, err := os.Create(filepath.Join(t.TempDir(), "rootfs.ext4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	opts := ext4Options{Headroom: 20, Label: "rootfs", Now: time.Unix(1700000000, 0)}
+	copy(opts.UUID[:], "0123456789abcdef")
+	if err := writeExt4(bytes.NewReader(testExportedContainer(t)), dst, opts); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if _, err := exec.LookPath("e2fsck"); err != nil {
+		t.Skip("e2fsck not found")
+	}
+	out, err := exec.Command("e2fsck", "-fn", dst.Name()).CombinedOutput()
+	if err != nil {
+		t.Fatalf("e2fsck: %s\n%s", err, out)
+	}
+	t.Logf("%s", out)
+
+	if _, err := exec.LookPath("debugfs"); err != nil {
+		t.Skip("debugfs not found")
+	}
+	for name, expected := range map[string]string{
+		"/bin/ping":      "security.capability (20)",
+		"/etc/big":       "user.comment (200)",
+		"/etc/localtime": `security.selinux (30) = "system_u:object_r:locale_t:s0\000"`,
+	} {
+		out, err := exec.Command("debugfs", "-R", "ea_list "+name, dst.Name()).CombinedOutput()
+		if err != nil || !strings.Contains(string(out), expected) {
+			t.Errorf("%s: expected %s: %v\n%s", name, expected, err, out)
+		}
+	}
+}
@@ -0,0 +1,527 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// squashfs filesystems are written in the 4.0 format, with 128 KiB blocks
+// compressed with zlib and without fragments, so that the tail of files is
+// in a last, smaller block. Files with extended attributes have extended
+// inodes, which reference them.
+const (
+	squashfsMagic        = 0x73717368
+	squashfsBlockSize    = 128 << 10
+	squashfsBlockLog     = 17
+	squashfsMetadataSize = 8192
+	squashfsSuperSize    = 96
+	squashfsCompZlib     = 1
+	squashfsInvalid      = 0xffffffffffffffff
+	squashfsNoFragment   = 0xffffffff
+	squashfsNoXattr      = 0xffffffff
+	squashfsUncompressed = 1 << 24
+
+	squashfsFlagNoFragments = 0x10
+	squashfsFlagNoXattrs    = 0x200
+
+	squashfsTypeDir      = 1
+	squashfsTypeFile     = 2
+	squashfsTypeSymlink  = 3
+	squashfsTypeBlock    = 4
+	squashfsTypeChar     = 5
+	squashfsTypeFifo     = 6
+	squashfsTypeSocket   = 7
+	squashfsTypeLDir     = 8
+	squashfsTypeLFile    = 9
+	squashfsTypeLSymlink = 10
+	squashfsTypeLBlock   = 11
+	squashfsTypeLChar    = 12
+	squashfsTypeLFifo    = 13
+	squashfsTypeLSocket  = 14
+)
+
+// squashfsFile is the location of the blocks of a regular file.
+type squashfsFile struct {
+	start uint64
+	sizes []uint32
+}
+
+type squashfsInode struct {
+	ref uint64
+	ino uint32
+}
+
+type squashfsWriter struct {
+	dst    *os.File
+	offset uint64
+	zw     *zlib.Writer
+	buf    bytes.Buffer
+
+	files  map[*fsNode]squashfsFile
+	inos   map[*fsNode]uint32
+	inodes map[*fsNode]squashfsInode
+	ids    map[uint32]uint16
+	idList []uint32
+
+	inodeTable *squashfsMetadata
+	dirTable   *squashfsMetadata
+
+	// xattrs are the extended attributes, as key-value pairs, and
+	// xattrIDs their sets referenced by inodes. Identical sets are shared.
+	xattrs    *squashfsMetadata
+	xattrIDs  *squashfsMetadata
+	xattrSets map[string]uint32
+}
+
+// writeSquashfs writes a squashfs filesystem with the files of the `docker
+// export` tar stream r to dst. The contents of the files are written as
+// they are read, and the inodes and directories once all are.
+func writeSquashfs(r io.Reader, dst *os.File, now time.Time) error {
+	w := &squashfsWriter{
+		dst:    dst,
+		offset: squashfsSuperSize,
+		zw:     zlib.NewWriter(nil),
+		files:  map[*fsNode]squashfsFile{},
+		inos:   map[*fsNode]uint32{},
+		inodes: map[*fsNode]squashfsInode{},
+		ids:    map[uint32]uint16{},
+	}
+	w.inodeTable = &squashfsMetadata{compress: w.compress}
+	w.dirTable = &squashfsMetadata{compress: w.compress}
+	w.xattrs = &squashfsMetadata{compress: w.compress}
+	w.xattrIDs = &squashfsMetadata{compress: w.compress}
+	w.xattrSets = map[string]uint32{}
+
+	root, err := readFSTree(r, w.writeFile)
+	if err != nil {
+		return err
+	}
+
+	// Inodes are numbered as they are written, the children of
+	// directories before them, so that directories know the numbers of
+	// their parents.
+	var number func(n *fsNode)
+	number = func(n *fsNode) {
+		for _, c := range n.sortedChildren() {
+			if c.isDir() {
+				number(c)
+			} else if _, ok := w.inos[c.inode()]; !ok {
+				w.inos[c.inode()] = uint32(len(w.inos) + 1)
+			}
+		}
+		w.inos[n] = uint32(len(w.inos) + 1)
+	}
+	number(root)
+
+	rootRef, err := w.writeDir(root, uint32(len(w.inos)+1))
+	if err != nil {
+		return err
+	}
+
+	inodeTableStart := w.offset
+	if err := w.write(w.inodeTable.finish()); err != nil {
+		return err
+	}
+	dirTableStart := w.offset
+	if err := w.write(w.dirTable.finish()); err != nil {
+		return err
+	}
+
+	ids := &squashfsMetadata{compress: w.compress}
+	for _, id := range w.idList {
+		ids.write(binary.LittleEndian.AppendUint32(nil, id))
+	}
+	idBlocks := w.offset
+	if err := w.write(ids.finish()); err != nil {
+		return err
+	}
+	idTableStart := w.offset
+	var index []byte
+	for _, block := range ids.blocks {
+		index = binary.LittleEndian.AppendUint64(index, idBlocks+block)
+	}
+	if err := w.write(index); err != nil {
+		return err
+	}
+
+	// The extended attributes follow, with the index of the blocks of
+	// their sets at the end.
+	xattrTableStart := uint64(squashfsInvalid)
+	flags := uint16(squashfsFlagNoFragments | squashfsFlagNoXattrs)
+	if len(w.xattrSets) > 0 {
+		flags &^= squashfsFlagNoXattrs
+		kvStart := w.offset
+		if err := w.write(w.xattrs.finish()); err != nil {
+			return err
+		}
+		idBlocks := w.offset
+		if err := w.write(w.xattrIDs.finish()); err != nil {
+			return err
+		}
+		xattrTableStart = w.offset
+		index := binary.LittleEndian.AppendUint64(nil, kvStart)
+		index = binary.LittleEndian.AppendUint32(index, uint32(len(w.xattrSets)))
+		index = binary.LittleEndian.AppendUint32(index, 0)
+		for _, block := range w.xattrIDs.blocks {
+			index = binary.LittleEndian.AppendUint64(index, idBlocks+block)
+		}
+		if err := w.write(index); err != nil {
+			return err
+		}
+	}
+
+	le := binary.LittleEndian
+	sb := make([]byte, squashfsSuperSize)
+	le.PutUint32(sb[0:], squashfsMagic)
+	le.PutUint32(sb[4:], uint32(len(w.inos)))
+	le.PutUint32(sb[8:], uint32(now.Unix()))
+	le.PutUint32(sb[12:], squashfsBlockSize)
+	le.PutUint32(sb[16:], 0)
+	le.PutUint16(sb[20:], squashfsCompZlib)
+	le.PutUint16(sb[22:], squashfsBlockLog)
+	le.PutUint16(sb[24:], flags)
+	le.PutUint16(sb[26:], uint16(len(w.idList)))
+	le.PutUint16(sb[28:], 4)
+	le.PutUint16(sb[30:], 0)
+	le.PutUint64(sb[32:], rootRef)
+	le.PutUint64(sb[40:], w.offset)
+	le.PutUint64(sb[48:], idTableStart)
+	le.PutUint64(sb[56:], xattrTableStart)
+	le.PutUint64(sb[64:], inodeTableStart)
+	le.PutUint64(sb[72:], dirTableStart)
+	le.PutUint64(sb[80:], idBlocks)
+	le.PutUint64(sb[88:], squashfsInvalid)
+	if _, err := dst.WriteAt(sb, 0); err != nil {
+		return err
+	}
+
+	// The filesystem is padded to 4 KiB, for loop devices.
+	if pad := w.offset % 4096; pad != 0 {
+		return w.write(make([]byte, 4096-pad))
+	}
+	return nil
+}
+
+func (w *squashfsWriter) write(b []byte) error {
+	n, err := w.dst.WriteAt(b, int64(w.offset))
+	w.offset += uint64(n)
+	return err
+}
+
+// compress returns the zlib compression of b, or nil if it isn't smaller.
+func (w *squashfsWriter) compress(b []byte) []byte {
+	w.buf.Reset()
+	w.zw.Reset(&w.buf)
+	w.zw.Write(b)
+	w.zw.Close()
+	if w.buf.Len() >= len(b) {
+		return nil
+	}
+	return w.buf.Bytes()
+}
+
+// writeFile writes the blocks of a regular file.
+func (w *squashfsWriter) writeFile(n *fsNode, r io.Reader) error {
+	f := squashfsFile{start: w.offset}
+	block := make([]byte, squashfsBlockSize)
+	for {
+		size, rerr := io.ReadFull(r, block)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+		if size == 0 {
+			break
+		}
+		data := w.compress(block[:size])
+		if data == nil {
+			data = block[:size]
+			f.sizes = append(f.sizes, uint32(size)|squashfsUncompressed)
+		} else {
+			f.sizes = append(f.sizes, uint32(len(data)))
+		}
+		if err := w.write(data); err != nil {
+			return err
+		}
+		if rerr != nil {
+			break
+		}
+	}
+	w.files[n] = f
+	return nil
+}
+
+func (w *squashfsWriter) id(id uint32) uint16 {
+	i, ok := w.ids[id]
+	if !ok {
+		i = uint16(len(w.idList))
+		w.ids[id] = i
+		w.idList = append(w.idList, id)
+	}
+	return i
+}
+
+// xattr returns the index of the set of the extended attributes of a file,
+// writing it if it wasn't yet, or squashfsNoXattr if it has none.
+func (w *squashfsWriter) xattr(n *fsNode) uint32 {
+	if len(n.xattrs) == 0 {
+		return squashfsNoXattr
+	}
+	le := binary.LittleEndian
+	var kv []byte
+	for _, name := range n.sortedXattrs() {
+		i, rest := xattrPrefix(name)
+		kv = le.AppendUint16(kv, uint16(i))
+		kv = le.AppendUint16(kv, uint16(len(rest)))
+		kv = append(kv, rest...)
+		kv = le.AppendUint32(kv, uint32(len(n.xattrs[name])))
+		kv = append(kv, n.xattrs[name]...)
+	}
+	if id, ok := w.xattrSets[string(kv)]; ok {
+		return id
+	}
+
+	id := uint32(len(w.xattrSets))
+	w.xattrSets[string(kv)] = id
+	b := le.AppendUint64(nil, w.xattrs.ref())
+	b = le.AppendUint32(b, uint32(len(n.xattrs)))
+	b = le.AppendUint32(b, uint32(len(kv)))
+	w.xattrIDs.write(b)
+	w.xattrs.write(kv)
+	return id
+}
+
+// inodeHeader returns the header common to all inodes.
+func (w *squashfsWriter) inodeHeader(typ uint16, n *fsNode) []byte {
+	le := binary.LittleEndian
+	b := le.AppendUint16(nil, typ)
+	b = le.AppendUint16(b, uint16(n.mode&07777))
+	b = le.AppendUint16(b, w.id(n.uid))
+	b = le.AppendUint16(b, w.id(n.gid))
+	b = le.AppendUint32(b, uint32(n.mtime.Unix()))
+	return le.AppendUint32(b, w.inos[n])
+}
+
+// writeInode writes the inode of a file other than a directory, once for
+// all its hard links.
+func (w *squashfsWriter) writeInode(n *fsNode) (squashfsInode, error) {
+	if in, ok := w.inodes[n]; ok {
+		return in, nil
+	}
+	le := binary.LittleEndian
+	in := squashfsInode{ref: w.inodeTable.ref(), ino: w.inos[n]}
+	xattr := w.xattr(n)
+
+	// The extended types of files other than regular files only add the
+	// index of their extended attributes.
+	var b []byte
+	switch n.mode & modeTypeMask {
+	case modeRegular:
+		f := w.files[n]
+		if f.start < 1<<32 && n.size < 1<<32 && n.nlink == 1 && xattr == squashfsNoXattr {
+			b = w.inodeHeader(squashfsTypeFile, n)
+			b = le.AppendUint32(b, uint32(f.start))
+			b = le.AppendUint32(b, squashfsNoFragment)
+			b = le.AppendUint32(b, 0)
+			b = le.AppendUint32(b, uint32(n.size))
+		} else {
+			b = w.inodeHeader(squashfsTypeLFile, n)
+			b = le.AppendUint64(b, f.start)
+			b = le.AppendUint64(b, uint64(n.size))
+			b = le.AppendUint64(b, 0)
+			b = le.AppendUint32(b, n.nlink)
+			b = le.AppendUint32(b, squashfsNoFragment)
+			b = le.AppendUint32(b, 0)
+			b = le.AppendUint32(b, xattr)
+		}
+		for _, size := range f.sizes {
+			b = le.AppendUint32(b, size)
+		}
+	case modeSymlink:
+		b = w.inodeHeader(squashfsExtendedType(squashfsTypeSymlink, xattr), n)
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint32(b, uint32(len(n.target)))
+		b = append(b, n.target...)
+	case modeChar, modeBlock:
+		typ := uint16(squashfsTypeChar)
+		if n.mode&modeTypeMask == modeBlock {
+			typ = squashfsTypeBlock
+		}
+		b = w.inodeHeader(squashfsExtendedType(typ, xattr), n)
+		b = le.AppendUint32(b, n.nlink)
+		b = le.AppendUint32(b, n.devminor&0xff|n.devmajor<<8|(n.devminor&^0xff)<<12)
+	case modeFifo, modeSocket:
+		typ := uint16(squashfsTypeFifo)
+		if n.mode&modeTypeMask == modeSocket {
+			typ = squashfsTypeSocket
+		}
+		b = w.inodeHeader(squashfsExtendedType(typ, xattr), n)
+		b = le.AppendUint32(b, n.nlink)
+	default:
+		return in, fmt.Errorf("unsupported file type %o", n.mode&modeTypeMask)
+	}
+	// Regular files hold it before the sizes of their blocks.
+	if xattr != squashfsNoXattr && n.mode&modeTypeMask != modeRegular {
+		b = le.AppendUint32(b, xattr)
+	}
+	w.inodeTable.write(b)
+	w.inodes[n] = in
+	return in, nil
+}
+
+type squashfsDirEntry struct {
+	name string
+	typ  uint16
+	squashfsInode
+}
+
+// writeDir writes the inodes of the children of a directory, its listing
+// and its inode.
+func (w *squashfsWriter) writeDir(n *fsNode, parent uint32) (uint64, error) {
+	var entries []squashfsDirEntry
+	subdirs := uint32(0)
+	for _, c := range n.sortedChildren() {
+		var in squashfsInode
+		var err error
+		if c.isDir() {
+			subdirs++
+			in.ino = w.inos[c]
+			in.ref, err = w.writeDir(c, w.inos[n])
+		} else {
+			in, err = w.writeInode(c.inode())
+		}
+		if err != nil {
+			return 0, err
+		}
+		entries = append(entries, squashfsDirEntry{c.name, squashfsDirType(c.inode().mode), in})
+	}
+
+	// The entries are listed under headers for up to 256 entries whose
+	// inodes are in the same metadata block, with numbers close enough.
+	le := binary.LittleEndian
+	listing := w.dirTable.ref()
+	size := 0
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && j-i < 256 && entries[j].ref>>16 == entries[i].ref>>16 &&
+			int64(entries[j].ino)-int64(entries[i].ino) >= -32768 && int64(entries[j].ino)-int64(entries[i].ino) <= 32767 {
+			j++
+		}
+		b := le.AppendUint32(nil, uint32(j-i-1))
+		b = le.AppendUint32(b, uint32(entries[i].ref>>16))
+		b = le.AppendUint32(b, entries[i].ino)
+		for _, e := range entries[i:j] {
+			b = le.AppendUint16(b, uint16(e.ref&0xffff))
+			b = le.AppendUint16(b, uint16(int16(int64(e.ino)-int64(entries[i].ino))))
+			b = le.AppendUint16(b, e.typ)
+			b = le.AppendUint16(b, uint16(len(e.name)-1))
+			b = append(b, e.name...)
+		}
+		w.dirTable.write(b)
+		size += len(b)
+		i = j
+	}
+
+	// The size of directories counts the "." and ".." entries they don't
+	// list.
+	ref := w.inodeTable.ref()
+	xattr := w.xattr(n)
+	var b []byte
+	if size+3 <= 0xffff && listing>>16 < 1<<32 && xattr == squashfsNoXattr {
+		b = w.inodeHeader(squashfsTypeDir, n)
+		b = le.AppendUint32(b, uint32(listing>>16))
+		b = le.AppendUint32(b, 2+subdirs)
+		b = le.AppendUint16(b, uint16(size+3))
+		b = le.AppendUint16(b, uint16(listing&0xffff))
+		b = le.AppendUint32(b, parent)
+	} else {
+		b = w.inodeHeader(squashfsTypeLDir, n)
+		b = le.AppendUint32(b, 2+subdirs)
+		b = le.AppendUint32(b, uint32(size+3))
+		b = le.AppendUint32(b, uint32(listing>>16))
+		b = le.AppendUint32(b, parent)
+		b = le.AppendUint16(b, 0)
+		b = le.AppendUint16(b, uint16(listing&0xffff))
+		b = le.AppendUint32(b, xattr)
+	}
+	w.inodeTable.write(b)
+	return ref, nil
+}
+
+// squashfsExtendedType returns the extended type of the basic type typ,
+// if the file has extended attributes.
+func squashfsExtendedType(typ uint16, xattr uint32) uint16 {
+	if xattr == squashfsNoXattr {
+		return typ
+	}
+	return typ + squashfsTypeLDir - squashfsTypeDir
+}
+
+func squashfsDirType(mode uint32) uint16 {
+	switch mode & modeTypeMask {
+	case modeDir:
+		return squashfsTypeDir
+	case modeSymlink:
+		return squashfsTypeSymlink
+	case modeBlock:
+		return squashfsTypeBlock
+	case modeChar:
+		return squashfsTypeChar
+	case modeFifo:
+		return squashfsTypeFifo
+	case modeSocket:
+		return squashfsTypeSocket
+	}
+	return squashfsTypeFile
+}
+
+// squashfsMetadata is a table of metadata, written in blocks of 8 KiB
+// compressed separately.
+type squashfsMetadata struct {
+	compress func([]byte) []byte
+	out      []byte
+	pending  []byte
+	// blocks are the offsets of the blocks in the table.
+	blocks []uint64
+}
+
+// ref returns the reference of the next byte written, the offset of its
+// block in the table and its offset in the block.
+func (m *squashfsMetadata) ref() uint64 {
+	return uint64(len(m.out))<<16 | uint64(len(m.pending))
+}
+
+func (m *squashfsMetadata) write(b []byte) {
+	m.pending = append(m.pending, b...)
+	for len(m.pending) >= squashfsMetadataSize {
+		m.flush(m.pending[:squashfsMetadataSize])
+		m.pending = m.pending[squashfsMetadataSize:]
+	}
+}
+
+func (m *squashfsMetadata) flush(b []byte) {
+	m.blocks = append(m.blocks, uint64(len(m.out)))
+	if c := m.compress(b); c != nil {
+		m.out = binary.LittleEndian.AppendUint16(m.out, uint16(len(c)))
+		m.out = append(m.out, c...)
+	} else {
+		m.out = binary.LittleEndian.AppendUint16(m.out, uint16(len(b))|0x8000)
+		m.out = append(m.out, b...)
+	}
+}
+
+// finish flushes the last block, and returns the table.
+func (m *squashfsMetadata) finish() []byte {
+	if len(m.pending) > 0 {
+		m.flush(m.pending)
+		m.pending = nil
+	}
+	return m.out
+}
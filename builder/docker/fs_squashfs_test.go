@@ -0,0 +1,289 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSquashfs is a minimal reader of the squashfs filesystems written by
+// writeSquashfs.
+type testSquashfs struct {
+	t    *testing.T
+	data []byte
+	sb   []byte
+}
+
+func (s *testSquashfs) u16(b []byte) uint16 { return binary.LittleEndian.Uint16(b) }
+func (s *testSquashfs) u32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+func (s *testSquashfs) u64(b []byte) uint64 { return binary.LittleEndian.Uint64(b) }
+
+func (s *testSquashfs) block(b []byte, compressed bool) []byte {
+	if !compressed {
+		return b
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return out
+}
+
+// metadata returns the contents of a metadata table, and the offsets of
+// its blocks in the contents.
+func (s *testSquashfs) metadata(start, end uint64) ([]byte, map[uint64]int) {
+	var out []byte
+	offsets := map[uint64]int{}
+	for pos := start; pos < end; {
+		offsets[pos-start] = len(out)
+		hdr := s.u16(s.data[pos:])
+		size := uint64(hdr & 0x7fff)
+		out = append(out, s.block(s.data[pos+2:pos+2+size], hdr&0x8000 == 0)...)
+		pos += 2 + size
+	}
+	return out, offsets
+}
+
+type testSquashfsEntry struct {
+	name  string
+	typ   uint16
+	inode []byte
+}
+
+// inodeAt returns the inode at ref, a reference in the inode table.
+func (s *testSquashfs) inodeAt(ref uint64) []byte {
+	inodes, offsets := s.metadata(s.u64(s.sb[64:]), s.u64(s.sb[72:]))
+	return inodes[offsets[ref>>16]+int(ref&0xffff):]
+}
+
+// readDir returns the entries of the directory inode dir.
+func (s *testSquashfs) readDir(dir []byte) []testSquashfsEntry {
+	var start, size, offset uint64
+	switch s.u16(dir) {
+	case squashfsTypeDir:
+		start, size, offset = uint64(s.u32(dir[16:])), uint64(s.u16(dir[24:])), uint64(s.u16(dir[26:]))
+	case squashfsTypeLDir:
+		start, size, offset = uint64(s.u32(dir[24:])), uint64(s.u32(dir[20:])), uint64(s.u16(dir[34:]))
+	default:
+		s.t.Fatalf("not a directory: %d", s.u16(dir))
+	}
+	dirs, offsets := s.metadata(s.u64(s.sb[72:]), s.u64(s.sb[80:]))
+	listing := dirs[offsets[start]+int(offset):][:size-3]
+
+	var entries []testSquashfsEntry
+	for len(listing) > 0 {
+		count, block := s.u32(listing)+1, s.u32(listing[4:])
+		listing = listing[12:]
+		for i := uint32(0); i < count; i++ {
+			nameSize := int(s.u16(listing[6:])) + 1
+			entries = append(entries, testSquashfsEntry{
+				name:  string(listing[8 : 8+nameSize]),
+				typ:   s.u16(listing[4:]),
+				inode: s.inodeAt(uint64(block)<<16 | uint64(s.u16(listing))),
+			})
+			listing = listing[8+nameSize:]
+		}
+	}
+	return entries
+}
+
+func (s *testSquashfs) lookup(name string) []byte {
+	in := s.inodeAt(s.u64(s.sb[32:]))
+	for _, part := range strings.Split(name, "/") {
+		var found []byte
+		for _, e := range s.readDir(in) {
+			if e.name == part {
+				found = e.inode
+			}
+		}
+		if found == nil {
+			s.t.Fatalf("%s not found", name)
+		}
+		in = found
+	}
+	return in
+}
+
+// readFile returns the contents of the regular file inode in.
+func (s *testSquashfs) readFile(in []byte) []byte {
+	var start, size uint64
+	var sizes []byte
+	switch s.u16(in) {
+	case squashfsTypeFile:
+		start, size, sizes = uint64(s.u32(in[16:])), uint64(s.u32(in[28:])), in[32:]
+	case squashfsTypeLFile:
+		start, size, sizes = s.u64(in[16:]), s.u64(in[24:]), in[56:]
+	default:
+		s.t.Fatalf("not a file: %d", s.u16(in))
+	}
+	var out []byte
+	for uint64(len(out)) < size {
+		b := s.u32(sizes)
+		sizes = sizes[4:]
+		n := uint64(b &^ squashfsUncompressed)
+		out = append(out, s.block(s.data[start:start+n], b&squashfsUncompressed == 0)...)
+		start += n
+	}
+	return out
+}
+
+// xattrs returns the extended attributes of the set index.
+func (s *testSquashfs) xattrs(index uint32) map[string]string {
+	table := s.u64(s.sb[56:])
+	idBlocks := s.u64(s.data[table+16:])
+	ids, _ := s.metadata(idBlocks, table)
+	kv, offsets := s.metadata(s.u64(s.data[table:]), idBlocks)
+
+	id := ids[16*index:]
+	ref := s.u64(id)
+	entries := kv[offsets[ref>>16]+int(ref&0xffff):]
+	xattrs := map[string]string{}
+	for i := uint32(0); i < s.u32(id[8:]); i++ {
+		typ, size := s.u16(entries), int(s.u16(entries[2:]))
+		name := fsXattrPrefixes[typ] + string(entries[4:4+size])
+		entries = entries[4+size:]
+		size = int(s.u32(entries))
+		xattrs[name] = string(entries[4 : 4+size])
+		entries = entries[4+size:]
+	}
+	return xattrs
+}
+
+func TestWriteSquashfs(t *testing.T) {
+	dst, err := os.Create(filepath.Join(t.TempDir(), "rootfs.squashfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	if err := writeSquashfs(bytes.NewReader(testExportedContainer(t)), dst, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	data, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testSquashfs{t: t, data: data, sb: data[:squashfsSuperSize]}
+
+	if s.u32(s.sb) != squashfsMagic || s.u16(s.sb[28:]) != 4 {
+		t.Fatalf("bad superblock: %x", s.sb)
+	}
+	if used := s.u64(s.sb[40:]); used > uint64(len(data)) || len(data)%4096 != 0 {
+		t.Fatalf("bad size: %d, %d used", len(data), used)
+	}
+
+	var names []string
+	for _, e := range s.readDir(s.inodeAt(s.u64(s.sb[32:]))) {
+		names = append(names, e.name)
+	}
+	if strings.Join(names, " ") != "bin dev etc run usr" {
+		t.Fatalf("bad: %v", names)
+	}
+	if entries := s.readDir(s.lookup("usr/share/many")); len(entries) != 200 {
+		t.Fatalf("bad: %d entries", len(entries))
+	}
+
+	if got := s.readFile(s.lookup("etc/hostname")); string(got) != "host\n" {
+		t.Fatalf("bad: %q", got)
+	}
+	if got := s.readFile(s.lookup("etc/big")); !bytes.Equal(got, bytes.Repeat([]byte("b"), 3*ext4BlockSize+1)) {
+		t.Fatalf("bad: %d bytes", len(got))
+	}
+	if got := s.readFile(s.lookup("etc/empty")); len(got) != 0 {
+		t.Fatalf("bad: %q", got)
+	}
+
+	// Hard links share an extended inode, with the number of links.
+	sh, bash := s.lookup("bin/sh"), s.lookup("bin/bash")
+	if s.u16(sh) != squashfsTypeLFile || s.u32(sh[12:]) != s.u32(bash[12:]) || s.u32(sh[40:]) != 2 {
+		t.Fatalf("bad: %x, %x", sh[:44], bash[:44])
+	}
+	if got := s.readFile(bash); string(got) != "sh" {
+		t.Fatalf("bad: %q", got)
+	}
+
+	link := s.lookup("etc/localtime")
+	if s.u16(link) != squashfsTypeLSymlink || string(link[24:24+s.u32(link[20:])]) != "/usr/share/zoneinfo/UTC" {
+		t.Fatalf("bad: %x", link[:24])
+	}
+
+	// Files with extended attributes have extended inodes.
+	if s.u16(s.sb[24:])&squashfsFlagNoXattrs != 0 {
+		t.Fatalf("bad flags: %x", s.u16(s.sb[24:]))
+	}
+	ping := s.lookup("bin/ping")
+	if s.u16(ping) != squashfsTypeLFile || s.xattrs(s.u32(ping[52:]))["security.capability"] != testCapability {
+		t.Fatalf("bad: %x", ping[:56])
+	}
+	if xattrs := s.xattrs(s.u32(link[24+s.u32(link[20:]):])); xattrs["security.selinux"] != "system_u:object_r:locale_t:s0\x00" {
+		t.Fatalf("bad: %q", xattrs)
+	}
+	xattrs := s.xattrs(s.u32(s.lookup("etc/big")[52:]))
+	if len(xattrs) != 2 || xattrs["user.comment"] != strings.Repeat("c", 200) || xattrs["user.empty"] != "" {
+		t.Fatalf("bad: %q", xattrs)
+	}
+	if s.u16(s.lookup("etc/hostname")) != squashfsTypeFile {
+		t.Fatal("files without extended attributes should have basic inodes")
+	}
+	dev := s.lookup("dev/sda")
+	if s.u16(dev) != squashfsTypeBlock || s.u32(dev[20:]) != 300&0xff|8<<8|(300&^0xff)<<12 {
+		t.Fatalf("bad: %x", dev[:24])
+	}
+	hostname := s.lookup("etc/hostname")
+	idIndex := s.u64(data[s.u64(s.sb[48:]):])
+	ids, _ := s.metadata(idIndex, s.u64(s.sb[48:]))
+	if id := s.u32(ids[4*int(s.u16(hostname[4:])):]); id != 1000 {
+		t.Fatalf("bad uid: %d", id)
+	}
+}
+
+func TestWriteSquashfs_unsquashfs(t *testing.T) {
+	if _, err := exec.LookPath("unsquashfs"); err != nil {
+		t.Skip("unsquashfs not found")
+	}
+
+	dst, err := os.Create(filepath.Join(t.TempDir(), "rootfs.squashfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := writeSquashfs(bytes.NewReader(testExportedContainer(t)), dst, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	out, err := exec.Command("unsquashfs", "-s", dst.Name()).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unsquashfs -s: %s\n%s", err, out)
+	}
+	t.Logf("%s", out)
+
+	out, err = exec.Command("unsquashfs", "-lls", dst.Name()).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unsquashfs -lls: %s\n%s", err, out)
+	}
+	for _, expected := range []string{
+		"squashfs-root/bin/ping",
+		"squashfs-root/etc/big",
+		"squashfs-root/etc/localtime -> /usr/share/zoneinfo/UTC",
+		"squashfs-root/dev/sda",
+		"squashfs-root/run/fifo",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("%s missing from the listing:\n%s", expected, out)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The file types of unix modes.
+const (
+	modeTypeMask = 0170000
+	modeSocket   = 0140000
+	modeSymlink  = 0120000
+	modeRegular  = 0100000
+	modeBlock    = 0060000
+	modeDir      = 0040000
+	modeChar     = 0020000
+	modeFifo     = 0010000
+)
+
+// fsNode is a file of the filesystem of an exported container.
+type fsNode struct {
+	name  string
+	mode  uint32
+	uid   uint32
+	gid   uint32
+	mtime time.Time
+	size  int64
+	// target is the target of symlinks.
+	target   string
+	devmajor uint32
+	devminor uint32
+	// nlink is the number of hard links to regular files.
+	nlink    uint32
+	children map[string]*fsNode
+	// link is the node a hard link shares its inode with.
+	link *fsNode
+	// xattrs are the extended attributes of the file, by name.
+	xattrs map[string]string
+}
+
+// fsXattrPrefixes are the namespaces of the extended attributes written to
+// filesystems. The others, like the POSIX ACLs in system., are stored in
+// other formats.
+var fsXattrPrefixes = []string{"user.", "trusted.", "security."}
+
+// xattrPrefix returns the index of the namespace of the extended attribute
+// name in fsXattrPrefixes, and the name in that namespace.
+func xattrPrefix(name string) (int, string) {
+	for i, prefix := range fsXattrPrefixes {
+		if rest := strings.TrimPrefix(name, prefix); rest != name && rest != "" {
+			return i, rest
+		}
+	}
+	return -1, name
+}
+
+func (n *fsNode) isDir() bool {
+	return n.mode&modeTypeMask == modeDir
+}
+
+// inode returns the node holding the inode of n, which is another node for
+// hard links.
+func (n *fsNode) inode() *fsNode {
+	if n.link != nil {
+		return n.link
+	}
+	return n
+}
+
+// sortedChildren returns the children of a directory, sorted by name.
+func (n *fsNode) sortedChildren() []*fsNode {
+	children := make([]*fsNode, 0, len(n.children))
+	for _, c := range n.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].name < children[j].name })
+	return children
+}
+
+// sortedXattrs returns the names of the extended attributes of a file,
+// sorted.
+func (n *fsNode) sortedXattrs() []string {
+	names := make([]string, 0, len(n.xattrs))
+	for name := range n.xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readFSTree reads the filesystem of a container from the tar stream of
+// `docker export`, with the extended attributes of its PAX records. The
+// contents of regular files are passed to data as they are read, as they
+// aren't kept.
+func readFSTree(r io.Reader, data func(*fsNode, io.Reader) error) (*fsNode, error) {
+	root := &fsNode{mode: modeDir | 0755, children: map[string]*fsNode{}}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading exported container: %s", err)
+		}
+
+		name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+		n := &fsNode{
+			name:  path.Base(name),
+			mode:  uint32(hdr.Mode) & 07777,
+			uid:   uint32(hdr.Uid),
+			gid:   uint32(hdr.Gid),
+			mtime: hdr.ModTime,
+			nlink: 1,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			n.mode |= modeDir
+			n.children = map[string]*fsNode{}
+		case tar.TypeReg:
+			n.mode |= modeRegular
+			n.size = hdr.Size
+		case tar.TypeSymlink:
+			n.mode |= modeSymlink
+			n.target = hdr.Linkname
+		case tar.TypeLink:
+			target, err := lookupFSNode(root, strings.Trim(path.Clean("/"+hdr.Linkname), "/"))
+			if err != nil || target.isDir() {
+				return nil, fmt.Errorf("Error reading exported container: invalid hard link %s to %s", hdr.Name, hdr.Linkname)
+			}
+			target = target.inode()
+			target.nlink++
+			n = &fsNode{name: n.name, link: target}
+		case tar.TypeChar:
+			n.mode |= modeChar
+			n.devmajor, n.devminor = uint32(hdr.Devmajor), uint32(hdr.Devminor)
+		case tar.TypeBlock:
+			n.mode |= modeBlock
+			n.devmajor, n.devminor = uint32(hdr.Devmajor), uint32(hdr.Devminor)
+		case tar.TypeFifo:
+			n.mode |= modeFifo
+		default:
+			continue
+		}
+		if n.link == nil {
+			if n.xattrs, err = readXattrs(hdr); err != nil {
+				return nil, err
+			}
+		}
+
+		if name == "" {
+			if n.isDir() {
+				root.mode, root.uid, root.gid, root.mtime, root.xattrs = n.mode, n.uid, n.gid, n.mtime, n.xattrs
+			}
+			continue
+		}
+		if len(n.name) > 255 {
+			return nil, fmt.Errorf("Error reading exported container: file name too long: %s", hdr.Name)
+		}
+
+		parent, err := mkdirFSNode(root, path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		if old, ok := parent.children[n.name]; ok && old.isDir() && n.isDir() {
+			// Keep the contents of directories listed twice.
+			old.mode, old.uid, old.gid, old.mtime, old.xattrs = n.mode, n.uid, n.gid, n.mtime, n.xattrs
+			continue
+		}
+		parent.children[n.name] = n
+
+		if n.mode&modeTypeMask == modeRegular {
+			if err := data(n, tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// readXattrs returns the extended attributes of the SCHILY.xattr. PAX
+// records of a file, or an error for the ones that can't be written.
+func readXattrs(hdr *tar.Header) (map[string]string, error) {
+	var xattrs map[string]string
+	for key, value := range hdr.PAXRecords {
+		name := strings.TrimPrefix(key, "SCHILY.xattr.")
+		if name == key {
+			continue
+		}
+		if i, _ := xattrPrefix(name); i < 0 {
+			return nil, fmt.Errorf("Error reading exported container: unsupported extended attribute %s of %s", name, hdr.Name)
+		}
+		if xattrs == nil {
+			xattrs = map[string]string{}
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+func lookupFSNode(root *fsNode, name string) (*fsNode, error) {
+	n := root
+	if name == "" || name == "." {
+		return n, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		if !n.isDir() || n.children[part] == nil {
+			return nil, fmt.Errorf("%s not found", name)
+		}
+		n = n.children[part]
+	}
+	return n, nil
+}
+
+// mkdirFSNode returns the directory name, creating the directories that
+// are missing from the archive.
+func mkdirFSNode(root *fsNode, name string) (*fsNode, error) {
+	n := root
+	if name == "." {
+		return n, nil
+	}
+	for _, part := range strings.Split(name, "/") {
+		child, ok := n.children[part]
+		if !ok {
+			child = &fsNode{name: part, mode: modeDir | 0755, mtime: n.mtime, children: map[string]*fsNode{}}
+			n.children[part] = child
+		}
+		if !child.isDir() {
+			return nil, fmt.Errorf("Error reading exported container: %s is not a directory", name)
+		}
+		n = child
+	}
+	return n, nil
+}
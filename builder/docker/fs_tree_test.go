@@ -0,0 +1,45 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadFSTree_xattrs(t *testing.T) {
+	read := func(records map[string]string) (*fsNode, error) {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := tw.WriteHeader(&tar.Header{Name: "file", Typeflag: tar.TypeReg, Mode: 0644, PAXRecords: records}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return readFSTree(&buf, func(*fsNode, io.Reader) error { return nil })
+	}
+
+	root, err := read(map[string]string{
+		"SCHILY.xattr.security.capability": testCapability,
+		"SCHILY.xattr.user.mime_type":      "text/plain",
+		"comment":                          "not an attribute",
+	})
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	xattrs := root.children["file"].xattrs
+	if len(xattrs) != 2 || xattrs["security.capability"] != testCapability || xattrs["user.mime_type"] != "text/plain" {
+		t.Fatalf("bad: %q", xattrs)
+	}
+
+	// ACLs aren't stored as extended attributes.
+	_, err = read(map[string]string{"SCHILY.xattr.system.posix_acl_access": "\x02\x00\x00\x00"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported extended attribute system.posix_acl_access of file") {
+		t.Fatalf("bad: %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restoreChanges returns the changes to apply on commit so that the
+// configuration of the committed image matches the one of its source image,
+// undoing what the options of the container, like `run_command`, changed.
+//
+// Changes can only set values, environment variables, labels, exposed ports
+// and volumes added to the container can't be removed: they are returned as
+// warnings.
+func restoreChanges(image, container *ImageConfig) ([]string, []string) {
+	var changes, warnings []string
+
+	// Setting the entrypoint resets the command, so both are restored
+	// together, in this order.
+	if !equalStrings(image.Entrypoint, container.Entrypoint) {
+		changes = append(changes, "ENTRYPOINT "+execForm(image.Entrypoint))
+		changes = append(changes, "CMD "+execForm(image.Cmd))
+	} else if !equalStrings(image.Cmd, container.Cmd) {
+		changes = append(changes, "CMD "+execForm(image.Cmd))
+	}
+
+	if image.User != container.User {
+		user := image.User
+		if user == "" {
+			user = "root"
+		}
+		changes = append(changes, "USER "+user)
+	}
+
+	if image.WorkingDir != container.WorkingDir {
+		dir := image.WorkingDir
+		if dir == "" {
+			dir = "/"
+		}
+		changes = append(changes, "WORKDIR "+dir)
+	}
+
+	imageEnv, containerEnv := envMap(image.Env), envMap(container.Env)
+	for _, name := range sortedKeys(imageEnv) {
+		if value, ok := containerEnv[name]; !ok || value != imageEnv[name] {
+			changes = append(changes, fmt.Sprintf("ENV %s=%s", name, quoteChangeValue(imageEnv[name])))
+		}
+	}
+	for _, name := range sortedKeys(containerEnv) {
+		if _, ok := imageEnv[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("environment variable %s can't be removed", name))
+		}
+	}
+
+	for _, name := range sortedKeys(image.Labels) {
+		if value, ok := container.Labels[name]; !ok || value != image.Labels[name] {
+			changes = append(changes, fmt.Sprintf("LABEL %s=%s", quoteChangeValue(name), quoteChangeValue(image.Labels[name])))
+		}
+	}
+	for _, name := range sortedKeys(container.Labels) {
+		if _, ok := image.Labels[name]; !ok {
+			warnings = append(warnings, fmt.Sprintf("label %s can't be removed", name))
+		}
+	}
+
+	if image.StopSignal != container.StopSignal {
+		signal := image.StopSignal
+		if signal == "" {
+			signal = "SIGTERM"
+		}
+		changes = append(changes, "STOPSIGNAL "+signal)
+	}
+
+	if !reflect.DeepEqual(image.Healthcheck, container.Healthcheck) {
+		changes = append(changes, "HEALTHCHECK "+healthcheckForm(image.Healthcheck))
+	}
+
+	for _, port := range setDifference(image.ExposedPorts, container.ExposedPorts) {
+		changes = append(changes, "EXPOSE "+port)
+	}
+	for _, port := range setDifference(container.ExposedPorts, image.ExposedPorts) {
+		warnings = append(warnings, fmt.Sprintf("exposed port %s can't be removed", port))
+	}
+
+	for _, volume := range setDifference(image.Volumes, container.Volumes) {
+		changes = append(changes, "VOLUME "+volume)
+	}
+	for _, volume := range setDifference(container.Volumes, image.Volumes) {
+		warnings = append(warnings, fmt.Sprintf("volume %s can't be removed", volume))
+	}
+
+	return changes, warnings
+}
+
+// execForm returns the JSON form of a command, for CMD and ENTRYPOINT.
+//
+// An empty command is [""]: the commit treats `null` and `[]` as no change,
+// so an array with an empty string is the only way to unset a command.
+func execForm(command []string) string {
+	if len(command) == 0 {
+		return `[""]`
+	}
+	b, _ := json.Marshal(command)
+	return string(b)
+}
+
+// healthcheckForm returns the arguments of the HEALTHCHECK instruction
+// setting this healthcheck.
+func healthcheckForm(health *HealthConfig) string {
+	if health == nil || len(health.Test) == 0 || health.Test[0] == "NONE" {
+		return "NONE"
+	}
+
+	var options []string
+	if health.Interval != 0 {
+		options = append(options, "--interval="+health.Interval.String())
+	}
+	if health.Timeout != 0 {
+		options = append(options, "--timeout="+health.Timeout.String())
+	}
+	if health.StartPeriod != 0 {
+		options = append(options, "--start-period="+health.StartPeriod.String())
+	}
+	if health.Retries != 0 {
+		options = append(options, fmt.Sprintf("--retries=%d", health.Retries))
+	}
+
+	command := execForm(health.Test[1:])
+	if health.Test[0] == "CMD-SHELL" {
+		command = strings.Join(health.Test[1:], " ")
+	}
+	return strings.Join(append(options, "CMD", command), " ")
+}
+
+// envMap returns the values of a list of `NAME=value` variables.
+func envMap(env []string) map[string]string {
+	ret := map[string]string{}
+	for _, variable := range env {
+		name, value, _ := strings.Cut(variable, "=")
+		ret[name] = value
+	}
+	return ret
+}
+
+// setDifference returns the sorted keys of a that are not in b.
+func setDifference(a, b map[string]struct{}) []string {
+	var ret []string
+	for key := range a {
+		if _, ok := b[key]; !ok {
+			ret = append(ret, key)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
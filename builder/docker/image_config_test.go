@@ -0,0 +1,145 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestoreChanges(t *testing.T) {
+	image := &ImageConfig{
+		Env:        []string{"PATH=/usr/local/bin:/usr/bin", "LANG=C.UTF-8"},
+		Cmd:        []string{"nginx", "-g", "daemon off;"},
+		Entrypoint: []string{"/docker-entrypoint.sh"},
+		User:       "nginx",
+		StopSignal: "SIGQUIT",
+		Labels:     map[string]string{"maintainer": "NGINX <docker@nginx.com>"},
+		ExposedPorts: map[string]struct{}{
+			"80/tcp": {},
+		},
+		Healthcheck: &HealthConfig{
+			Test:     []string{"CMD-SHELL", "curl -f http://localhost/"},
+			Interval: 30 * time.Second,
+			Retries:  3,
+		},
+	}
+
+	tests := []struct {
+		name             string
+		container        ImageConfig
+		expectedChanges  []string
+		expectedWarnings []string
+	}{
+		{
+			"unchanged",
+			*image,
+			nil,
+			nil,
+		},
+		{
+			"default run_command",
+			ImageConfig{
+				Env:          image.Env,
+				Cmd:          nil,
+				Entrypoint:   []string{"/bin/sh"},
+				User:         image.User,
+				StopSignal:   image.StopSignal,
+				Labels:       image.Labels,
+				ExposedPorts: image.ExposedPorts,
+				Healthcheck:  image.Healthcheck,
+			},
+			[]string{
+				`ENTRYPOINT ["/docker-entrypoint.sh"]`,
+				`CMD ["nginx","-g","daemon off;"]`,
+			},
+			nil,
+		},
+		{
+			"everything overridden",
+			ImageConfig{
+				Env:          []string{"PATH=/usr/local/bin:/usr/bin", "LANG=en_US.UTF-8", "DEBUG=1"},
+				Cmd:          image.Cmd,
+				Entrypoint:   image.Entrypoint,
+				User:         "root",
+				WorkingDir:   "/build",
+				StopSignal:   "SIGKILL",
+				Labels:       map[string]string{"maintainer": "me", "stage": "build"},
+				ExposedPorts: map[string]struct{}{"80/tcp": {}, "8080/tcp": {}},
+				Volumes:      map[string]struct{}{"/cache": {}},
+			},
+			[]string{
+				"USER nginx",
+				"WORKDIR /",
+				`ENV LANG="C.UTF-8"`,
+				`LABEL "maintainer"="NGINX <docker@nginx.com>"`,
+				"STOPSIGNAL SIGQUIT",
+				"HEALTHCHECK --interval=30s --retries=3 CMD curl -f http://localhost/",
+			},
+			[]string{
+				"environment variable DEBUG can't be removed",
+				"label stage can't be removed",
+				"exposed port 8080/tcp can't be removed",
+				"volume /cache can't be removed",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, warnings := restoreChanges(image, &tt.container)
+			if !reflect.DeepEqual(changes, tt.expectedChanges) {
+				t.Errorf("expected changes:\n%s\ngot:\n%s", strings.Join(tt.expectedChanges, "\n"), strings.Join(changes, "\n"))
+			}
+			if !reflect.DeepEqual(warnings, tt.expectedWarnings) {
+				t.Errorf("expected warnings:\n%s\ngot:\n%s", strings.Join(tt.expectedWarnings, "\n"), strings.Join(warnings, "\n"))
+			}
+		})
+	}
+}
+
+func TestRestoreChanges_emptyImage(t *testing.T) {
+	container := &ImageConfig{
+		Entrypoint:  []string{"/bin/sh"},
+		Cmd:         []string{"-c", "sleep infinity"},
+		User:        "nobody",
+		StopSignal:  "SIGINT",
+		Healthcheck: &HealthConfig{Test: []string{"CMD", "true"}},
+	}
+
+	changes, _ := restoreChanges(&ImageConfig{}, container)
+	expected := []string{
+		`ENTRYPOINT [""]`,
+		`CMD [""]`,
+		"USER root",
+		"STOPSIGNAL SIGTERM",
+		"HEALTHCHECK NONE",
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(changes, "\n"))
+	}
+}
+
+func TestRestoreChanges_quoting(t *testing.T) {
+	image := &ImageConfig{
+		Env:    []string{`HOME_DIR=$HOME`, `WINDOWS_PATH=C:\Windows`, "GREETING=café"},
+		Labels: map[string]string{"description": `costs $5 and "more"`},
+	}
+
+	changes, _ := restoreChanges(image, &ImageConfig{})
+	expected := []string{
+		`ENV GREETING="café"`,
+		`ENV HOME_DIR="\$HOME"`,
+		`ENV WINDOWS_PATH="C:\\Windows"`,
+		`LABEL "description"="costs \$5 and \"more\""`,
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(changes, "\n"))
+	}
+	if errs := ValidateChanges(changes); len(errs) > 0 {
+		t.Errorf("invalid changes: %v", errs)
+	}
+}
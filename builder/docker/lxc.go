@@ -0,0 +1,180 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// lxcArchitectures maps the architectures of Docker platforms, with their
+// variant, to the ones of LXC and Incus.
+var lxcArchitectures = map[string]string{
+	"amd64":    "x86_64",
+	"386":      "i686",
+	"arm64":    "aarch64",
+	"arm":      "armv7l",
+	"arm/v7":   "armv7l",
+	"arm/v6":   "armv6l",
+	"ppc64le":  "ppc64le",
+	"s390x":    "s390x",
+	"riscv64":  "riscv64",
+	"mips64le": "mips64el",
+	"loong64":  "loongarch64",
+}
+
+// lxcTemplates are the templates of the files Incus writes when an
+// instance is created from the image, or copied.
+var lxcTemplates = []struct {
+	path     string
+	name     string
+	contents string
+}{
+	{"/etc/hostname", "hostname.tpl", "{{ container.name }}\n"},
+	{"/etc/hosts", "hosts.tpl", `127.0.0.1	localhost
+127.0.1.1	{{ container.name }}
+
+# The following lines are desirable for IPv6 capable hosts
+::1	localhost ip6-localhost ip6-loopback
+ff02::1	ip6-allnodes
+ff02::2	ip6-allrouters
+`},
+}
+
+// lxcMetadata is the metadata of an LXC image.
+type lxcMetadata struct {
+	Architecture string
+	CreationDate time.Time
+	Description  string
+}
+
+// lxcArchitecture returns the LXC architecture of a platform like
+// `linux/arm64` or `linux/arm/v7`, or of the machine Packer runs on if the
+// platform is empty.
+func lxcArchitecture(platform string) (string, error) {
+	arch := runtime.GOARCH
+	if platform != "" {
+		parts := strings.SplitN(platform, "/", 2)
+		if len(parts) != 2 || parts[0] != "linux" {
+			return "", fmt.Errorf("the lxc export_format requires a linux platform, not %q", platform)
+		}
+		arch = parts[1]
+	}
+	lxcArch, ok := lxcArchitectures[arch]
+	if !ok {
+		return "", fmt.Errorf("the lxc export_format doesn't support the %q architecture", arch)
+	}
+	return lxcArch, nil
+}
+
+// yaml returns the metadata.yaml file of the image.
+func (m *lxcMetadata) yaml() string {
+	// Strings are quoted as JSON strings, which are YAML strings too.
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "architecture: %s\n", quote(m.Architecture))
+	fmt.Fprintf(&b, "creation_date: %d\n", m.CreationDate.Unix())
+	fmt.Fprintf(&b, "properties:\n")
+	fmt.Fprintf(&b, "  architecture: %s\n", quote(m.Architecture))
+	if m.Description != "" {
+		fmt.Fprintf(&b, "  description: %s\n", quote(m.Description))
+	}
+	fmt.Fprintf(&b, "templates:\n")
+	for _, t := range lxcTemplates {
+		fmt.Fprintf(&b, "  %s:\n", t.path)
+		fmt.Fprintf(&b, "    when:\n    - create\n    - copy\n")
+		fmt.Fprintf(&b, "    template: %s\n", t.name)
+	}
+	return b.String()
+}
+
+// writeLXCImage writes the unified tarball of an LXC image to dst, with
+// its metadata, templates and the `docker export` tar stream r as its
+// rootfs.
+func writeLXCImage(r io.Reader, dst io.Writer, meta lxcMetadata) error {
+	tw := tar.NewWriter(dst)
+	writeFile := func(name string, contents string) error {
+		hdr := &tar.Header{
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+			ModTime:  meta.CreationDate,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.WriteString(tw, contents)
+		return err
+	}
+
+	if err := writeFile("metadata.yaml", meta.yaml()); err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: "templates/", Mode: 0755, ModTime: meta.CreationDate, Typeflag: tar.TypeDir}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	for _, t := range lxcTemplates {
+		if err := writeFile("templates/"+t.name, t.contents); err != nil {
+			return err
+		}
+	}
+
+	rootfs := false
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading exported container: %s", err)
+		}
+
+		name := lxcRootfsPath(hdr.Name)
+		if name == "rootfs" {
+			rootfs = true
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = lxcRootfsPath(hdr.Linkname)
+		}
+		// The names are longer, and may not fit the format read.
+		hdr.Format = tar.FormatUnknown
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	if !rootfs {
+		hdr := &tar.Header{Name: "rootfs/", Mode: 0755, ModTime: meta.CreationDate, Typeflag: tar.TypeDir}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// lxcRootfsPath returns the path of the file name of an exported container
+// in the image.
+func lxcRootfsPath(name string) string {
+	return path.Join("rootfs", strings.Trim(path.Clean("/"+name), "/"))
+}
@@ -0,0 +1,103 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestLXCArchitecture(t *testing.T) {
+	tests := map[string]string{
+		"linux/amd64":   "x86_64",
+		"linux/arm64":   "aarch64",
+		"linux/arm/v7":  "armv7l",
+		"linux/arm/v6":  "armv6l",
+		"linux/386":     "i686",
+		"windows/amd64": "",
+		"linux/sparc":   "",
+		"amd64":         "",
+	}
+	for platform, expected := range tests {
+		actual, err := lxcArchitecture(platform)
+		if actual != expected || (err == nil) != (expected != "") {
+			t.Errorf("%s: expected %q, got %q, %v", platform, expected, actual, err)
+		}
+	}
+}
+
+func TestWriteLXCImage(t *testing.T) {
+	meta := lxcMetadata{
+		Architecture: "x86_64",
+		CreationDate: time.Unix(1700000000, 0),
+		Description:  `Ubuntu "noble"`,
+	}
+	var buf bytes.Buffer
+	if err := writeLXCImage(bytes.NewReader(testExportedContainer(t)), &buf, meta); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	files := map[string]string{}
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		contents, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(contents)
+		headers[hdr.Name] = hdr
+	}
+
+	expected := `architecture: "x86_64"
+creation_date: 1700000000
+properties:
+  architecture: "x86_64"
+  description: "Ubuntu \"noble\""
+templates:
+  /etc/hostname:
+    when:
+    - create
+    - copy
+    template: hostname.tpl
+  /etc/hosts:
+    when:
+    - create
+    - copy
+    template: hosts.tpl
+`
+	if files["metadata.yaml"] != expected {
+		t.Fatalf("bad metadata:\n%s", files["metadata.yaml"])
+	}
+	if files["templates/hostname.tpl"] != "{{ container.name }}\n" {
+		t.Fatalf("bad template: %q", files["templates/hostname.tpl"])
+	}
+	if _, ok := files["templates/hosts.tpl"]; !ok {
+		t.Fatal("should have the hosts template")
+	}
+
+	// The files of the container are in rootfs.
+	if _, ok := headers["rootfs/"]; !ok {
+		t.Fatal("should have the rootfs directory")
+	}
+	if files["rootfs/etc/hostname"] != "host\n" || headers["rootfs/etc/hostname"].Uid != 1000 {
+		t.Fatalf("bad: %#v", headers["rootfs/etc/hostname"])
+	}
+	if link := headers["rootfs/bin/bash"]; link == nil || link.Linkname != "rootfs/bin/sh" {
+		t.Fatalf("bad hard link: %#v", link)
+	}
+	if link := headers["rootfs/etc/localtime"]; link == nil || link.Linkname != "/usr/share/zoneinfo/UTC" {
+		t.Fatalf("bad symlink: %#v", link)
+	}
+	if dev := headers["rootfs/dev/sda"]; dev == nil || dev.Devmajor != 8 || dev.Devminor != 300 {
+		t.Fatalf("bad device: %#v", dev)
+	}
+}
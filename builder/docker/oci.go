@@ -0,0 +1,84 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The OCI annotations set on committed images with `oci_annotations`, see
+// https://github.com/opencontainers/image-spec/blob/main/annotations.md
+const (
+	ociCreated    = "org.opencontainers.image.created"
+	ociBaseName   = "org.opencontainers.image.base.name"
+	ociBaseDigest = "org.opencontainers.image.base.digest"
+	ociRevision   = "org.opencontainers.image.revision"
+	ociSource     = "org.opencontainers.image.source"
+	ociVersion    = "org.opencontainers.image.version"
+)
+
+// ociAnnotations returns the OCI annotations of an image committed now from
+// the base image, with the given distribution digest, and from the git
+// repository in dir. Annotations whose value is unknown are left out.
+func ociAnnotations(baseImage, baseDigest, dir string, now time.Time) map[string]string {
+	annotations := map[string]string{
+		ociCreated: now.UTC().Format(time.RFC3339),
+	}
+	// Images built from a Dockerfile only have an ID, which isn't a name.
+	if baseImage != "" && !strings.HasPrefix(baseImage, "sha256:") {
+		annotations[ociBaseName] = baseImage
+	}
+	// The distribution digest is given as name@digest.
+	if i := strings.LastIndex(baseDigest, "@"); i >= 0 {
+		annotations[ociBaseDigest] = baseDigest[i+1:]
+	}
+
+	if revision := gitOutput(dir, "rev-parse", "--verify", "HEAD"); revision != "" {
+		annotations[ociRevision] = revision
+	}
+	if source := gitOutput(dir, "config", "--get", "remote.origin.url"); source != "" {
+		// Don't leak the credentials of the remote into the image.
+		if u, err := url.Parse(source); err == nil && u.User != nil && u.Scheme != "ssh" {
+			u.User = nil
+			source = u.String()
+		}
+		annotations[ociSource] = source
+	}
+	if version := gitOutput(dir, "describe", "--tags", "--exact-match", "HEAD"); version != "" {
+		annotations[ociVersion] = version
+	}
+	return annotations
+}
+
+// gitOutput runs git in dir and returns its output, or an empty string if
+// it fails, for instance when dir isn't in a git repository.
+func gitOutput(dir string, args ...string) string {
+	var stdout bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(stdout.String())
+}
+
+// templateDir returns the directory of the template, or the working
+// directory if its path isn't known.
+func (c *Config) templateDir() string {
+	p := c.ctx.TemplatePath
+	if p == "" {
+		return "."
+	}
+	// HCL2 templates can be a directory of files.
+	if info, err := os.Stat(p); err == nil && info.IsDir() {
+		return p
+	}
+	return filepath.Dir(p)
+}
@@ -0,0 +1,278 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociMediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	ociMediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// ociDescriptor is a descriptor of a blob of an OCI image layout.
+type ociDescriptor struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Size      int64        `json:"size"`
+	Platform  *ociPlatform `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	OSVersion    string `json:"os.version,omitempty"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ociWriteFunc writes a file of size bytes read from r to an OCI image
+// layout, at name relative to its root.
+type ociWriteFunc func(name string, size int64, r io.Reader) error
+
+// checkOCILayoutPath returns an error if the OCI image layout can't be
+// written to the directory dir: it must not exist or be empty, so that
+// no other image or file is overwritten, or removed with the artifact.
+func checkOCILayoutPath(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil
+	}
+	if !fi.IsDir() {
+		return errExportPathNotDir
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return errExportPathNotEmpty
+	}
+	return nil
+}
+
+// exportOCILayout saves the image and writes it as an OCI image layout to
+// the directory dir. It returns the files written.
+func exportOCILayout(driver Driver, image string, dir string, tempDir string) ([]string, error) {
+	var files []string
+	err := exportOCI(driver, image, tempDir, func(name string, size int64, r io.Reader) error {
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		files = append(files, dst)
+		return err
+	})
+	return files, err
+}
+
+// exportOCIArchive saves the image and writes it as a tar archive of an
+// OCI image layout to dst.
+func exportOCIArchive(driver Driver, image string, dst io.Writer, tempDir string) error {
+	tw := tar.NewWriter(dst)
+	dirs := map[string]bool{}
+	err := exportOCI(driver, image, tempDir, func(name string, size int64, r io.Reader) error {
+		if dir := path.Dir(name); dir != "." && !dirs[dir] {
+			for _, d := range []string{path.Dir(dir), dir} {
+				if d == "." || dirs[d] {
+					continue
+				}
+				dirs[d] = true
+				if err := tw.WriteHeader(&tar.Header{Name: d + "/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// exportOCI saves the image to a temporary directory in tempDir, and writes
+// it as an OCI image layout with write.
+func exportOCI(driver Driver, image string, tempDir string, write ociWriteFunc) error {
+	dir, err := os.MkdirTemp(tempDir, "packer-docker-export")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := saveAndUnpack(driver, image, dir); err != nil {
+		return fmt.Errorf("Error saving image: %s", err)
+	}
+	return writeOCILayout(dir, write)
+}
+
+// writeOCILayout writes the image saved to the directory saved as an OCI
+// image layout. The manifest is annotated with the OCI annotations of the
+// labels of the image, and indexed with the platform of the image.
+func writeOCILayout(saved string, write ociWriteFunc) error {
+	manifest, _, err := readSaved(saved)
+	if err != nil {
+		return err
+	}
+	configData, err := os.ReadFile(filepath.Join(saved, filepath.FromSlash(path.Clean("/"+manifest.Config))))
+	if err != nil {
+		return fmt.Errorf("Error reading configuration of saved image: %s", err)
+	}
+	var config struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		OSVersion    string `json:"os.version"`
+		Variant      string `json:"variant"`
+		Config       struct {
+			Labels map[string]string
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(configData, &config); err != nil {
+		return fmt.Errorf("Error parsing configuration of saved image: %s", err)
+	}
+
+	written := map[string]bool{}
+	writeBlob := func(d ociDescriptor, r io.Reader) error {
+		if written[d.Digest] {
+			return nil
+		}
+		written[d.Digest] = true
+		return write("blobs/sha256/"+strings.TrimPrefix(d.Digest, "sha256:"), d.Size, r)
+	}
+
+	m := struct {
+		SchemaVersion int               `json:"schemaVersion"`
+		MediaType     string            `json:"mediaType"`
+		Config        ociDescriptor     `json:"config"`
+		Layers        []ociDescriptor   `json:"layers"`
+		Annotations   map[string]string `json:"annotations,omitempty"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeManifest,
+		Config:        ociBlob(ociMediaTypeConfig, configData),
+		Layers:        []ociDescriptor{},
+	}
+	if err := writeBlob(m.Config, bytes.NewReader(configData)); err != nil {
+		return err
+	}
+
+	for _, layer := range manifest.Layers {
+		p := filepath.Join(saved, filepath.FromSlash(path.Clean("/"+layer)))
+		d, err := ociLayer(p)
+		if err != nil {
+			return fmt.Errorf("Error reading layer %s: %s", layer, err)
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		err = writeBlob(d, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		m.Layers = append(m.Layers, d)
+	}
+
+	for k, v := range config.Config.Labels {
+		if strings.HasPrefix(k, "org.opencontainers.image.") {
+			if m.Annotations == nil {
+				m.Annotations = map[string]string{}
+			}
+			m.Annotations[k] = v
+		}
+	}
+	manifestData, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	manifestBlob := ociBlob(ociMediaTypeManifest, manifestData)
+	if err := writeBlob(manifestBlob, bytes.NewReader(manifestData)); err != nil {
+		return err
+	}
+
+	manifestBlob.Platform = &ociPlatform{
+		Architecture: config.Architecture,
+		OS:           config.OS,
+		OSVersion:    config.OSVersion,
+		Variant:      config.Variant,
+	}
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     ociMediaTypeIndex,
+		"manifests":     []ociDescriptor{manifestBlob},
+	})
+	if err != nil {
+		return err
+	}
+
+	layout := []byte(`{"imageLayoutVersion":"1.0.0"}`)
+	if err := write("oci-layout", int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+	return write("index.json", int64(len(index)), bytes.NewReader(index))
+}
+
+func ociBlob(mediaType string, data []byte) ociDescriptor {
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(data)),
+		Size:      int64(len(data)),
+	}
+}
+
+// ociLayer returns the descriptor of the layer file p, which is a tar
+// archive, compressed or not.
+func ociLayer(p string) (ociDescriptor, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer f.Close()
+
+	mediaType := ociMediaTypeLayer
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		mediaType += "+gzip"
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		mediaType += "+zstd"
+	}
+
+	h := sha256.New()
+	size, err := io.Copy(h, br)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    fmt.Sprintf("sha256:%x", h.Sum(nil)),
+		Size:      size,
+	}, nil
+}
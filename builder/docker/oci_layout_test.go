@@ -0,0 +1,120 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testOCILayout checks that the files are an OCI image layout of the image
+// saved by testSavedImage.
+func testOCILayout(t *testing.T, files map[string]string, layers [][]byte) {
+	if files["oci-layout"] != `{"imageLayoutVersion":"1.0.0"}` {
+		t.Fatalf("bad oci-layout: %q", files["oci-layout"])
+	}
+	blob := func(d ociDescriptor) string {
+		data, ok := files["blobs/sha256/"+strings.TrimPrefix(d.Digest, "sha256:")]
+		if !ok {
+			t.Fatalf("missing blob %s", d.Digest)
+		}
+		if digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(data))); digest != d.Digest || int64(len(data)) != d.Size {
+			t.Fatalf("bad blob %s: %s %d", d.Digest, digest, len(data))
+		}
+		return data
+	}
+
+	var index struct {
+		MediaType string          `json:"mediaType"`
+		Manifests []ociDescriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal([]byte(files["index.json"]), &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.MediaType != ociMediaTypeIndex || len(index.Manifests) != 1 {
+		t.Fatalf("bad index: %s", files["index.json"])
+	}
+	if p := index.Manifests[0].Platform; p == nil || p.Architecture != "amd64" {
+		t.Fatalf("the platform of the image should be indexed: %#v", p)
+	}
+
+	var manifest struct {
+		Config ociDescriptor   `json:"config"`
+		Layers []ociDescriptor `json:"layers"`
+	}
+	if err := json.Unmarshal([]byte(blob(index.Manifests[0])), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if config := blob(manifest.Config); !strings.Contains(config, `"Cmd":["nginx"]`) {
+		t.Fatalf("the configuration of the image should be kept: %s", config)
+	}
+	if len(manifest.Layers) != len(layers) {
+		t.Fatalf("bad layers: %#v", manifest.Layers)
+	}
+	for i, d := range manifest.Layers {
+		if d.MediaType != ociMediaTypeLayer || blob(d) != string(layers[i]) {
+			t.Fatalf("bad layer %d: %#v", i, d)
+		}
+	}
+}
+
+func testOCILayers(t *testing.T) [][]byte {
+	return [][]byte{
+		testLayer(t, testLayerEntry{name: "bin/sh", content: "sh"}),
+		testLayer(t, testLayerEntry{name: "app", content: "app"}),
+	}
+}
+
+func TestExportOCILayout(t *testing.T) {
+	layers := testOCILayers(t)
+	saved, _ := testSavedImage(t, layers...)
+	driver := &MockDriver{SaveImageReader: bytes.NewReader(saved)}
+
+	dir := filepath.Join(t.TempDir(), "layout")
+	paths, err := exportOCILayout(driver, "image", dir, t.TempDir())
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if driver.SaveImageId != "image" {
+		t.Fatalf("bad saved image: %s", driver.SaveImageId)
+	}
+
+	files := map[string]string{}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[filepath.ToSlash(rel)] = string(data)
+	}
+	// The config, the two layers, the manifest, oci-layout and index.json.
+	if len(files) != 6 {
+		t.Fatalf("bad files: %#v", paths)
+	}
+	testOCILayout(t, files, layers)
+}
+
+func TestExportOCIArchive(t *testing.T) {
+	layers := testOCILayers(t)
+	saved, _ := testSavedImage(t, layers...)
+	driver := &MockDriver{SaveImageReader: bytes.NewReader(saved)}
+
+	var buf bytes.Buffer
+	if err := exportOCIArchive(driver, "image", &buf, t.TempDir()); err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+
+	names, files := readTestArchive(t, &buf)
+	if names[0] != "blobs/" || names[1] != "blobs/sha256/" {
+		t.Fatalf("the directories should be archived first: %#v", names)
+	}
+	testOCILayout(t, files, layers)
+}
@@ -0,0 +1,108 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// progressThreshold is the size from which uploads report their progress.
+const progressThreshold = 100 * 1024 * 1024
+
+// progressInterval is the time between two progress reports.
+const progressInterval = 10 * time.Second
+
+// progressReader reports the throughput of the reads from an io.Reader to the
+// UI. The file provisioner already displays a progress bar for the files it
+// uploads, this is meant for the transfers to the container that take long
+// enough to look stuck otherwise.
+type progressReader struct {
+	reader io.Reader
+	ui     packersdk.Ui
+	name   string
+	total  int64
+
+	lock       sync.Mutex
+	read       int64
+	start      time.Time
+	lastReport time.Time
+	now        func() time.Time
+}
+
+func newProgressReader(reader io.Reader, ui packersdk.Ui, name string, total int64) *progressReader {
+	now := time.Now()
+	return &progressReader{
+		reader:     reader,
+		ui:         ui,
+		name:       name,
+		total:      total,
+		start:      now,
+		lastReport: now,
+		now:        time.Now,
+	}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.read += int64(n)
+	if now := r.now(); now.Sub(r.lastReport) >= progressInterval {
+		r.lastReport = now
+		r.report(fmt.Sprintf("Uploading %s: %s of %s (%s)",
+			r.name, formatBytes(r.read), formatBytes(r.total), r.throughput(now)))
+	}
+
+	return n, err
+}
+
+// done reports the summary of the transfer.
+func (r *progressReader) done() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.now()
+	r.report(fmt.Sprintf("Uploaded %s to %s in %s (%s)",
+		formatBytes(r.read), r.name, now.Sub(r.start).Round(time.Second), r.throughput(now)))
+}
+
+func (r *progressReader) report(message string) {
+	if r.ui == nil {
+		log.Print(message)
+		return
+	}
+	r.ui.Message(message)
+}
+
+// throughput returns the average transfer rate since the start.
+func (r *progressReader) throughput(now time.Time) string {
+	elapsed := now.Sub(r.start).Seconds()
+	if elapsed <= 0 {
+		return "-"
+	}
+	return formatBytes(int64(float64(r.read)/elapsed)) + "/s"
+}
+
+// formatBytes returns a human readable size, in binary units.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 5; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
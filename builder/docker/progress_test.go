@@ -0,0 +1,66 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestProgressReader(t *testing.T) {
+	ui := &packersdk.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+
+	contents := bytes.Repeat([]byte("x"), 4*1024*1024)
+	r := newProgressReader(bytes.NewReader(contents), ui, "/tmp/big", int64(len(contents)))
+
+	// Every read happens 5 seconds after the previous one
+	now := r.start
+	r.now = func() time.Time {
+		now = now.Add(5 * time.Second)
+		return now
+	}
+
+	buf := make([]byte, 1024*1024)
+	for {
+		if _, err := r.Read(buf); err == io.EOF {
+			break
+		}
+	}
+	r.done()
+
+	output := ui.Writer.(*bytes.Buffer).String()
+	if count := strings.Count(output, "Uploading /tmp/big"); count != 2 {
+		t.Errorf("expected 2 progress reports, got %d:\n%s", count, output)
+	}
+	if !strings.Contains(output, "Uploaded 4.0 MiB to /tmp/big in 30s") {
+		t.Errorf("missing summary:\n%s", output)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		size     int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024 * 1024, "5.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if actual := formatBytes(tt.size); actual != tt.expected {
+			t.Errorf("%d: expected %q, got %q", tt.size, tt.expected, actual)
+		}
+	}
+}
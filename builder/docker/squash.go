@@ -0,0 +1,534 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const (
+	// whiteoutPrefix marks a file removed from the lower layers
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque marks a directory whose contents in the lower layers
+	// are hidden
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// saveManifest is an entry of the manifest.json of a `docker save` archive.
+type saveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// Squash flattens the layers the image adds on top of the base image into
+// a single layer, or all its layers if base is empty, and loads the result
+// as a new image with the same configuration. It returns the ID of the new
+// image, which is the one of the image if there is nothing to squash.
+//
+// The image is saved to a temporary directory in tempDir, or in the
+// default directory for temporary files if it is empty.
+func Squash(driver Driver, image string, base string, tempDir string) (string, error) {
+	dir, err := os.MkdirTemp(tempDir, "packer-squash")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	saved := filepath.Join(dir, "saved")
+	if err := saveAndUnpack(driver, image, saved); err != nil {
+		return "", err
+	}
+
+	manifest, config, err := readSaved(saved)
+	if err != nil {
+		return "", err
+	}
+	var rootfs struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	}
+	if err := json.Unmarshal(config["rootfs"], &rootfs); err != nil {
+		return "", fmt.Errorf("invalid rootfs in the configuration of image %s: %s", image, err)
+	}
+	if len(rootfs.DiffIDs) != len(manifest.Layers) {
+		return "", fmt.Errorf("image %s has %d layers for %d diff IDs", image, len(manifest.Layers), len(rootfs.DiffIDs))
+	}
+
+	baseLayers := 0
+	if base != "" {
+		baseDiffIDs, err := driver.ImageLayers(base)
+		if err != nil {
+			return "", err
+		}
+		if len(baseDiffIDs) > len(rootfs.DiffIDs) || !equalStrings(baseDiffIDs, rootfs.DiffIDs[:len(baseDiffIDs)]) {
+			return "", fmt.Errorf("image %s is not based on image %s", image, base)
+		}
+		baseLayers = len(baseDiffIDs)
+	}
+	if len(manifest.Layers)-baseLayers <= 1 {
+		return image, nil
+	}
+
+	layers := make([]string, len(manifest.Layers)-baseLayers)
+	for i, layer := range manifest.Layers[baseLayers:] {
+		layers[i] = filepath.Join(saved, filepath.FromSlash(path.Clean("/"+layer)))
+	}
+	squashed := filepath.Join(dir, "layer.tar")
+	diffID, err := writeSquashedLayer(squashed, layers, base != "")
+	if err != nil {
+		return "", err
+	}
+
+	rootfs.DiffIDs = append(rootfs.DiffIDs[:baseLayers:baseLayers], diffID)
+	if config["rootfs"], err = json.Marshal(rootfs); err != nil {
+		return "", err
+	}
+	if config["history"], err = squashHistory(config["history"], baseLayers, len(layers)); err != nil {
+		return "", err
+	}
+
+	load := filepath.Join(dir, "load.tar")
+	if err := writeLoadArchive(load, saved, manifest.Layers[:baseLayers], squashed, config); err != nil {
+		return "", err
+	}
+	f, err := os.Open(load)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return driver.LoadImage(f)
+}
+
+// SquashImage squashes the image like Squash, and reports the ID of the
+// squashed image and the space saved to ui.
+func SquashImage(ui packersdk.Ui, driver Driver, image string, base string, tempDir string) (string, error) {
+	before, err := driver.ImageSize(image)
+	if err != nil {
+		return "", err
+	}
+	squashed, err := Squash(driver, image, base, tempDir)
+	if err != nil {
+		return "", err
+	}
+	if squashed == image {
+		ui.Message("The image has a single layer to squash, it is left as is")
+		return squashed, nil
+	}
+
+	ui.Message(fmt.Sprintf("Squashed image ID: %s", squashed))
+	after, err := driver.ImageSize(squashed)
+	if err != nil {
+		return "", err
+	}
+	if saved := before - after; saved > 0 {
+		ui.Message(fmt.Sprintf("Space saved: %s", formatBytes(saved)))
+	} else {
+		ui.Message("Space saved: none")
+	}
+	return squashed, nil
+}
+
+// saveAndUnpack saves the image with `docker save` and unpacks the archive
+// in dir.
+func saveAndUnpack(driver Driver, image string, dir string) error {
+	r, w := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := driver.SaveImage(image, w)
+		w.CloseWithError(err)
+		errCh <- err
+	}()
+
+	err := unpackSaved(r, dir)
+	// Let SaveImage return if unpacking stopped early.
+	r.CloseWithError(errors.New("unpacking of the saved image stopped"))
+	if saveErr := <-errCh; saveErr != nil {
+		return saveErr
+	}
+	return err
+}
+
+// unpackSaved unpacks the regular files of a `docker save` archive in dir.
+func unpackSaved(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading saved image: %s", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		dst := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		f, err := os.Create(dst)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readSaved reads the manifest and the configuration of the image in an
+// unpacked `docker save` archive.
+func readSaved(dir string) (*saveManifest, map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading manifest of saved image: %s", err)
+	}
+	var manifests []saveManifest
+	if err := json.Unmarshal(data, &manifests); err != nil {
+		return nil, nil, fmt.Errorf("Error parsing manifest of saved image: %s", err)
+	}
+	if len(manifests) != 1 {
+		return nil, nil, fmt.Errorf("expected a single image in the saved image, found %d", len(manifests))
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+manifests[0].Config))))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading configuration of saved image: %s", err)
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("Error parsing configuration of saved image: %s", err)
+	}
+	return &manifests[0], config, nil
+}
+
+// squashHistory replaces the entries of the history for the squashed layers
+// with a single one.
+func squashHistory(raw json.RawMessage, baseLayers int, squashedLayers int) (json.RawMessage, error) {
+	var history []map[string]interface{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &history); err != nil {
+			return nil, fmt.Errorf("Error parsing history of saved image: %s", err)
+		}
+	}
+
+	// Keep the entries up to the last layer of the base image, and the
+	// ones following it that don't add layers.
+	kept := 0
+	for layers := 0; kept < len(history); kept++ {
+		empty, _ := history[kept]["empty_layer"].(bool)
+		if !empty {
+			if layers == baseLayers {
+				break
+			}
+			layers++
+		}
+	}
+
+	history = append(history[:kept:kept], map[string]interface{}{
+		"created":    time.Now().UTC().Format(time.RFC3339Nano),
+		"created_by": "packer squash",
+		"comment":    fmt.Sprintf("Squashed %d layers", squashedLayers),
+	})
+	return json.Marshal(history)
+}
+
+// writeLoadArchive writes an archive for `docker load` holding the base
+// layers of a saved image and the squashed layer, with config.
+func writeLoadArchive(dst string, saved string, baseLayers []string, squashed string, config map[string]json.RawMessage) error {
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	configName := fmt.Sprintf("%x.json", sha256.Sum256(configData))
+
+	manifest := []saveManifest{{
+		Config: configName,
+		Layers: append(baseLayers[:len(baseLayers):len(baseLayers)], "squashed/layer.tar"),
+	}}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	tw := tar.NewWriter(bw)
+
+	writeData := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	writeFile := func(name string, src string) error {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		fi, err := in.Stat()
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: fi.Size(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, in)
+		return err
+	}
+
+	if err := writeData("manifest.json", manifestData); err != nil {
+		return err
+	}
+	if err := writeData(configName, configData); err != nil {
+		return err
+	}
+	written := map[string]bool{}
+	for _, layer := range baseLayers {
+		if written[layer] {
+			continue
+		}
+		written[layer] = true
+		if err := writeFile(layer, filepath.Join(saved, filepath.FromSlash(path.Clean("/"+layer)))); err != nil {
+			return err
+		}
+	}
+	if err := writeFile("squashed/layer.tar", squashed); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// writeSquashedLayer merges layers, from the bottom one up, into a single
+// layer written to dst, and returns its digest. Whiteouts are kept if the
+// layer is applied on top of base layers.
+func writeSquashedLayer(dst string, layers []string, keepWhiteouts bool) (string, error) {
+	keep, opaque, err := squashEntries(layers, keepWhiteouts)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(f, h))
+	tw := tar.NewWriter(bw)
+
+	// Hard links are written last, once the files they link to are.
+	var links []*tar.Header
+	for i, layer := range layers {
+		err := readLayer(layer, func(j int, hdr *tar.Header, tr *tar.Reader) error {
+			if !keep[i][j] {
+				return nil
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				links = append(links, hdr)
+				return nil
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			_, err := io.Copy(tw, tr)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, dir := range opaque {
+		hdr := &tar.Header{
+			Name:     path.Join(dir, whiteoutOpaque),
+			Mode:     0644,
+			Typeflag: tar.TypeReg,
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+	}
+	for _, hdr := range links {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", err
+	}
+	if err := bw.Flush(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// squashEntries walks the layers from the top one down, and returns which
+// entries of each layer end up in the squashed layer, and the directories
+// that need an opaque whiteout because they were removed then created again.
+func squashEntries(layers []string, keepWhiteouts bool) ([][]bool, []string, error) {
+	// The paths provided by the layers above, and whether they are
+	// directories.
+	seen := map[string]bool{}
+	// The paths whose contents in the layers below are hidden: removed
+	// paths, paths that aren't directories, and opaque directories.
+	hidden := map[string]bool{}
+	// The paths removed by the layers above.
+	removed := map[string]bool{}
+	// The directories created again after being removed.
+	opaque := map[string]bool{}
+
+	isHidden := func(name string) bool {
+		if removed[name] {
+			return true
+		}
+		for dir := path.Dir(name); ; dir = path.Dir(dir) {
+			if hidden[dir] {
+				return true
+			}
+			if dir == "." || dir == "/" {
+				return false
+			}
+		}
+	}
+
+	keep := make([][]bool, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		layerSeen := map[string]bool{}
+		var layerHidden, layerRemoved []string
+
+		err := readLayer(layers[i], func(j int, hdr *tar.Header, _ *tar.Reader) error {
+			name := path.Clean(hdr.Name)
+			dir, base := path.Split(name)
+			dir = path.Clean(dir)
+			kept := false
+
+			switch {
+			case base == whiteoutOpaque:
+				if !isHidden(dir) && !seen[name] {
+					kept = keepWhiteouts
+					layerSeen[name] = false
+				}
+				layerHidden = append(layerHidden, dir)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				if isDir, ok := seen[target]; ok {
+					// A directory created again must not show the
+					// contents of the removed one.
+					if _, marked := seen[path.Join(target, whiteoutOpaque)]; isDir && keepWhiteouts && !marked {
+						opaque[target] = true
+					}
+				} else if !isHidden(target) {
+					kept = keepWhiteouts
+				}
+				layerRemoved = append(layerRemoved, target)
+				layerHidden = append(layerHidden, target)
+			default:
+				if _, ok := seen[name]; !ok && !isHidden(name) {
+					kept = true
+				}
+				isDir := hdr.Typeflag == tar.TypeDir
+				layerSeen[name] = isDir
+				if !isDir {
+					layerHidden = append(layerHidden, name)
+				}
+			}
+
+			keep[i] = append(keep[i], kept)
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The changes of a layer only apply to the layers below.
+		for name, isDir := range layerSeen {
+			if _, ok := seen[name]; !ok {
+				seen[name] = isDir
+			}
+		}
+		for _, name := range layerHidden {
+			hidden[name] = true
+		}
+		for _, name := range layerRemoved {
+			removed[name] = true
+		}
+	}
+	dirs := make([]string, 0, len(opaque))
+	for dir := range opaque {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return keep, dirs, nil
+}
+
+// readLayer calls fn with the index and the header of each entry of a
+// layer, which may be compressed with gzip.
+func readLayer(layer string, fn func(int, *tar.Header, *tar.Reader) error) error {
+	f, err := os.Open(layer)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading layer %s: %s", filepath.Base(layer), err)
+		}
+		if err := fn(i, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
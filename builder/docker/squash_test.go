@@ -0,0 +1,347 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testLayerEntry is an entry of a layer, a directory if its name ends with
+// a slash, a hard link if link is set, and a file otherwise.
+type testLayerEntry struct {
+	name    string
+	content string
+	link    string
+}
+
+func testLayer(t *testing.T, entries ...testLayerEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, e.link, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTestArchive returns the names of the entries of an archive, and the
+// contents of its files.
+func readTestArchive(t *testing.T, r io.Reader) ([]string, map[string]string) {
+	var names []string
+	contents := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, contents
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[hdr.Name] = string(data)
+	}
+}
+
+func testSquashLayers(t *testing.T) []string {
+	dir := t.TempDir()
+	layers := [][]byte{
+		testLayer(t,
+			testLayerEntry{name: "etc/"},
+			testLayerEntry{name: "etc/a", content: "a0"},
+			testLayerEntry{name: "etc/b", content: "b0"},
+			testLayerEntry{name: "var/"},
+			testLayerEntry{name: "var/cache/"},
+			testLayerEntry{name: "var/cache/x", content: "x0"},
+		),
+		testLayer(t,
+			testLayerEntry{name: "etc/.wh.b"},
+			testLayerEntry{name: "etc/a", content: "a1"},
+			testLayerEntry{name: "etc/link", link: "etc/a"},
+			testLayerEntry{name: "var/.wh.cache"},
+			testLayerEntry{name: "tmp/"},
+			testLayerEntry{name: "tmp/big", content: "big"},
+		),
+		testLayer(t,
+			testLayerEntry{name: ".wh.tmp"},
+			testLayerEntry{name: "var/cache/"},
+			testLayerEntry{name: "var/cache/y", content: "y2"},
+		),
+	}
+
+	var paths []string
+	for i, layer := range layers {
+		p := filepath.Join(dir, fmt.Sprintf("layer%d.tar", i))
+		if err := os.WriteFile(p, layer, 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func TestWriteSquashedLayer(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepWhiteouts bool
+		expected      []string
+	}{
+		{
+			"on top of base layers",
+			true,
+			[]string{
+				"etc/", "var/",
+				"etc/.wh.b", "etc/a",
+				".wh.tmp", "var/cache/", "var/cache/y",
+				"var/cache/.wh..wh..opq",
+				"etc/link",
+			},
+		},
+		{
+			"from scratch",
+			false,
+			[]string{
+				"etc/", "var/",
+				"etc/a",
+				"var/cache/", "var/cache/y",
+				"etc/link",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "squashed.tar")
+			diffID, err := writeSquashedLayer(dst, testSquashLayers(t), tt.keepWhiteouts)
+			if err != nil {
+				t.Fatalf("bad: %s", err)
+			}
+
+			data, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); diffID != expected {
+				t.Fatalf("expected diff ID %s, got %s", expected, diffID)
+			}
+
+			names, contents := readTestArchive(t, bytes.NewReader(data))
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Fatalf("bad entries: %#v", names)
+			}
+			if contents["etc/a"] != "a1" {
+				t.Fatalf("the file of the top layer should win: %q", contents["etc/a"])
+			}
+		})
+	}
+}
+
+// testSavedImage returns a `docker save` archive of an image with layers,
+// and the diff IDs of its layers.
+func testSavedImage(t *testing.T, layers ...[]byte) ([]byte, []string) {
+	var diffIDs, paths []string
+	var history []map[string]interface{}
+	for i, layer := range layers {
+		diffIDs = append(diffIDs, fmt.Sprintf("sha256:%x", sha256.Sum256(layer)))
+		paths = append(paths, fmt.Sprintf("blobs/sha256/%x", sha256.Sum256(layer)))
+		history = append(history,
+			map[string]interface{}{"created_by": fmt.Sprintf("layer %d", i)},
+			map[string]interface{}{"created_by": fmt.Sprintf("CMD %d", i), "empty_layer": true})
+	}
+
+	config, err := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"config":       map[string]interface{}{"Cmd": []string{"nginx"}, "Env": []string{"FOO=bar"}},
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+		"history":      history,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal([]saveManifest{{
+		Config: "blobs/sha256/config",
+		Layers: paths,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.WriteHeader(&tar.Header{Name: "blobs/", Mode: 0755, Typeflag: tar.TypeDir})
+	write("blobs/sha256/config", config)
+	for i, layer := range layers {
+		write(paths[i], layer)
+	}
+	write("manifest.json", manifest)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), diffIDs
+}
+
+func testSquashImage(t *testing.T) ([]byte, []string) {
+	var layers [][]byte
+	for _, p := range append([]string{""}, testSquashLayers(t)...) {
+		if p == "" {
+			layers = append(layers, testLayer(t, testLayerEntry{name: "bin/"}, testLayerEntry{name: "bin/sh", content: "sh"}))
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, data)
+	}
+	return testSavedImage(t, layers...)
+}
+
+func TestSquash(t *testing.T) {
+	tests := []struct {
+		name           string
+		base           string
+		expectedLayers int
+		expectedLayer  []string
+	}{
+		{"onto base", "base", 2, []string{"etc/", "var/", "etc/.wh.b", "etc/a", ".wh.tmp", "var/cache/", "var/cache/y", "var/cache/.wh..wh..opq", "etc/link"}},
+		{"all", "", 1, []string{"bin/", "bin/sh", "etc/", "var/", "etc/a", "var/cache/", "var/cache/y", "etc/link"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved, diffIDs := testSquashImage(t)
+			var loaded bytes.Buffer
+			driver := &MockDriver{
+				SaveImageReader:   bytes.NewReader(saved),
+				ImageLayersResult: diffIDs[:1],
+				LoadImageWriter:   &loaded,
+				LoadImageId:       "sha256:squashed",
+			}
+
+			id, err := Squash(driver, "image", tt.base, t.TempDir())
+			if err != nil {
+				t.Fatalf("bad: %s", err)
+			}
+			if id != "sha256:squashed" {
+				t.Fatalf("bad: %s", id)
+			}
+			if driver.SaveImageId != "image" {
+				t.Fatalf("bad saved image: %s", driver.SaveImageId)
+			}
+
+			_, files := readTestArchive(t, &loaded)
+			var manifest []saveManifest
+			if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if len(manifest) != 1 || len(manifest[0].Layers) != tt.expectedLayers {
+				t.Fatalf("bad manifest: %#v", manifest)
+			}
+
+			var config struct {
+				Config struct {
+					Cmd []string
+					Env []string
+				} `json:"config"`
+				RootFS struct {
+					DiffIDs []string `json:"diff_ids"`
+				} `json:"rootfs"`
+				History []map[string]interface{} `json:"history"`
+			}
+			if err := json.Unmarshal([]byte(files[manifest[0].Config]), &config); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(config.Config.Cmd, []string{"nginx"}) || !reflect.DeepEqual(config.Config.Env, []string{"FOO=bar"}) {
+				t.Fatalf("the image config should be preserved: %#v", config.Config)
+			}
+			if len(config.RootFS.DiffIDs) != tt.expectedLayers {
+				t.Fatalf("bad diff IDs: %#v", config.RootFS.DiffIDs)
+			}
+			if tt.base != "" && config.RootFS.DiffIDs[0] != diffIDs[0] {
+				t.Fatalf("the base layer should be kept: %#v", config.RootFS.DiffIDs)
+			}
+			// The base layer, the command following it, and the squashed
+			// layer.
+			if expected := 2*(tt.expectedLayers-1) + 1; len(config.History) != expected {
+				t.Fatalf("bad history: %#v", config.History)
+			}
+
+			squashed := files[manifest[0].Layers[len(manifest[0].Layers)-1]]
+			if diffID := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(squashed))); diffID != config.RootFS.DiffIDs[len(config.RootFS.DiffIDs)-1] {
+				t.Fatalf("bad diff ID of the squashed layer: %s", diffID)
+			}
+			names, _ := readTestArchive(t, bytes.NewReader([]byte(squashed)))
+			if !reflect.DeepEqual(names, tt.expectedLayer) {
+				t.Fatalf("bad squashed layer: %#v", names)
+			}
+		})
+	}
+}
+
+func TestSquash_notBasedOn(t *testing.T) {
+	saved, _ := testSquashImage(t)
+	driver := &MockDriver{
+		SaveImageReader:   bytes.NewReader(saved),
+		ImageLayersResult: []string{"sha256:other"},
+	}
+	if _, err := Squash(driver, "image", "base", t.TempDir()); err == nil {
+		t.Fatal("should error")
+	}
+	if driver.LoadImageCalled {
+		t.Fatal("should not have loaded an image")
+	}
+}
+
+func TestSquash_singleLayer(t *testing.T) {
+	saved, diffIDs := testSavedImage(t,
+		testLayer(t, testLayerEntry{name: "bin/sh", content: "sh"}),
+		testLayer(t, testLayerEntry{name: "app", content: "app"}))
+	driver := &MockDriver{
+		SaveImageReader:   bytes.NewReader(saved),
+		ImageLayersResult: diffIDs[:1],
+	}
+
+	id, err := Squash(driver, "image", "base", t.TempDir())
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	if id != "image" || driver.LoadImageCalled {
+		t.Fatalf("a single layer should be left as is: %s", id)
+	}
+}
@@ -4,8 +4,17 @@
 package docker
 
 import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
@@ -32,6 +41,9 @@ func (s *stepBuild) Run(ctx context.Context, state multistep.StateBag) multistep
 	}
 
 	ui.Say("Building base image...")
+	if len(s.buildArgs.baseImages) > 0 {
+		ui.Message(fmt.Sprintf("Base images: %s", strings.Join(s.buildArgs.baseImages, ", ")))
+	}
 
 	if config.EcrLogin {
 		ui.Message("Fetching ECR credentials...")
@@ -69,7 +81,27 @@ func (s *stepBuild) Run(ctx context.Context, state multistep.StateBag) multistep
 		}()
 	}
 
-	imageId, err := driver.Build(s.buildArgs.BuildArgs())
+	buildConfig := s.buildArgs
+	if buildConfig.Content != "" || buildConfig.ContextTarball != "" || buildConfig.ContextGit.Path != "" {
+		dir, err := os.MkdirTemp(state.Get("temp_dir").(string), "build")
+		if err != nil {
+			err := fmt.Errorf("Error creating build directory: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		defer os.RemoveAll(dir)
+
+		buildConfig, err = prepareBuildDir(buildConfig, dir)
+		if err != nil {
+			err := fmt.Errorf("Error preparing the build: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	imageId, err := driver.BuildImage(buildConfig)
 	if err != nil {
 		state.Put("error", err)
 		return multistep.ActionHalt
@@ -84,6 +116,84 @@ func (s *stepBuild) Run(ctx context.Context, state multistep.StateBag) multistep
 	return multistep.ActionContinue
 }
 
+// prepareBuildDir writes the inline Dockerfile and extracts the build context
+// of the config in dir, and returns the config to build from there.
+func prepareBuildDir(c DockerfileBootstrapConfig, dir string) (DockerfileBootstrapConfig, error) {
+	if c.Content != "" {
+		c.DockerfilePath = filepath.Join(dir, "Dockerfile")
+		if err := os.WriteFile(c.DockerfilePath, []byte(c.Content), 0644); err != nil {
+			return c, fmt.Errorf("failed to write Dockerfile: %s", err)
+		}
+	}
+
+	contextDir := filepath.Join(dir, "context")
+	switch {
+	case c.ContextTarball != "":
+		if err := extractTarball(c.ContextTarball, contextDir); err != nil {
+			return c, fmt.Errorf("failed to extract context tarball %q: %s", c.ContextTarball, err)
+		}
+		c.BuildDir = contextDir
+	case c.ContextGit.Path != "":
+		if err := extractGitRevision(c.ContextGit.Path, c.ContextGit.Ref, contextDir); err != nil {
+			return c, fmt.Errorf("failed to extract %q from git context %q: %s", c.ContextGit.Ref, c.ContextGit.Path, err)
+		}
+		c.BuildDir = contextDir
+	}
+
+	return c, nil
+}
+
+// extractTarball extracts a tar archive, compressed with gzip or not, in dst.
+func extractTarball(src string, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if magic, err := r.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return extractArchive(tar.NewReader(r), dst, nil)
+}
+
+// extractGitRevision extracts the files of the ref revision of the git
+// repository at repo in dst.
+func extractGitRevision(repo string, ref string, dst string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", repo, "archive", "--format=tar", ref)
+	cmd.Stderr = &stderr
+
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	if err := extractArchive(tar.NewReader(pipe), dst, nil); err != nil {
+		// Don't leave git blocked on a pipe nobody reads anymore
+		//nolint:errcheck
+		cmd.Process.Kill()
+		//nolint:errcheck
+		cmd.Wait()
+		return err
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return nil
+}
+
 func (s *stepBuild) Cleanup(state multistep.StateBag) {
 	if !s.ran {
 		return
@@ -96,13 +206,34 @@ func (s *stepBuild) Cleanup(state multistep.StateBag) {
 		return
 	}
 
-	if !config.Discard {
-		ui.Say("final image is not discarded, removing the built image will fail because of existing dependencies, skipping cleanup for docker build.")
+	switch s.buildArgs.Cleanup {
+	case BuildCleanupNever:
 		return
+	case BuildCleanupOnSuccess:
+		_, cancelled := state.GetOk(multistep.StateCancelled)
+		_, halted := state.GetOk(multistep.StateHalted)
+		if cancelled || halted {
+			ui.Sayf("Build failed, keeping the bootstrap image %q", config.Image)
+			return
+		}
 	}
 
 	driver := state.Get("driver").(Driver)
 
+	// Removing the tags of an image only deletes it once the last one is
+	// removed, and if no other image depends on it.
+	for _, tag := range s.buildArgs.Tags {
+		if err := driver.DeleteImage(tag); err != nil {
+			ui.Sayf("failed to untag bootstrap image %q: %s", tag, err)
+		}
+	}
+
+	// Once committed, the final image depends on the layers of the bootstrap
+	// image, which can't be deleted.
+	if _, committed := state.GetOk("image_id"); committed || len(s.buildArgs.Tags) > 0 {
+		return
+	}
+
 	err := driver.DeleteImage(config.Image)
 	if err != nil {
 		ui.Sayf("failed to remove image %q: %s", config.Image, err)
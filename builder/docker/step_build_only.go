@@ -0,0 +1,55 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+// stepBuildOnly uses the image built by stepBuild as the final image of a
+// `build_only` build.
+type stepBuildOnly struct {
+	GeneratedData *packerbuilderdata.GeneratedData
+}
+
+func (s *stepBuildOnly) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config, ok := state.Get("config").(*Config)
+	if !ok {
+		err := fmt.Errorf("error encountered obtaining docker config")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	driver := state.Get("driver").(Driver)
+
+	// stepBuild replaced the image with the one it built
+	imageId := config.Image
+	state.Put("image_id", imageId)
+
+	s256, err := driver.Sha256(imageId)
+	if err != nil {
+		err := fmt.Errorf("Error determining the built image Id: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	s.GeneratedData.Put("ImageSha256", s256)
+	// The built image is also the source image, which was only built
+	// locally and has no distribution digest.
+	s.GeneratedData.Put("SourceImageSha256", s256)
+	s.GeneratedData.Put("SourceImageDigest", "")
+
+	ui.Message(fmt.Sprintf("Image ID: %s", imageId))
+
+	return multistep.ActionContinue
+}
+
+func (s *stepBuildOnly) Cleanup(state multistep.StateBag) {}
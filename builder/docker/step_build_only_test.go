@@ -0,0 +1,59 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+func TestStepBuildOnly_impl(t *testing.T) {
+	var _ multistep.Step = new(stepBuildOnly)
+}
+
+func TestStepBuildOnly(t *testing.T) {
+	state := testState(t)
+	config := state.Get("config").(*Config)
+	config.Image = "sha256:1234"
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.Sha256Result = "sha256:af61410def4ae2aece7c1b8d94b82ef434c8ee76e0e69001230f6636aea58cd1"
+
+	step := &stepBuildOnly{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	if id := state.Get("image_id").(string); id != config.Image {
+		t.Fatalf("bad image ID: %#v", id)
+	}
+
+	genData := state.Get("generated_data").(map[string]interface{})
+	if imSha := genData["ImageSha256"].(string); imSha != driver.Sha256Result {
+		t.Fatalf("Bad: image sha wasn't set properly; received %s", imSha)
+	}
+}
+
+func TestStepBuildOnly_error(t *testing.T) {
+	state := testState(t)
+	state.Get("config").(*Config).Image = "sha256:1234"
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.Sha256Err = errors.New("foo")
+
+	step := &stepBuildOnly{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestPrepareBuildDir_content(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := prepareBuildDir(DockerfileBootstrapConfig{
+		Content:  "FROM alpine\n",
+		BuildDir: ".",
+	}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if c.DockerfilePath != filepath.Join(dir, "Dockerfile") {
+		t.Errorf("unexpected Dockerfile path %q", c.DockerfilePath)
+	}
+	contents, err := os.ReadFile(c.DockerfilePath)
+	if err != nil || string(contents) != "FROM alpine\n" {
+		t.Errorf("bad Dockerfile contents %q: %v", contents, err)
+	}
+	if c.BuildDir != "." {
+		t.Errorf("build dir should not change, got %q", c.BuildDir)
+	}
+}
+
+func TestPrepareBuildDir_tarball(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		tarball := filepath.Join(t.TempDir(), "context.tar")
+		f, err := os.Create(tarball)
+		if err != nil {
+			t.Fatalf("failed to create tarball: %s", err)
+		}
+
+		var archive *tar.Writer
+		var gz *gzip.Writer
+		if compress {
+			gz = gzip.NewWriter(f)
+			archive = tar.NewWriter(gz)
+		} else {
+			archive = tar.NewWriter(f)
+		}
+		archive.WriteHeader(&tar.Header{Name: "app/main.go", Typeflag: tar.TypeReg, Mode: 0644, Size: 12})
+		archive.Write([]byte("package main"))
+		archive.Close()
+		if gz != nil {
+			gz.Close()
+		}
+		f.Close()
+
+		dir := t.TempDir()
+		c, err := prepareBuildDir(DockerfileBootstrapConfig{
+			DockerfilePath: "/src/Dockerfile",
+			ContextTarball: tarball,
+		}, dir)
+		if err != nil {
+			t.Fatalf("compress %t: unexpected error: %s", compress, err)
+		}
+
+		if c.BuildDir != filepath.Join(dir, "context") {
+			t.Errorf("compress %t: unexpected build dir %q", compress, c.BuildDir)
+		}
+		if c.DockerfilePath != "/src/Dockerfile" {
+			t.Errorf("compress %t: Dockerfile path should not change, got %q", compress, c.DockerfilePath)
+		}
+		contents, err := os.ReadFile(filepath.Join(c.BuildDir, "app", "main.go"))
+		if err != nil || string(contents) != "package main" {
+			t.Errorf("compress %t: bad context contents %q: %v", compress, contents, err)
+		}
+	}
+}
+
+func TestPrepareBuildDir_git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=packer", "GIT_AUTHOR_EMAIL=packer@example.com",
+			"GIT_COMMITTER_NAME=packer", "GIT_COMMITTER_EMAIL=packer@example.com")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s %s", args, err, output)
+		}
+	}
+
+	git("init", "-q")
+	os.WriteFile(filepath.Join(repo, "VERSION"), []byte("1"), 0644)
+	git("add", "VERSION")
+	git("commit", "-q", "-m", "first")
+	git("tag", "v1")
+	os.WriteFile(filepath.Join(repo, "VERSION"), []byte("2"), 0644)
+	git("commit", "-q", "-a", "-m", "second")
+
+	gitContext := BuildContextGit{Path: repo, Ref: "v1"}
+	if err := gitContext.Prepare(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	dir := t.TempDir()
+	c, err := prepareBuildDir(DockerfileBootstrapConfig{
+		Content:    "FROM alpine\n",
+		ContextGit: gitContext,
+	}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	contents, err := os.ReadFile(filepath.Join(c.BuildDir, "VERSION"))
+	if err != nil || string(contents) != "1" {
+		t.Errorf("context should be extracted from v1, got %q: %v", contents, err)
+	}
+
+	unknown := BuildContextGit{Path: repo, Ref: "v2"}
+	if err := unknown.Prepare(); err == nil {
+		t.Errorf("expected an error for an unknown revision")
+	}
+}
+
+func TestStepBuild_cleanup(t *testing.T) {
+	tests := []struct {
+		name      string
+		cleanup   string
+		tags      []string
+		committed bool
+		failed    bool
+		expected  []string
+	}{
+		{"always", BuildCleanupAlways, nil, false, false, []string{"sha256:1234"}},
+		{"always on failure", BuildCleanupAlways, nil, false, true, []string{"sha256:1234"}},
+		{"on success", BuildCleanupOnSuccess, nil, false, false, []string{"sha256:1234"}},
+		{"on success after a failure", BuildCleanupOnSuccess, nil, false, true, nil},
+		{"never", BuildCleanupNever, []string{"app:bootstrap"}, false, false, nil},
+		{"tagged", BuildCleanupAlways, []string{"app:bootstrap", "app:latest"}, false, false, []string{"app:bootstrap", "app:latest"}},
+		{"committed", BuildCleanupAlways, nil, true, false, nil},
+		{"committed and tagged", BuildCleanupAlways, []string{"app:bootstrap"}, true, false, []string{"app:bootstrap"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := testState(t)
+			state.Get("config").(*Config).Image = "sha256:1234"
+			if tt.committed {
+				state.Put("image_id", "sha256:5678")
+			}
+			if tt.failed {
+				state.Put(multistep.StateHalted, true)
+			}
+
+			step := &stepBuild{
+				buildArgs: DockerfileBootstrapConfig{
+					DockerfilePath: "Dockerfile",
+					Tags:           tt.tags,
+					Cleanup:        tt.cleanup,
+				},
+				ran: true,
+			}
+			step.Cleanup(state)
+
+			driver := state.Get("driver").(*MockDriver)
+			if !reflect.DeepEqual(driver.DeleteImageIds, tt.expected) {
+				t.Errorf("expected deleted images %v, got %v", tt.expected, driver.DeleteImageIds)
+			}
+		})
+	}
+}
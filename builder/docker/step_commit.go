@@ -6,6 +6,7 @@ package docker
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -30,24 +31,40 @@ func (s *StepCommit) Run(ctx context.Context, state multistep.StateBag) multiste
 
 	driver := state.Get("driver").(Driver)
 	containerId := state.Get("container_id").(string)
-	if config.WindowsContainer {
-		// docker can't commit a running Windows container
-		err := driver.StopContainer(containerId)
-		if err != nil {
-			state.Put("error", err)
-			ui.Error(fmt.Sprintf("Error halting windows container for commit: %s",
-				err.Error()))
-			return multistep.ActionHalt
-		}
+	// With commit_per_provisioner, the container holds the last layer.
+	layers, _ := state.Get("image_layers").([]imageLayer)
+	message := config.Message
+	if len(layers) > 0 && layers[len(layers)-1].ImageId == "" && message == "" {
+		message = layers[len(layers)-1].Name
+	}
+
+	changes := config.Changes
+	if config.OCIAnnotations {
+		// The annotations go first, so that they can be overridden.
+		sourceDigest, _ := state.Get("source_digest").(string)
+		annotations := ociAnnotations(config.Image, sourceDigest, config.templateDir(), time.Now())
+		changes = append(LabelChanges(annotations), changes...)
+	}
+	if fp, ok := state.GetOk("fingerprint"); ok {
+		changes = append(changes[:len(changes):len(changes)], fmt.Sprintf("LABEL %s=%s", fingerprintLabel, fp))
 	}
+
 	ui.Say("Committing the container")
-	imageId, err := driver.Commit(containerId, config.Author, config.Changes, config.Message)
+	imageId, err := driver.Commit(containerId, config.Author, changes, message)
 	if err != nil {
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
+	if config.CommitPerProvisioner {
+		if err := reportLayers(ui, driver, config.Image, imageId, layers); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	// Save the container ID to state and to generated data
 	s.imageId = imageId
 	state.Put("image_id", s.imageId)
@@ -62,3 +79,34 @@ func (s *StepCommit) Run(ctx context.Context, state multistep.StateBag) multiste
 }
 
 func (s *StepCommit) Cleanup(state multistep.StateBag) {}
+
+// reportLayers prints the size of the layers committed for each provisioner,
+// the last one being the image committed from the container.
+func reportLayers(ui packersdk.Ui, driver Driver, baseImage string, imageId string, layers []imageLayer) error {
+	if len(layers) == 0 {
+		return nil
+	}
+
+	if last := &layers[len(layers)-1]; last.ImageId == "" {
+		parent := baseImage
+		if len(layers) > 1 {
+			parent = layers[len(layers)-2].ImageId
+		}
+		parentSize, err := driver.ImageSize(parent)
+		if err != nil {
+			return fmt.Errorf("Error reading size of image %s: %s", parent, err)
+		}
+		size, err := driver.ImageSize(imageId)
+		if err != nil {
+			return fmt.Errorf("Error reading size of image %s: %s", imageId, err)
+		}
+		last.ImageId = imageId
+		last.Size = size - parentSize
+	}
+
+	ui.Say(fmt.Sprintf("Image layers (%d):", len(layers)))
+	for _, layer := range layers {
+		ui.Message(fmt.Sprintf("%10s  %s", formatBytes(layer.Size), layer.Name))
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ package docker
 import (
 	"context"
 	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -81,3 +82,97 @@ func TestStepCommit_error(t *testing.T) {
 		t.Fatal("shouldn't save image ID")
 	}
 }
+
+func TestStepCommit_layers(t *testing.T) {
+	state := testStepCommitState(t)
+	state.Get("config").(*Config).CommitPerProvisioner = true
+	state.Put("image_layers", []imageLayer{
+		{Name: "Provisioning with shell script: setup.sh", ImageId: "layer", Size: 1024},
+		{Name: "Uploading app => /app"},
+	})
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.CommitImageId = "bar"
+
+	step := &StepCommit{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	// The last layer is named after its provisioner
+	if len(driver.CommitMessages) != 1 || driver.CommitMessages[0] != "Uploading app => /app" {
+		t.Fatalf("bad commit messages: %#v", driver.CommitMessages)
+	}
+	if !driver.ImageSizeCalled || driver.ImageSizeId != "bar" {
+		t.Fatalf("should have read the size of the image, read %q", driver.ImageSizeId)
+	}
+}
+
+func TestStepCommit_fingerprint(t *testing.T) {
+	state := testStepCommitState(t)
+	state.Put("fingerprint", "1234")
+	config := state.Get("config").(*Config)
+	config.Changes = []string{"EXPOSE 80"}
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.CommitImageId = "bar"
+
+	step := &StepCommit{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	expected := []string{"EXPOSE 80", "LABEL packer.fingerprint=1234"}
+	if !reflect.DeepEqual(driver.CommitChanges, expected) {
+		t.Fatalf("expected changes %#v, got %#v", expected, driver.CommitChanges)
+	}
+	if len(config.Changes) != 1 {
+		t.Fatalf("config changes should not have been modified: %#v", config.Changes)
+	}
+}
+
+func TestStepCommit_ociAnnotations(t *testing.T) {
+	state := testStepCommitState(t)
+	state.Put("source_digest", "ubuntu@sha256:abcd")
+	config := state.Get("config").(*Config)
+	config.Image = "ubuntu"
+	config.OCIAnnotations = true
+	config.Changes = []string{"EXPOSE 80"}
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.CommitImageId = "bar"
+
+	step := &StepCommit{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	changes := driver.CommitChanges
+	if changes[len(changes)-1] != "EXPOSE 80" {
+		t.Fatalf("the changes of the config should be applied last: %#v", changes)
+	}
+	for _, expected := range []string{
+		`LABEL "org.opencontainers.image.base.digest"="sha256:abcd"`,
+		`LABEL "org.opencontainers.image.base.name"="ubuntu"`,
+	} {
+		found := false
+		for _, change := range changes {
+			found = found || change == expected
+		}
+		if !found {
+			t.Fatalf("expected change %q in %#v", expected, changes)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
 type StepConnectDocker struct{}
@@ -26,6 +27,7 @@ func (s *StepConnectDocker) Run(ctx context.Context, state multistep.StateBag) m
 	containerId := state.Get("container_id").(string)
 	driver := state.Get("driver").(Driver)
 	tempDir := state.Get("temp_dir").(string)
+	ui := state.Get("ui").(packersdk.Ui)
 
 	// Get the version so we can pass it to the communicator
 	version, err := driver.Version()
@@ -52,6 +54,7 @@ func (s *StepConnectDocker) Run(ctx context.Context, state multistep.StateBag) m
 			Config:        config,
 			ContainerUser: containerUser,
 			EntryPoint:    []string{"powershell"},
+			Ui:            ui,
 		},
 		}
 		state.Put("communicator", comm)
@@ -65,7 +68,8 @@ func (s *StepConnectDocker) Run(ctx context.Context, state multistep.StateBag) m
 			Version:       version,
 			Config:        config,
 			ContainerUser: containerUser,
-			EntryPoint:    []string{"/bin/sh", "-c"},
+			EntryPoint:    config.Shell,
+			Ui:            ui,
 		}
 		state.Put("communicator", comm)
 	}
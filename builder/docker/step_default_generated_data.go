@@ -15,7 +15,7 @@ import (
 // _something_ to read in the provisioners, regardless of whether the value.
 // was created.
 // The true values are put in generated data in the steps where the
-// values are actually created (step pull, and step commit)
+// values are actually created (step pull, step commit and step export)
 type StepDefaultGeneratedData struct {
 	GeneratedData *packerbuilderdata.GeneratedData
 }
@@ -24,6 +24,9 @@ func (s *StepDefaultGeneratedData) Run(_ context.Context, state multistep.StateB
 	s.GeneratedData.Put("ImageSha256", "ERR_IMAGE_SHA256_NOT_FOUND")
 	s.GeneratedData.Put("SourceImageDigest", "ERR_SOURCE_IMAGE_DIGEST_NOT_FOUND")
 	s.GeneratedData.Put("SourceImageSha256", "ERR_SOURCE_IMAGE_SHA256_NOT_FOUND")
+	s.GeneratedData.Put("Fingerprint", "ERR_FINGERPRINT_NOT_FOUND")
+	s.GeneratedData.Put("ExportSha256", "ERR_EXPORT_SHA256_NOT_FOUND")
+	s.GeneratedData.Put("ExportSha512", "ERR_EXPORT_SHA512_NOT_FOUND")
 
 	return multistep.ActionContinue
 }
@@ -5,16 +5,31 @@ package docker
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
-// StepExport exports the container to a flat tar file.
-type StepExport struct{}
+// StepExport exports the container to a flat tar file, a filesystem image
+// or an LXC image, or the image committed from it to an image archive or
+// layout. Files are compressed while they are exported, and their checksums
+// are written next to them.
+//
+// Produces:
+//
+//	export_files []string - The files of the OCI image layout.
+//	export_checksums map[string]string - The checksums of the file, by type.
+//	export_checksum_files []string - The files the checksums are written to.
+type StepExport struct {
+	GeneratedData *packerbuilderdata.GeneratedData
+}
 
 func (s *StepExport) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
@@ -41,6 +56,50 @@ func (s *StepExport) Run(ctx context.Context, state multistep.StateBag) multiste
 		return multistep.ActionHalt
 	}
 
+	driver := state.Get("driver").(Driver)
+	containerId := state.Get("container_id").(string)
+	// The image committed from the container, for the image formats.
+	imageId, _ := state.Get("image_id").(string)
+	// The image is saved there while it is converted to the OCI formats.
+	tempDir, _ := state.Get("temp_dir").(string)
+
+	if config.ExportFormat == ExportFormatOCILayout {
+		ui.Say("Exporting the image as an OCI image layout")
+		if err := checkOCILayoutPath(config.ExportPath); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		files, err := exportOCILayout(driver, imageId, config.ExportPath, tempDir)
+		if err != nil {
+			err := fmt.Errorf("Error exporting image: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		state.Put("export_files", files)
+		return multistep.ActionContinue
+	}
+
+	compression := config.ExportCompression
+	if compression == "" {
+		compression = ExportCompressionNone
+	}
+
+	// Filesystem images are written to a temporary file, which only needs
+	// to be copied to be compressed or checksummed.
+	if config.exportsFilesystem() && compression == ExportCompressionNone && len(config.ExportChecksums) == 0 {
+		ui.Say(fmt.Sprintf("Exporting the container as %s", config.ExportFormat))
+		if err := exportFilesystemFile(driver, containerId, config); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		state.Put("export_checksums", map[string]string{})
+		state.Put("export_checksum_files", []string(nil))
+		return multistep.ActionContinue
+	}
+
 	// Open the file that we're going to write to
 	f, err := os.Create(config.ExportPath)
 	if err != nil {
@@ -50,21 +109,97 @@ func (s *StepExport) Run(ctx context.Context, state multistep.StateBag) multiste
 		return multistep.ActionHalt
 	}
 
-	driver := state.Get("driver").(Driver)
-	containerId := state.Get("container_id").(string)
+	// The checksums are of the compressed file, as written.
+	hashes := map[string]hash.Hash{}
+	writers := []io.Writer{f}
+	for _, t := range config.ExportChecksums {
+		hashes[t] = exportChecksums[t]()
+		writers = append(writers, hashes[t])
+	}
 
-	ui.Say("Exporting the container")
-	if err := driver.Export(containerId, f); err != nil {
+	w, err := compressExport(io.MultiWriter(writers...), compression, config.ExportCompressionLevel)
+	if err != nil {
 		f.Close()
 		os.Remove(f.Name())
 
+		err := fmt.Errorf("Error compressing output file: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	what := "the container"
+	if config.exportsImage() {
+		what = "the image as " + config.ExportFormat
+	} else if config.exportsFilesystem() {
+		what = "the container as " + config.ExportFormat
+	} else if config.ExportFormat == ExportFormatLXC {
+		what = "the container as an LXC image"
+	}
+	if compression == ExportCompressionNone {
+		ui.Say(fmt.Sprintf("Exporting %s", what))
+	} else {
+		ui.Say(fmt.Sprintf("Exporting %s, compressed with %s", what, compression))
+	}
+	switch config.ExportFormat {
+	case ExportFormatDockerArchive:
+		err = driver.SaveImage(imageId, w)
+	case ExportFormatOCIArchive:
+		err = exportOCIArchive(driver, imageId, w, tempDir)
+	case ExportFormatExt4, ExportFormatSquashfs:
+		err = exportFilesystem(driver, containerId, config, w)
+	case ExportFormatLXC:
+		err = exportLXCImage(driver, containerId, config, w)
+	default:
+		err = driver.Export(containerId, w)
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+
 		state.Put("error", err)
 		ui.Error(err.Error())
 		return multistep.ActionHalt
 	}
 
-	f.Close()
+	checksums := map[string]string{}
+	var files []string
+	for _, t := range config.ExportChecksums {
+		sum := hex.EncodeToString(hashes[t].Sum(nil))
+		path := config.ExportPath + "." + t
+		contents := exportChecksumFile(sum, filepath.Base(config.ExportPath))
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			err := fmt.Errorf("Error writing checksum file: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Message(fmt.Sprintf("%s: %s", t, sum))
+
+		checksums[t] = sum
+		files = append(files, path)
+		s.GeneratedData.Put(exportChecksumData[t], sum)
+	}
+	state.Put("export_checksums", checksums)
+	state.Put("export_checksum_files", files)
+
 	return multistep.ActionContinue
 }
 
-func (s *StepExport) Cleanup(state multistep.StateBag) {}
+func (s *StepExport) Cleanup(state multistep.StateBag) {
+	// The image was only committed to be exported.
+	imageId, ok := state.GetOk("image_id")
+	if !ok {
+		return
+	}
+	ui := state.Get("ui").(packersdk.Ui)
+	driver := state.Get("driver").(Driver)
+	if err := driver.DeleteImage(imageId.(string)); err != nil {
+		ui.Error(fmt.Sprintf("Error deleting image %s: %s", imageId, err))
+	}
+}
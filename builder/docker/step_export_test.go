@@ -4,14 +4,22 @@
 package docker
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 func testStepExportState(t *testing.T) multistep.StateBag {
@@ -102,3 +110,229 @@ func TestStepExport_error(t *testing.T) {
 		t.Fatal("export path shouldn't exist")
 	}
 }
+
+func TestStepExport_compressed(t *testing.T) {
+	state := testStepExportState(t)
+	step := &StepExport{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	config := state.Get("config").(*Config)
+	config.ExportPath = filepath.Join(t.TempDir(), "rootfs.tar.gz")
+	config.ExportCompression = ExportCompressionGzip
+	config.ExportChecksums = []string{ExportChecksumSha256, ExportChecksumSha512}
+	driver := state.Get("driver").(*MockDriver)
+	driver.ExportReader = bytes.NewReader([]byte("data!"))
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	contents, err := os.ReadFile(config.ExportPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(contents))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "data!" {
+		t.Fatalf("bad: %#v", string(data))
+	}
+
+	// The checksums are of the compressed file.
+	sha256sum := fmt.Sprintf("%x", sha256.Sum256(contents))
+	sha512sum := fmt.Sprintf("%x", sha512.Sum512(contents))
+	checksums := state.Get("export_checksums").(map[string]string)
+	if checksums["sha256"] != sha256sum || checksums["sha512"] != sha512sum {
+		t.Fatalf("bad checksums: %#v", checksums)
+	}
+	genData := state.Get("generated_data").(map[string]interface{})
+	if genData["ExportSha256"] != sha256sum || genData["ExportSha512"] != sha512sum {
+		t.Fatalf("bad generated data: %#v", genData)
+	}
+
+	files := state.Get("export_checksum_files").([]string)
+	if len(files) != 2 || files[0] != config.ExportPath+".sha256" {
+		t.Fatalf("bad checksum files: %#v", files)
+	}
+	sidecar, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if expected := sha256sum + "  rootfs.tar.gz\n"; string(sidecar) != expected {
+		t.Fatalf("bad checksum file: %q", sidecar)
+	}
+}
+
+func TestStepExport_image(t *testing.T) {
+	for _, format := range []string{ExportFormatDockerArchive, ExportFormatOCIArchive, ExportFormatOCILayout} {
+		t.Run(format, func(t *testing.T) {
+			state := testStepExportState(t)
+			state.Put("image_id", "1234")
+			step := new(StepExport)
+
+			config := state.Get("config").(*Config)
+			config.ExportPath = filepath.Join(t.TempDir(), "image")
+			config.ExportFormat = format
+			driver := state.Get("driver").(*MockDriver)
+			saved, _ := testSavedImage(t, testLayer(t, testLayerEntry{name: "bin/sh", content: "sh"}))
+			driver.SaveImageReader = bytes.NewReader(saved)
+
+			if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("bad action: %#v", action)
+			}
+			if driver.ExportCalled {
+				t.Fatal("should not have exported the container")
+			}
+			if driver.SaveImageId != "1234" {
+				t.Fatalf("should have saved the committed image: %s", driver.SaveImageId)
+			}
+
+			fi, err := os.Stat(config.ExportPath)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if fi.IsDir() != (format == ExportFormatOCILayout) {
+				t.Fatalf("bad export path: %s", fi.Mode())
+			}
+			if files, _ := state.Get("export_files").([]string); (len(files) > 0) != fi.IsDir() {
+				t.Fatalf("bad files: %#v", files)
+			}
+
+			// The image was only committed to be exported.
+			step.Cleanup(state)
+			if driver.DeleteImageId != "1234" {
+				t.Fatalf("should have deleted the image: %s", driver.DeleteImageId)
+			}
+		})
+	}
+}
+
+func TestStepExport_filesystem(t *testing.T) {
+	for _, format := range []string{ExportFormatExt4, ExportFormatSquashfs} {
+		t.Run(format, func(t *testing.T) {
+			state := testStepExportState(t)
+			step := new(StepExport)
+			defer step.Cleanup(state)
+
+			config := state.Get("config").(*Config)
+			config.ExportPath = filepath.Join(t.TempDir(), "rootfs."+format)
+			config.ExportFormat = format
+			config.ExportFSHeadroom = 20
+			driver := state.Get("driver").(*MockDriver)
+			driver.ExportReader = bytes.NewReader(testExportedContainer(t))
+
+			if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("bad action: %#v", action)
+			}
+			if driver.ExportID != "foo" {
+				t.Fatalf("should have exported the container: %s", driver.ExportID)
+			}
+
+			data, err := os.ReadFile(config.ExportPath)
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			magic := map[string][]byte{
+				ExportFormatExt4:     data[1024+0x38 : 1024+0x3a],
+				ExportFormatSquashfs: data[:4],
+			}
+			expected := map[string][]byte{
+				ExportFormatExt4:     {0x53, 0xef},
+				ExportFormatSquashfs: []byte("hsqs"),
+			}
+			if !bytes.Equal(magic[format], expected[format]) {
+				t.Fatalf("bad magic: %x", magic[format])
+			}
+
+			// Only the filesystem image is left in the directory.
+			entries, err := os.ReadDir(filepath.Dir(config.ExportPath))
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("bad: %d files", len(entries))
+			}
+		})
+	}
+}
+
+func TestStepExport_filesystemChecksum(t *testing.T) {
+	state := testStepExportState(t)
+	step := &StepExport{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	config := state.Get("config").(*Config)
+	config.ExportPath = filepath.Join(t.TempDir(), "rootfs.squashfs")
+	config.ExportFormat = ExportFormatSquashfs
+	config.ExportChecksums = []string{ExportChecksumSha256}
+	driver := state.Get("driver").(*MockDriver)
+	driver.ExportReader = bytes.NewReader(testExportedContainer(t))
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	// The image is copied from the temporary file, and checksummed.
+	contents, err := os.ReadFile(config.ExportPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	checksums := state.Get("export_checksums").(map[string]string)
+	if checksums["sha256"] != fmt.Sprintf("%x", sha256.Sum256(contents)) {
+		t.Fatalf("bad: %#v", checksums)
+	}
+	entries, err := os.ReadDir(filepath.Dir(config.ExportPath))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("bad: %d files", len(entries))
+	}
+}
+
+func TestStepExport_lxc(t *testing.T) {
+	state := testStepExportState(t)
+	step := new(StepExport)
+	defer step.Cleanup(state)
+
+	config := state.Get("config").(*Config)
+	config.ExportPath = filepath.Join(t.TempDir(), "image.tar.gz")
+	config.ExportFormat = ExportFormatLXC
+	config.ExportCompression = ExportCompressionGzip
+	config.Platform = "linux/arm64"
+	config.Message = "provisioned"
+	driver := state.Get("driver").(*MockDriver)
+	driver.ExportReader = bytes.NewReader(testExportedContainer(t))
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	f, err := os.Open(config.ExportPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != "metadata.yaml" {
+		t.Fatalf("bad: %#v, %v", hdr, err)
+	}
+	metadata, _ := io.ReadAll(tr)
+	if !bytes.Contains(metadata, []byte(`architecture: "aarch64"`)) || !bytes.Contains(metadata, []byte(`description: "provisioned"`)) {
+		t.Fatalf("bad metadata:\n%s", metadata)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+// stepFingerprint computes the fingerprint of the inputs of the build, and
+// looks for an image built from the same inputs. If there is one, it is used
+// as the artifact, and "unchanged" is put in the state so that the following
+// steps, wrapped in stepUnlessUnchanged, don't run.
+//
+// It runs after StepPull, which finds the digest of the source image, or
+// before stepBuild with a `build` configuration, so that the bootstrap image
+// is only built when needed. The base images of its Dockerfile then stand for
+// the source image.
+//
+// The fingerprint is put in the state as "fingerprint", for StepCommit to
+// label the image with it.
+type stepFingerprint struct {
+	GeneratedData *packerbuilderdata.GeneratedData
+}
+
+func (s *stepFingerprint) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	driver := state.Get("driver").(Driver)
+	config, ok := state.Get("config").(*Config)
+	if !ok {
+		err := fmt.Errorf("error encountered obtaining docker config")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	// The distribution digest identifies the source image best, but local
+	// images only have an ID.
+	var source string
+	if config.BuildConfig.IsDefault() {
+		source, _ = state.Get("source_digest").(string)
+		if source == "" {
+			source, _ = state.Get("source_sha256").(string)
+		}
+	} else {
+		source = baseImageIDs(driver, &config.BuildConfig)
+	}
+
+	fp, err := fingerprint(config, source)
+	if err != nil {
+		err := fmt.Errorf("Error computing fingerprint: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	state.Put("fingerprint", fp)
+	s.GeneratedData.Put("Fingerprint", fp)
+
+	ui.Say(fmt.Sprintf("Looking for an image with fingerprint %s", fp))
+	imageId, err := driver.FindImage(fingerprintLabel, fp)
+	if err != nil {
+		err := fmt.Errorf("Error looking for image: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	if imageId == "" && config.FingerprintRepository != "" {
+		ref := fmt.Sprintf("%s:%s", config.FingerprintRepository, fp)
+		ui.Message(fmt.Sprintf("Pulling %s", ref))
+		if err := driver.Pull(ref, config.Platform); err != nil {
+			log.Printf("Image %s not found: %s", ref, err)
+		} else if imageId, err = driver.FindImage(fingerprintLabel, fp); err != nil {
+			err := fmt.Errorf("Error looking for image: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	if imageId != "" {
+		ui.Message(fmt.Sprintf("Found image %s, skipping the build", imageId))
+		state.Put("image_id", imageId)
+		state.Put("unchanged", true)
+		s.GeneratedData.Put("ImageSha256", imageId)
+		return multistep.ActionContinue
+	}
+
+	ui.Message("No image found, building it")
+	return multistep.ActionContinue
+}
+
+func (s *stepFingerprint) Cleanup(state multistep.StateBag) {}
+
+// baseImageIDs returns the IDs of the base images of the Dockerfile of the
+// bootstrap image, which are pulled like `docker build` would when they
+// aren't there. The name of an image is used when it can't be pulled.
+func baseImageIDs(driver Driver, c *DockerfileBootstrapConfig) string {
+	var ids []string
+	for _, image := range c.baseImages {
+		id, err := driver.Sha256(image)
+		if err != nil || id == "" {
+			if err := driver.Pull(image, c.Platform); err != nil {
+				log.Printf("Error pulling base image %s: %s", image, err)
+			}
+			id, err = driver.Sha256(image)
+		}
+		if err != nil || id == "" {
+			log.Printf("Using the name of base image %s in the fingerprint: %v", image, err)
+			id = image
+		}
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, "\x00")
+}
+
+// unlessUnchanged wraps each of the steps in a stepUnlessUnchanged.
+func unlessUnchanged(steps []multistep.Step) []multistep.Step {
+	wrapped := make([]multistep.Step, len(steps))
+	for i, step := range steps {
+		wrapped[i] = &stepUnlessUnchanged{Step: step}
+	}
+	return wrapped
+}
+
+// stepUnlessUnchanged runs Step unless stepFingerprint found an image built
+// from the same inputs.
+type stepUnlessUnchanged struct {
+	Step multistep.Step
+
+	ran bool
+}
+
+func (s *stepUnlessUnchanged) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if _, ok := state.GetOk("unchanged"); ok {
+		return multistep.ActionContinue
+	}
+	s.ran = true
+	return s.Step.Run(ctx, state)
+}
+
+func (s *stepUnlessUnchanged) Cleanup(state multistep.StateBag) {
+	if s.ran {
+		s.Step.Cleanup(state)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+// testRunStep records whether it was run and cleaned up.
+type testRunStep struct {
+	run     bool
+	cleaned bool
+}
+
+func (s *testRunStep) Run(context.Context, multistep.StateBag) multistep.StepAction {
+	s.run = true
+	return multistep.ActionContinue
+}
+
+func (s *testRunStep) Cleanup(multistep.StateBag) {
+	s.cleaned = true
+}
+
+func TestStepFingerprint_impl(t *testing.T) {
+	var _ multistep.Step = new(stepFingerprint)
+}
+
+func TestStepFingerprint_found(t *testing.T) {
+	state := testState(t)
+	state.Put("source_digest", "ubuntu@sha256:1234")
+	driver := state.Get("driver").(*MockDriver)
+	driver.FindImageResult = "sha256:cached"
+
+	step := &stepFingerprint{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	if _, ok := state.GetOk("unchanged"); !ok {
+		t.Errorf("build should be skipped")
+	}
+	if driver.FindImageLabel != fingerprintLabel || driver.FindImageValue != state.Get("fingerprint") {
+		t.Errorf("bad image lookup: %s=%s", driver.FindImageLabel, driver.FindImageValue)
+	}
+	if id := state.Get("image_id"); id != "sha256:cached" {
+		t.Errorf("bad image ID: %#v", id)
+	}
+}
+
+func TestStepFingerprint_notFound(t *testing.T) {
+	state := testState(t)
+	state.Put("source_digest", "ubuntu@sha256:1234")
+	state.Get("config").(*Config).FingerprintRepository = "registry.example.com/app"
+	driver := state.Get("driver").(*MockDriver)
+
+	step := &stepFingerprint{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	if _, ok := state.GetOk("unchanged"); ok {
+		t.Errorf("build should not be skipped")
+	}
+	if expected := "registry.example.com/app:" + state.Get("fingerprint").(string); driver.PullImage != expected {
+		t.Errorf("should have pulled %q, pulled %q", expected, driver.PullImage)
+	}
+	if _, ok := state.GetOk("image_id"); ok {
+		t.Errorf("should not have set an image ID")
+	}
+}
+
+func TestStepFingerprint_bootstrap(t *testing.T) {
+	state := testState(t)
+	config := state.Get("config").(*Config)
+	config.BuildConfig = DockerfileBootstrapConfig{
+		Content:    "FROM ubuntu\n",
+		BuildDir:   t.TempDir(),
+		baseImages: []string{"ubuntu"},
+	}
+	driver := state.Get("driver").(*MockDriver)
+	driver.Sha256Result = "sha256:ubuntu"
+
+	step := &stepFingerprint{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if driver.Sha256Id != "ubuntu" {
+		t.Errorf("should have looked up the base image, looked up %q", driver.Sha256Id)
+	}
+	expected, err := fingerprint(config, "sha256:ubuntu")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := state.Get("fingerprint"); got != expected {
+		t.Errorf("expected fingerprint %s, got %s", expected, got)
+	}
+
+	// Missing base images are pulled, like docker build would
+	driver.Sha256Err = errors.New("No such image")
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if driver.PullImage != "ubuntu" {
+		t.Errorf("should have pulled the base image, pulled %q", driver.PullImage)
+	}
+}
+
+func TestStepUnlessUnchanged(t *testing.T) {
+	state := testState(t)
+	inner := &testRunStep{}
+	step := &stepUnlessUnchanged{Step: inner}
+
+	state.Put("unchanged", true)
+	step.Run(context.Background(), state)
+	step.Cleanup(state)
+	if inner.run || inner.cleaned {
+		t.Errorf("step should have been skipped")
+	}
+
+	state.Remove("unchanged")
+	step.Run(context.Background(), state)
+	step.Cleanup(state)
+	if !inner.run || !inner.cleaned {
+		t.Errorf("step should have run")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// stepPreCommit gets the container ready to be committed or exported: it
+// runs the `pre_commit_command`, removes the `cleanup_paths`, checks that
+// `container_dir` is still mounted, then stops or pauses the container as
+// configured by `commit_stop`.
+type stepPreCommit struct {
+	pausedId string
+}
+
+func (s *stepPreCommit) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config, ok := state.Get("config").(*Config)
+	if !ok {
+		err := fmt.Errorf("error encountered obtaining docker config")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	driver := state.Get("driver").(Driver)
+	containerId := state.Get("container_id").(string)
+
+	if config.PreCommitCommand != "" {
+		ui.Say(fmt.Sprintf("Running pre-commit command: %s", config.PreCommitCommand))
+		comm := state.Get("communicator").(packersdk.Communicator)
+		cmd := &packersdk.RemoteCmd{Command: config.PreCommitCommand}
+		if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+			err := fmt.Errorf("Error running pre-commit command: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		if status := cmd.ExitStatus(); status != 0 {
+			err := fmt.Errorf("Pre-commit command exited with non-zero exit status: %d", status)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	if paths := cleanupPaths(config); len(paths) > 0 {
+		ui.Say("Cleaning up the container")
+		comm := state.Get("communicator").(packersdk.Communicator)
+		// Only the docker communicator can run the cleanup as root.
+		reclaimed, err := runCleanup(ctx, comm, paths, config.Comm.Type == "docker")
+		if err != nil {
+			err := fmt.Errorf("Error cleaning up the container: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Message(fmt.Sprintf("Reclaimed %s", formatBytes(reclaimed)))
+	}
+
+	if comm, ok := state.Get("communicator").(packersdk.Communicator); ok {
+		if err := checkContainerDir(ctx, comm, config); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	switch config.CommitStop {
+	case CommitStopStop:
+		ui.Say("Stopping the container")
+		if err := driver.StopContainerWithSignal(containerId, config.CommitStopSignal, config.CommitStopTimeout); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	case CommitStopPause:
+		ui.Say("Pausing the container")
+		if err := driver.PauseContainer(containerId); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		s.pausedId = containerId
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *stepPreCommit) Cleanup(state multistep.StateBag) {
+	if s.pausedId == "" {
+		return
+	}
+
+	// A paused container can't be killed, resume it so that it can be
+	// cleaned up.
+	driver := state.Get("driver").(Driver)
+	if err := driver.UnpauseContainer(s.pausedId); err != nil {
+		ui := state.Get("ui").(packersdk.Ui)
+		ui.Error(err.Error())
+	}
+	s.pausedId = ""
+}
+
+// checkContainerDir fails if `container_dir` isn't a mount point in the
+// container anymore, like after a provisioner unmounted it, as the files
+// written to it since would then be in the image. It can only be checked
+// with the docker communicator, in a linux container with a shell.
+func checkContainerDir(ctx context.Context, comm packersdk.Communicator, config *Config) error {
+	if config.Comm.Type != "docker" || config.NoShell {
+		return nil
+	}
+	cmd := &packersdk.RemoteCmd{Command: mountCheckScript(config.ContainerDir)}
+	if err := comm.Start(ctx, cmd); err != nil {
+		return fmt.Errorf("Error checking that %s is mounted: %s", config.ContainerDir, err)
+	}
+	if status := cmd.Wait(); status != 0 {
+		return fmt.Errorf("%s isn't mounted in the container anymore, "+
+			"the files written to it would be in the image", config.ContainerDir)
+	}
+	return nil
+}
+
+// mountCheckScript returns a shell script exiting with 0 if dir is a mount
+// point, as listed in /proc/self/mountinfo, which escapes the whitespace
+// and backslashes of the mount points in octal.
+func mountCheckScript(dir string) string {
+	escaped := strings.NewReplacer(`\`, `\134`, " ", `\040`, "\t", `\011`, "\n", `\012`).Replace(path.Clean(dir))
+	return fmt.Sprintf(`while read -r _ _ _ _ m _; do [ "$m" = %s ] && exit 0; done < /proc/self/mountinfo; exit 1`,
+		"'"+strings.ReplaceAll(escaped, "'", `'\''`)+"'")
+}
@@ -0,0 +1,242 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func testStepPreCommitState(t *testing.T) multistep.StateBag {
+	state := testState(t)
+	state.Put("container_id", "foo")
+	state.Put("communicator", new(recordingCommunicator))
+	return state
+}
+
+// recordingCommunicator records the commands it runs.
+type recordingCommunicator struct {
+	packersdk.MockCommunicator
+	commands []string
+}
+
+func (c *recordingCommunicator) Start(ctx context.Context, cmd *packersdk.RemoteCmd) error {
+	c.commands = append(c.commands, cmd.Command)
+	return c.MockCommunicator.Start(ctx, cmd)
+}
+
+func TestStepPreCommit_impl(t *testing.T) {
+	var _ multistep.Step = new(stepPreCommit)
+}
+
+func TestStepPreCommit_none(t *testing.T) {
+	state := testStepPreCommitState(t)
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	driver := state.Get("driver").(*MockDriver)
+	if driver.StopCalled || driver.PauseCalled {
+		t.Fatal("should not have stopped or paused the container")
+	}
+	comm := state.Get("communicator").(*recordingCommunicator)
+	// Only the mount of the container dir is checked.
+	if len(comm.commands) != 1 || comm.commands[0] != mountCheckScript("/packer-files") {
+		t.Fatalf("bad commands: %#v", comm.commands)
+	}
+}
+
+func TestStepPreCommit_stop(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.CommitStop = CommitStopStop
+	config.CommitStopSignal = "SIGINT"
+	config.CommitStopTimeout = 30 * time.Second
+	config.PreCommitCommand = "sync"
+
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	comm := state.Get("communicator").(*recordingCommunicator)
+	if len(comm.commands) == 0 || comm.commands[0] != "sync" {
+		t.Fatalf("should have run the pre-commit command: %#v", comm.commands)
+	}
+	driver := state.Get("driver").(*MockDriver)
+	if !driver.StopCalled || driver.StopID != "foo" {
+		t.Fatalf("should have stopped the container: %#v", driver.StopID)
+	}
+	if driver.StopSignal != "SIGINT" || driver.StopTimeout != 30*time.Second {
+		t.Fatalf("bad stop options: %q %s", driver.StopSignal, driver.StopTimeout)
+	}
+}
+
+func TestStepPreCommit_pause(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.CommitStop = CommitStopPause
+
+	step := new(stepPreCommit)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	driver := state.Get("driver").(*MockDriver)
+	if !driver.PauseCalled || driver.PauseID != "foo" {
+		t.Fatalf("should have paused the container: %#v", driver.PauseID)
+	}
+	if driver.UnpauseCalled {
+		t.Fatal("should not have unpaused the container yet")
+	}
+
+	step.Cleanup(state)
+	if !driver.UnpauseCalled || driver.UnpauseID != "foo" {
+		t.Fatalf("should have unpaused the container: %#v", driver.UnpauseID)
+	}
+}
+
+func TestStepPreCommit_commandFailure(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.CommitStop = CommitStopStop
+	config.PreCommitCommand = "apt-get clean"
+
+	comm := state.Get("communicator").(*recordingCommunicator)
+	comm.StartExitStatus = 1
+
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	driver := state.Get("driver").(*MockDriver)
+	if driver.StopCalled {
+		t.Fatal("should not have stopped the container")
+	}
+}
+
+func TestStepPreCommit_stopError(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.CommitStop = CommitStopStop
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.StopError = errors.New("foo")
+
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+}
+
+func TestStepPreCommit_cleanup(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.CleanupPresets = []string{"apt"}
+
+	comm := state.Get("communicator").(*recordingCommunicator)
+	comm.StartStdout = "2048\n"
+
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if len(comm.commands) != 2 || !strings.Contains(comm.commands[0], "'/var/lib/apt/lists/'*") {
+		t.Fatalf("should have removed the apt lists: %#v", comm.commands)
+	}
+	if !strings.HasPrefix(comm.commands[0], execUserPrefix+"root ") {
+		t.Fatalf("should have run as root: %s", comm.commands[0])
+	}
+	if strings.Contains(comm.commands[0], "'/packer-files/'*") {
+		t.Fatalf("should not have emptied the container dir: %s", comm.commands[0])
+	}
+}
+
+func TestStepPreCommit_unmounted(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.CommitStop = CommitStopStop
+
+	comm := state.Get("communicator").(*recordingCommunicator)
+	comm.StartExitStatus = 1
+
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	driver := state.Get("driver").(*MockDriver)
+	if driver.StopCalled {
+		t.Fatal("should not have stopped the container")
+	}
+}
+
+func TestStepPreCommit_noShell(t *testing.T) {
+	state := testStepPreCommitState(t)
+	config := state.Get("config").(*Config)
+	config.NoShell = true
+
+	step := new(stepPreCommit)
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+	comm := state.Get("communicator").(*recordingCommunicator)
+	if len(comm.commands) > 0 {
+		t.Fatalf("should not have run a command: %#v", comm.commands)
+	}
+}
+
+func TestMountCheckScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	if _, err := os.Stat("/proc/self/mountinfo"); err != nil {
+		t.Skip("/proc/self/mountinfo not found")
+	}
+
+	for dir, mounted := range map[string]bool{
+		"/":                            true,
+		"/proc":                        true,
+		"/proc/":                       true,
+		t.TempDir():                    false,
+		"/proc/self/it's not mounted ": false,
+	} {
+		err := exec.Command(sh, "-c", mountCheckScript(dir)).Run()
+		if got := err == nil; got != mounted {
+			t.Errorf("%q: expected mounted %t, got %s", dir, mounted, err)
+		}
+	}
+}
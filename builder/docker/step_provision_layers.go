@@ -0,0 +1,474 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/multistep/commonsteps"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// imageLayer is a layer committed by `commit_per_provisioner`.
+type imageLayer struct {
+	// Name is the message printed when its provisioner started, it is used
+	// as the commit message.
+	Name    string
+	ImageId string
+	// Size is the size of the layer in bytes.
+	Size int64
+}
+
+// stepProvisionLayers runs the provisioners like commonsteps.StepProvision,
+// committing the container in between each of them.
+//
+// Packer doesn't call builders when a provisioner starts, but the provision
+// hook announces each provisioner with a "Provisioning with <type>" message.
+// The first communicator operation following it, after the container was
+// used, starts a new layer: the container is committed and replaced by one
+// started from the new image. The messages of the provisioners themselves
+// don't start layers.
+//
+// With `checkpoint`, each layer is also tagged with a key computed from the
+// base image and the position, type and entry of `checkpoint_keys` of the
+// provisioners so far. On the next run, the provisioners found in
+// checkpoints are skipped, see provisionLayers.operation.
+//
+// The layers are put in the state as "image_layers", the last one is left for
+// StepCommit to commit.
+type stepProvisionLayers struct {
+	StepProvision *commonsteps.StepProvision
+
+	layers *provisionLayers
+}
+
+func (s *stepProvisionLayers) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config := state.Get("config").(*Config)
+	driver := state.Get("driver").(Driver)
+
+	comm, ok := state.Get("communicator").(*Communicator)
+	if !ok {
+		err := fmt.Errorf("commit_per_provisioner requires the docker communicator")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	parentSize, err := driver.ImageSize(config.Image)
+	if err != nil {
+		err := fmt.Errorf("Error reading size of image %s: %s", config.Image, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	s.layers = &provisionLayers{
+		ctx:        ctx,
+		state:      state,
+		config:     config,
+		driver:     driver,
+		ui:         ui,
+		comm:       comm,
+		tempDir:    state.Get("temp_dir").(string),
+		parentSize: parentSize,
+	}
+
+	if config.Checkpoint {
+		// The checkpoints are keyed by the ID of the base image, which is
+		// the digest of its configuration.
+		imageId, err := driver.Sha256(config.Image)
+		if err != nil {
+			err := fmt.Errorf("Error reading ID of image %s: %s", config.Image, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		s.layers.checkpoint = true
+		s.layers.resuming = true
+		s.layers.key = imageId
+	}
+
+	s.StepProvision.Comm = &layerCommunicator{Communicator: comm, layers: s.layers}
+	state.Put("ui", &layerUi{Ui: ui, layers: s.layers})
+	action := s.StepProvision.Run(ctx, state)
+	state.Put("ui", ui)
+
+	if action == multistep.ActionContinue {
+		if err := s.layers.finish(); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
+	state.Put("image_layers", s.layers.imageLayers())
+	return action
+}
+
+func (s *stepProvisionLayers) Cleanup(state multistep.StateBag) {
+	if s.layers == nil {
+		return
+	}
+
+	// The cleanup provisioner doesn't get a layer of its own.
+	s.layers.disable()
+	s.StepProvision.Cleanup(state)
+
+	ui := state.Get("ui").(packersdk.Ui)
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		// The checkpoints are only needed until the build succeeds. The
+		// images stay around as the parents of the final image, only
+		// their tags are removed.
+		for _, tag := range s.layers.tags {
+			if err := s.layers.driver.DeleteImage(tag); err != nil {
+				log.Printf("Error removing checkpoint %s: %s", tag, err)
+			}
+		}
+		return
+	}
+
+	// The layers are kept so that the state of the container before the
+	// failing provisioner can be inspected, and the build resumed from
+	// them with `checkpoint`.
+	for _, layer := range s.layers.imageLayers() {
+		if layer.ImageId != "" {
+			ui.Message(fmt.Sprintf("Keeping layer image %s (%s)", layer.ImageId, layer.Name))
+		}
+	}
+}
+
+// provisionLayers tracks the layers of the image while the provisioners run.
+type provisionLayers struct {
+	ctx     context.Context
+	state   multistep.StateBag
+	config  *Config
+	driver  Driver
+	ui      packersdk.Ui
+	comm    *Communicator
+	tempDir string
+
+	// lock is held while a layer is committed, so that no communicator
+	// operation runs in the container being replaced.
+	lock sync.Mutex
+	// layers are the committed layers.
+	layers []imageLayer
+	// parentSize is the size of the image the container was started from.
+	parentSize int64
+	// name is the name of the current layer, used tells whether the
+	// container was used since the last commit.
+	name string
+	used bool
+	// next is the name of the next layer, set when a provisioner starts.
+	next     string
+	hasNext  bool
+	disabled bool
+
+	// count is the number of provisioners announced so far, and position
+	// the position of the one of the current layer, counted from 1.
+	count    int
+	position int
+
+	// checkpoint is set with `checkpoint`, the following fields are only
+	// used then.
+	checkpoint bool
+	// key is the key of the last checkpoint, or the ID of the base image,
+	// and layerKey the key of the current layer. They are empty once a
+	// provisioner has no entry in `checkpoint_keys`, as the following ones
+	// can't be checkpointed either.
+	key      string
+	layerKey string
+	// resuming is set while the provisioners are found in checkpoints,
+	// skipped is set when the current one is, and pending is the image of
+	// the last checkpoint found, which the container must be started from
+	// before anything runs in it.
+	resuming bool
+	skipped  bool
+	pending  string
+	// tags are the checkpoints created or used by this build.
+	tags []string
+}
+
+// provisionerMessage matches the message of the provision hook starting a
+// provisioner, like "Provisioning with shell...", but not the ones printed
+// by provisioners, like "Provisioning with shell script: setup.sh".
+var provisionerMessage = regexp.MustCompile(`^Provisioning with ([a-zA-Z0-9_-]+)(\.\.\.)?$`)
+
+// say records the start of a provisioner, if message announces one.
+func (l *provisionLayers) say(message string) {
+	message = strings.TrimSpace(message)
+	if !provisionerMessage.MatchString(message) {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.next = message
+	l.hasNext = true
+	l.count++
+}
+
+// disable stops committing layers.
+func (l *provisionLayers) disable() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.disabled = true
+}
+
+// operation is called before each communicator operation, and commits the
+// current layer when a new provisioner started.
+//
+// It returns whether the operation must be skipped, when the provisioner was
+// found in a checkpoint: its commands and uploads already ran when the
+// checkpoint was committed. Downloads aren't skipped, the container is
+// started from the last checkpoint found instead, as it is before anything
+// runs once a provisioner isn't found.
+func (l *provisionLayers) operation(download bool) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.disabled {
+		return false, nil
+	}
+
+	if l.hasNext {
+		if l.used {
+			if err := l.commit(); err != nil {
+				return false, err
+			}
+		}
+		l.name, l.hasNext, l.used = l.next, false, false
+		l.position = l.count
+		if l.checkpoint {
+			if err := l.startLayer(); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if l.skipped {
+		if !download {
+			return true, nil
+		}
+	} else {
+		l.used = true
+	}
+	return false, l.resume()
+}
+
+// startLayer computes the key of the checkpoint of the current layer, and
+// skips the layer when the checkpoint exists and the previous layers were
+// found too.
+func (l *provisionLayers) startLayer() error {
+	l.skipped = false
+	l.layerKey = ""
+	if l.key == "" || l.position > len(l.config.CheckpointKeys) {
+		l.key = ""
+		l.resuming = false
+		return nil
+	}
+
+	typ := provisionerMessage.FindStringSubmatch(l.name)[1]
+	l.layerKey = checkpointKey(l.key, l.position, typ, l.config.CheckpointKeys[l.position-1])
+	if !l.resuming {
+		return nil
+	}
+	imageId, err := l.driver.Sha256(checkpointTag(l.layerKey))
+	if err != nil {
+		log.Printf("No checkpoint %s: %s", l.layerKey, err)
+		l.resuming = false
+		return nil
+	}
+
+	l.ui.Say(fmt.Sprintf("Using checkpoint for: %s", l.name))
+	if err := l.addLayer(imageId); err != nil {
+		return err
+	}
+	l.key = l.layerKey
+	l.tags = append(l.tags, checkpointTag(l.layerKey))
+	l.pending = imageId
+	l.skipped = true
+	return nil
+}
+
+// resume starts the container from the last checkpoint found, if it wasn't
+// yet.
+func (l *provisionLayers) resume() error {
+	if l.pending == "" {
+		return nil
+	}
+	l.ui.Say(fmt.Sprintf("Resuming from checkpoint %s", l.pending))
+	if err := l.restart(l.pending); err != nil {
+		return err
+	}
+	l.pending = ""
+	return nil
+}
+
+// finish brings the container up to date once all the provisioners ran,
+// when the last ones were found in checkpoints.
+func (l *provisionLayers) finish() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.resume()
+}
+
+// commit commits the current layer, and replaces the container with one
+// started from it.
+func (l *provisionLayers) commit() error {
+	if err := checkContainerDir(l.ctx, l.comm, l.config); err != nil {
+		return err
+	}
+	containerId := l.state.Get("container_id").(string)
+
+	l.ui.Say(fmt.Sprintf("Committing layer: %s", l.name))
+	imageId, err := l.driver.Commit(containerId, l.config.Author, nil, l.name)
+	if err != nil {
+		return fmt.Errorf("Error committing layer: %s", err)
+	}
+	if err := l.addLayer(imageId); err != nil {
+		return err
+	}
+
+	if l.layerKey != "" {
+		if err := l.driver.TagImage(imageId, checkpointTag(l.layerKey), true); err != nil {
+			return fmt.Errorf("Error tagging checkpoint: %s", err)
+		}
+		l.tags = append(l.tags, checkpointTag(l.layerKey))
+		l.key = l.layerKey
+	}
+
+	return l.restart(imageId)
+}
+
+// addLayer records the image of the current layer.
+func (l *provisionLayers) addLayer(imageId string) error {
+	size, err := l.driver.ImageSize(imageId)
+	if err != nil {
+		return fmt.Errorf("Error reading size of image %s: %s", imageId, err)
+	}
+	l.layers = append(l.layers, imageLayer{
+		Name:    l.name,
+		ImageId: imageId,
+		Size:    size - l.parentSize,
+	})
+	l.parentSize = size
+	return nil
+}
+
+// restart replaces the container with one started from image. It is
+// refused while commands run in the container.
+func (l *provisionLayers) restart(image string) error {
+	var containerId string
+	err := l.comm.replaceContainer(func(old string) (string, error) {
+		if err := l.driver.KillContainer(old); err != nil {
+			return "", fmt.Errorf("Error killing container %s: %s", old, err)
+		}
+
+		runConfig := newContainerConfig(l.config, image, l.tempDir)
+		id, err := l.driver.StartContainer(&runConfig)
+		if err != nil {
+			return "", fmt.Errorf("Error running container from layer %s: %s", image, err)
+		}
+		containerId = id
+		return id, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	l.state.Put("container_id", containerId)
+	l.state.Put("instance_id", containerId)
+	l.ui.Message(fmt.Sprintf("Container ID: %s", containerId))
+	return nil
+}
+
+// imageLayers returns the committed layers, followed by the current one if
+// the container was used since the last commit.
+func (l *provisionLayers) imageLayers() []imageLayer {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	layers := append([]imageLayer{}, l.layers...)
+	if l.used {
+		layers = append(layers, imageLayer{Name: l.name})
+	}
+	return layers
+}
+
+// layerUi records the messages announcing the provisioners.
+type layerUi struct {
+	packersdk.Ui
+	layers *provisionLayers
+}
+
+func (u *layerUi) Say(message string) {
+	u.layers.say(message)
+	u.Ui.Say(message)
+}
+
+// layerCommunicator starts a new layer before the first operation of each
+// provisioner, and skips the operations of the provisioners found in
+// checkpoints.
+type layerCommunicator struct {
+	*Communicator
+	layers *provisionLayers
+}
+
+var _ packersdk.Communicator = new(layerCommunicator)
+
+func (c *layerCommunicator) Start(ctx context.Context, remote *packersdk.RemoteCmd) error {
+	skip, err := c.layers.operation(false)
+	if err != nil {
+		return err
+	}
+	if skip {
+		remote.SetExited(0)
+		return nil
+	}
+	return c.Communicator.Start(ctx, remote)
+}
+
+func (c *layerCommunicator) Upload(dst string, src io.Reader, fi *os.FileInfo) error {
+	skip, err := c.layers.operation(false)
+	if err != nil || skip {
+		return err
+	}
+	return c.Communicator.Upload(dst, src, fi)
+}
+
+func (c *layerCommunicator) UploadDir(dst string, src string, exclude []string) error {
+	skip, err := c.layers.operation(false)
+	if err != nil || skip {
+		return err
+	}
+	return c.Communicator.UploadDir(dst, src, exclude)
+}
+
+func (c *layerCommunicator) Download(src string, dst io.Writer) error {
+	if _, err := c.layers.operation(true); err != nil {
+		return err
+	}
+	return c.Communicator.Download(src, dst)
+}
+
+func (c *layerCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	if _, err := c.layers.operation(true); err != nil {
+		return err
+	}
+	return c.Communicator.DownloadDir(src, dst, exclude)
+}
@@ -0,0 +1,353 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestStepProvisionLayers_impl(t *testing.T) {
+	var _ multistep.Step = new(stepProvisionLayers)
+}
+
+func TestProvisionLayers(t *testing.T) {
+	state := testState(t)
+	state.Put("container_id", "first")
+	state.Put("temp_dir", "/tmp/packer")
+
+	// There is no container to check the mount of container_dir in.
+	state.Get("config").(*Config).NoShell = true
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.CommitImageId = "layer"
+	driver.StartID = "second"
+	comm := &Communicator{ContainerID: "first"}
+
+	layers := &provisionLayers{
+		state:  state,
+		config: state.Get("config").(*Config),
+		driver: driver,
+		ui:     state.Get("ui").(packersdk.Ui),
+		comm:   comm,
+	}
+	ui := &layerUi{Ui: layers.ui, layers: layers}
+
+	operation := func() {
+		t.Helper()
+		if _, err := layers.operation(false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// The first provisioner doesn't need a commit
+	ui.Say("Provisioning with shell...")
+	ui.Say("Provisioning with shell script: setup.sh")
+	operation()
+	operation()
+	if driver.CommitCalled {
+		t.Fatal("should not have committed")
+	}
+
+	ui.Say("Provisioning with file...")
+	ui.Say("Uploading app => /app")
+	operation()
+	if !reflect.DeepEqual(driver.CommitMessages, []string{"Provisioning with shell..."}) {
+		t.Fatalf("bad commit messages: %#v", driver.CommitMessages)
+	}
+	if driver.KillID != "first" {
+		t.Errorf("should have killed the first container, killed %q", driver.KillID)
+	}
+	if driver.StartConfig.Image != "layer" {
+		t.Errorf("should have started from the layer, started from %q", driver.StartConfig.Image)
+	}
+	if state.Get("container_id") != "second" || state.Get("instance_id") != "second" || comm.ContainerID != "second" {
+		t.Errorf("container should have been replaced")
+	}
+
+	// Provisioners that don't use the container don't get a layer
+	ui.Say("Provisioning with breakpoint...")
+	ui.Say("Pausing 1s before the next provisioner...")
+	ui.Say("Provisioning with shell...")
+	operation()
+
+	expected := []imageLayer{
+		{Name: "Provisioning with shell...", ImageId: "layer"},
+		{Name: "Provisioning with file...", ImageId: "layer"},
+		{Name: "Provisioning with shell..."},
+	}
+	if got := layers.imageLayers(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected layers %#v, got %#v", expected, got)
+	}
+
+	// Nothing is committed once disabled
+	layers.disable()
+	ui.Say("Provisioning with shell...")
+	operation()
+	if len(driver.CommitMessages) != 2 {
+		t.Fatalf("bad commit messages: %#v", driver.CommitMessages)
+	}
+}
+
+func TestProvisionLayers_provisionerMessages(t *testing.T) {
+	state := testState(t)
+	state.Put("container_id", "first")
+	state.Put("temp_dir", "/tmp/packer")
+
+	// There is no container to check the mount of container_dir in.
+	state.Get("config").(*Config).NoShell = true
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.CommitImageId = "layer"
+	driver.StartID = "second"
+
+	layers := &provisionLayers{
+		state:  state,
+		config: state.Get("config").(*Config),
+		driver: driver,
+		ui:     state.Get("ui").(packersdk.Ui),
+		comm:   &Communicator{ContainerID: "first"},
+	}
+	ui := &layerUi{Ui: layers.ui, layers: layers}
+
+	// A provisioner saying things in between its commands is one layer
+	ui.Say("Provisioning with shell...")
+	for _, script := range []string{"setup.sh", "install.sh", "cleanup.sh"} {
+		ui.Say("Provisioning with shell script: " + script)
+		if _, err := layers.operation(false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		ui.Say("Running " + script)
+	}
+	if driver.CommitCalled {
+		t.Fatalf("should not have committed: %#v", driver.CommitMessages)
+	}
+
+	expected := []imageLayer{{Name: "Provisioning with shell..."}}
+	if got := layers.imageLayers(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected layers %#v, got %#v", expected, got)
+	}
+}
+
+// checkpointDriver keeps the checkpoints tagged, across builds.
+type checkpointDriver struct {
+	*MockDriver
+	commits int
+	tags    map[string]string
+}
+
+func newCheckpointDriver() *checkpointDriver {
+	return &checkpointDriver{MockDriver: &MockDriver{StartID: "container"}, tags: map[string]string{}}
+}
+
+func (d *checkpointDriver) Commit(id string, author string, changes []string, message string) (string, error) {
+	d.MockDriver.Commit(id, author, changes, message)
+	d.commits++
+	return fmt.Sprintf("sha256:layer%d", d.commits), nil
+}
+
+func (d *checkpointDriver) TagImage(id string, repo string, force bool) error {
+	d.MockDriver.TagImage(id, repo, force)
+	d.tags[repo] = id
+	return nil
+}
+
+func (d *checkpointDriver) Sha256(id string) (string, error) {
+	d.MockDriver.Sha256(id)
+	if imageId, ok := d.tags[id]; ok {
+		return imageId, nil
+	}
+	return "", fmt.Errorf("No such image: %s", id)
+}
+
+func testCheckpointLayers(t *testing.T, driver *checkpointDriver, keys ...string) (*provisionLayers, *layerUi, *layerCommunicator) {
+	comm := testExecCommunicator(t)
+
+	state := testState(t)
+	state.Put("container_id", "container")
+	state.Put("temp_dir", t.TempDir())
+	state.Put("driver", driver)
+
+	config := state.Get("config").(*Config)
+	config.CheckpointKeys = keys
+	// The commands run on the host, without container_dir.
+	config.NoShell = true
+
+	layers := &provisionLayers{
+		ctx:        context.Background(),
+		state:      state,
+		config:     config,
+		driver:     driver,
+		ui:         state.Get("ui").(packersdk.Ui),
+		comm:       comm,
+		tempDir:    state.Get("temp_dir").(string),
+		checkpoint: true,
+		resuming:   true,
+		key:        "sha256:base",
+	}
+	return layers, &layerUi{Ui: layers.ui, layers: layers}, &layerCommunicator{Communicator: comm, layers: layers}
+}
+
+// testProvision runs the commands of each shell provisioner, and returns
+// their output and exit statuses.
+func testProvision(t *testing.T, layers *provisionLayers, ui packersdk.Ui, comm packersdk.Communicator, provisioners ...[]string) (string, []int) {
+	t.Helper()
+	var stdout bytes.Buffer
+	var statuses []int
+	for _, commands := range provisioners {
+		ui.Say("Provisioning with shell...")
+		for _, command := range commands {
+			cmd := &packersdk.RemoteCmd{Command: command, Stdout: &stdout}
+			if err := comm.Start(context.Background(), cmd); err != nil {
+				t.Fatalf("failed to start %q: %s", command, err)
+			}
+			statuses = append(statuses, waitCmd(t, cmd))
+		}
+	}
+	if err := layers.finish(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return stdout.String(), statuses
+}
+
+func TestProvisionLayers_checkpointFound(t *testing.T) {
+	ran := filepath.Join(t.TempDir(), "ran")
+	setup := []string{fmt.Sprintf("echo setup >> %s; echo setup", ran)}
+	install := []string{fmt.Sprintf("echo install >> %s; echo install", ran)}
+	driver := newCheckpointDriver()
+
+	layers, ui, comm := testCheckpointLayers(t, driver, "setup", "install")
+	testProvision(t, layers, ui, comm, setup, install)
+	if len(layers.tags) != 1 || driver.tags[layers.tags[0]] != "sha256:layer1" {
+		t.Fatalf("the first layer should have been checkpointed: %#v", driver.tags)
+	}
+
+	// The first provisioner is skipped, and the second one runs in a
+	// container started from its checkpoint.
+	driver.CommitCalled = false
+	layers, ui, comm = testCheckpointLayers(t, driver, "setup", "install")
+	got, statuses := testProvision(t, layers, ui, comm, setup, install)
+	if got != "install\n" || !reflect.DeepEqual(statuses, []int{0, 0}) {
+		t.Errorf("unexpected output %q and statuses %v", got, statuses)
+	}
+	if contents, _ := os.ReadFile(ran); string(contents) != "setup\ninstall\ninstall\n" {
+		t.Errorf("only the second provisioner should have run again, ran:\n%s", contents)
+	}
+	if driver.CommitCalled {
+		t.Error("should not have committed")
+	}
+	if driver.StartConfig.Image != "sha256:layer1" {
+		t.Errorf("should have started from the checkpoint, started from %q", driver.StartConfig.Image)
+	}
+	expected := []imageLayer{
+		{Name: "Provisioning with shell...", ImageId: "sha256:layer1"},
+		{Name: "Provisioning with shell..."},
+	}
+	if got := layers.imageLayers(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected layers %#v, got %#v", expected, got)
+	}
+}
+
+func TestProvisionLayers_checkpointAllFound(t *testing.T) {
+	driver := newCheckpointDriver()
+	layers, ui, comm := testCheckpointLayers(t, driver, "setup", "install", "cleanup")
+	testProvision(t, layers, ui, comm, []string{"true"}, []string{"true"}, []string{"true"})
+
+	// Without the last provisioner, the container is started from the
+	// checkpoint of the second one once they are all skipped.
+	layers, ui, comm = testCheckpointLayers(t, driver, "setup", "install", "cleanup")
+	testProvision(t, layers, ui, comm, []string{"false"}, []string{"false"})
+	if driver.StartConfig.Image != "sha256:layer2" {
+		t.Errorf("should have started from the checkpoint, started from %q", driver.StartConfig.Image)
+	}
+	if got := layers.imageLayers(); len(got) != 2 || got[1].ImageId != "sha256:layer2" {
+		t.Errorf("bad layers: %#v", got)
+	}
+}
+
+func TestProvisionLayers_checkpointChanged(t *testing.T) {
+	tests := []struct {
+		name         string
+		keys         []string
+		provisioners [][]string
+	}{
+		{"config", []string{"setup v2", "install"}, [][]string{{"echo setup"}, {"echo install"}}},
+		{"position", []string{"hello", "setup", "install"}, [][]string{{"echo hello"}, {"echo setup"}, {"echo install"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := newCheckpointDriver()
+			layers, ui, comm := testCheckpointLayers(t, driver, "setup", "install")
+			testProvision(t, layers, ui, comm, []string{"echo setup"}, []string{"echo install"})
+
+			// The changed provisioner and the following ones run again.
+			layers, ui, comm = testCheckpointLayers(t, driver, tt.keys...)
+			got, _ := testProvision(t, layers, ui, comm, tt.provisioners...)
+			var expected string
+			for _, commands := range tt.provisioners {
+				expected += strings.TrimPrefix(commands[0], "echo ") + "\n"
+			}
+			if got != expected {
+				t.Errorf("expected output %q, got %q", expected, got)
+			}
+			if layers.resuming {
+				t.Error("should have stopped resuming")
+			}
+		})
+	}
+}
+
+func TestProvisionLayers_checkpointKeys(t *testing.T) {
+	driver := newCheckpointDriver()
+	layers, ui, comm := testCheckpointLayers(t, driver, "setup")
+	testProvision(t, layers, ui, comm, []string{"true"}, []string{"true"}, []string{"true"})
+
+	// The provisioners past the end of checkpoint_keys are committed, but
+	// not checkpointed.
+	if len(driver.CommitMessages) != 2 || len(layers.tags) != 1 {
+		t.Fatalf("only the first layer should have been checkpointed: %#v", layers.tags)
+	}
+
+	layers, ui, comm = testCheckpointLayers(t, driver, "setup")
+	got, _ := testProvision(t, layers, ui, comm, []string{"echo setup"}, []string{"echo install"})
+	if got != "install\n" {
+		t.Errorf("unexpected output %q", got)
+	}
+}
+
+func TestProvisionLayers_checkpointDownload(t *testing.T) {
+	driver := newCheckpointDriver()
+	layers, ui, comm := testCheckpointLayers(t, driver, "setup", "install")
+	testProvision(t, layers, ui, comm, []string{"true"}, []string{"true"})
+
+	// A skipped provisioner downloads from its checkpoint.
+	driver.StartCalled = false
+	layers, ui, comm = testCheckpointLayers(t, driver, "setup", "install")
+	ui.Say("Provisioning with shell...")
+	if err := comm.Start(context.Background(), &packersdk.RemoteCmd{Command: "false"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if driver.StartCalled {
+		t.Fatal("should not have started a container for a skipped command")
+	}
+	if _, err := layers.operation(true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !driver.StartCalled || driver.StartConfig.Image != "sha256:layer1" {
+		t.Errorf("should have started from the checkpoint, started from %q", driver.StartConfig.Image)
+	}
+	if layers.pending != "" || !layers.resuming {
+		t.Errorf("should still be resuming from a started checkpoint")
+	}
+}
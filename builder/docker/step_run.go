@@ -6,6 +6,9 @@ package docker
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -25,26 +28,21 @@ func (s *StepRun) Run(ctx context.Context, state multistep.StateBag) multistep.S
 		return multistep.ActionHalt
 	}
 
-	runConfig := ContainerConfig{
-		Image:      config.Image,
-		RunCommand: config.RunCommand,
-		Device:     config.Device,
-		TmpFs:      config.TmpFs,
-		Volumes:    make(map[string]string),
-		CapAdd:     config.CapAdd,
-		CapDrop:    config.CapDrop,
-		Privileged: config.Privileged,
-		Runtime:    config.Runtime,
-		Platform:   config.Platform,
-	}
+	tempDir := state.Get("temp_dir").(string)
+	runConfig := newContainerConfig(config, config.Image, tempDir)
 
-	for host, container := range config.Volumes {
-		runConfig.Volumes[host] = container
+	if config.NoShell && config.PauseBinary != "" {
+		// The pause binary is made available to the container through the
+		// shared directory, so it can be used to keep it alive.
+		err := copyPauseBinary(config.PauseBinary, tempDir)
+		if err != nil {
+			err := fmt.Errorf("Error injecting pause binary: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
 	}
 
-	tempDir := state.Get("temp_dir").(string)
-	runConfig.Volumes[tempDir] = config.ContainerDir
-
 	driver := state.Get("driver").(Driver)
 	ui.Say("Starting docker container...")
 	containerId, err := driver.StartContainer(&runConfig)
@@ -70,6 +68,12 @@ func (s *StepRun) Cleanup(state multistep.StateBag) {
 		return
 	}
 
+	// The container may have been replaced by one started from a newer
+	// image, see stepProvisionLayers.
+	if containerId, ok := state.GetOk("container_id"); ok {
+		s.containerId = containerId.(string)
+	}
+
 	driver := state.Get("driver").(Driver)
 	ui := state.Get("ui").(packersdk.Ui)
 
@@ -84,3 +88,49 @@ func (s *StepRun) Cleanup(state multistep.StateBag) {
 	// Reset the container ID so that we're idempotent
 	s.containerId = ""
 }
+
+// newContainerConfig returns the configuration of the build container, started
+// from image, with tempDir shared with it.
+func newContainerConfig(config *Config, image string, tempDir string) ContainerConfig {
+	runConfig := ContainerConfig{
+		Image:      image,
+		RunCommand: config.RunCommand,
+		Device:     config.Device,
+		TmpFs:      config.TmpFs,
+		Volumes:    make(map[string]string),
+		CapAdd:     config.CapAdd,
+		CapDrop:    config.CapDrop,
+		Privileged: config.Privileged,
+		Runtime:    config.Runtime,
+		Platform:   config.Platform,
+	}
+
+	for host, container := range config.Volumes {
+		runConfig.Volumes[host] = container
+	}
+	runConfig.Volumes[tempDir] = config.ContainerDir
+
+	return runConfig
+}
+
+// copyPauseBinary copies the binary at src to the dir directory, keeping
+// it executable.
+func copyPauseBinary(src string, dir string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filepath.Join(dir, filepath.Base(src)), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
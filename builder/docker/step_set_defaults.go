@@ -5,51 +5,60 @@ package docker
 
 import (
 	"context"
-	"strings"
+	"fmt"
 
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
+// StepSetDefaults restores the configuration of the source image that the
+// container overrides, like the entrypoint set by `run_command`, so that the
+// committed image keeps it.
+//
+// The changes restoring it are put before the ones of the user, which take
+// precedence.
 type StepSetDefaults struct{}
 
 func (s *StepSetDefaults) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
-	driver := state.Get("driver").(*DockerDriver)
-	config := state.Get("config").(*Config)
-
-	// Fetch default CMD and ENTRYPOINT
-	//
-	// NOTE: if they're empty, the returned value is expected to be [""]
-	// This is because in order to override what we had set when running
-	// the build container, they need to be an array with nothing in it.
-	// The commit command treats explicit `null` as a NOOP, same with `[]`.
-	// If a string is passed as the change argument, it will be appended to
-	// what already exists for the cmd/entrypoint, which doesn't match the
-	// need here, as we need to forcefully set what was originally
-	// specified.
-	//
-	// So while not necessarily clean, the best way to force a similar
-	// behaviour as the original image, we default on an array with an
-	// empty string as argument, which is effectively the same as `null`.
-	defaultCmd, _ := driver.Cmd(config.Image)
-	defaultEntrypoint, _ := driver.Entrypoint(config.Image)
-
-	// Set defaults if not provided by the user
-	hasCmd, hasEntrypoint := false, false
-	for _, change := range config.Changes {
-		if strings.HasPrefix(change, "CMD") {
-			hasCmd = true
-		} else if strings.HasPrefix(change, "ENTRYPOINT") {
-			hasEntrypoint = true
-		}
+	ui := state.Get("ui").(packersdk.Ui)
+	driver := state.Get("driver").(Driver)
+	config, ok := state.Get("config").(*Config)
+	if !ok {
+		err := fmt.Errorf("error encountered obtaining docker config")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	containerId := state.Get("container_id").(string)
+
+	imageConfig, err := driver.InspectConfig(config.Image)
+	if err != nil {
+		err := fmt.Errorf("Error reading configuration of image %s: %s", config.Image, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
 	}
 
-	if !hasCmd {
-		config.Changes = append(config.Changes, "CMD "+defaultCmd)
+	containerConfig, err := driver.InspectConfig(containerId)
+	if err != nil {
+		err := fmt.Errorf("Error reading configuration of container %s: %s", containerId, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	changes, warnings := restoreChanges(imageConfig, containerConfig)
+	if len(changes) > 0 {
+		ui.Say("Restoring the image configuration overridden by the container:")
+		for _, change := range changes {
+			ui.Message(change)
+		}
 	}
-	if !hasEntrypoint {
-		config.Changes = append(config.Changes, "ENTRYPOINT "+defaultEntrypoint)
+	for _, warning := range warnings {
+		ui.Message(fmt.Sprintf("Warning: %s", warning))
 	}
 
+	config.Changes = append(changes, config.Changes...)
 	return multistep.ActionContinue
 }
 
@@ -0,0 +1,65 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestStepSetDefaults_impl(t *testing.T) {
+	var _ multistep.Step = new(StepSetDefaults)
+}
+
+func TestStepSetDefaults(t *testing.T) {
+	state := testState(t)
+	state.Put("container_id", "container")
+	config := state.Get("config").(*Config)
+	config.Changes = []string{"CMD [\"nginx\"]"}
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.InspectConfigResults = map[string]*ImageConfig{
+		config.Image: {
+			Entrypoint: []string{"/docker-entrypoint.sh"},
+			User:       "nginx",
+		},
+		"container": {
+			Entrypoint: []string{"/bin/sh"},
+			Cmd:        []string{"-c", "cat"},
+			User:       "root",
+		},
+	}
+
+	step := new(StepSetDefaults)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	// The changes of the user come last, so that they win
+	expected := []string{
+		`ENTRYPOINT ["/docker-entrypoint.sh"]`,
+		`CMD [""]`,
+		"USER nginx",
+		`CMD ["nginx"]`,
+	}
+	if !reflect.DeepEqual(config.Changes, expected) {
+		t.Fatalf("expected changes %#v, got %#v", expected, config.Changes)
+	}
+}
+
+func TestStepSetDefaults_inspectError(t *testing.T) {
+	state := testState(t)
+	state.Put("container_id", "container")
+
+	step := new(StepSetDefaults)
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have an error")
+	}
+}
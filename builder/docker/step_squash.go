@@ -0,0 +1,65 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+// stepSquash squashes the layers of the committed image into a single one,
+// on top of the source image or of nothing with `squash_all`.
+type stepSquash struct {
+	GeneratedData *packerbuilderdata.GeneratedData
+}
+
+func (s *stepSquash) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+	config, ok := state.Get("config").(*Config)
+	if !ok {
+		err := fmt.Errorf("error encountered obtaining docker config")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	driver := state.Get("driver").(Driver)
+	imageId := state.Get("image_id").(string)
+
+	base := config.Image
+	if config.SquashAll {
+		base = ""
+	}
+
+	ui.Say("Squashing the image")
+	tempDir, _ := state.Get("temp_dir").(string)
+	squashedId, err := SquashImage(ui, driver, imageId, base, tempDir)
+	if err != nil {
+		err := fmt.Errorf("Error squashing image: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	if squashedId == imageId {
+		return multistep.ActionContinue
+	}
+
+	// The committed image was only an intermediate one.
+	if err := driver.DeleteImage(imageId); err != nil {
+		log.Printf("Error deleting image %s: %s", imageId, err)
+	}
+
+	state.Put("image_id", squashedId)
+	if s256, err := driver.Sha256(squashedId); err == nil {
+		s.GeneratedData.Put("ImageSha256", s256)
+	}
+	return multistep.ActionContinue
+}
+
+func (s *stepSquash) Cleanup(state multistep.StateBag) {}
@@ -0,0 +1,98 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+func TestStepSquash_impl(t *testing.T) {
+	var _ multistep.Step = new(stepSquash)
+}
+
+func TestStepSquash(t *testing.T) {
+	state := testState(t)
+	state.Put("image_id", "committed")
+	config := state.Get("config").(*Config)
+	config.Image = "base"
+
+	saved, diffIDs := testSquashImage(t)
+	driver := state.Get("driver").(*MockDriver)
+	driver.SaveImageReader = bytes.NewReader(saved)
+	driver.ImageLayersResult = diffIDs[:1]
+	driver.LoadImageId = "sha256:squashed"
+	driver.Sha256Result = "sha256:squashed"
+
+	step := &stepSquash{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	defer step.Cleanup(state)
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	if driver.ImageLayersId != "base" {
+		t.Fatalf("should have squashed onto the source image: %q", driver.ImageLayersId)
+	}
+	if id := state.Get("image_id").(string); id != "sha256:squashed" {
+		t.Fatalf("bad image ID: %s", id)
+	}
+	if driver.DeleteImageId != "committed" {
+		t.Fatalf("should have deleted the committed image: %q", driver.DeleteImageId)
+	}
+	genData := state.Get("generated_data").(map[string]interface{})
+	if genData["ImageSha256"] != "sha256:squashed" {
+		t.Fatalf("bad ImageSha256: %#v", genData["ImageSha256"])
+	}
+}
+
+func TestStepSquash_all(t *testing.T) {
+	state := testState(t)
+	state.Put("image_id", "committed")
+	config := state.Get("config").(*Config)
+	config.SquashAll = true
+
+	saved, _ := testSquashImage(t)
+	driver := state.Get("driver").(*MockDriver)
+	driver.SaveImageReader = bytes.NewReader(saved)
+	driver.LoadImageId = "sha256:squashed"
+
+	step := &stepSquash{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if driver.ImageLayersCalled {
+		t.Fatal("should not have read the layers of the source image")
+	}
+}
+
+func TestStepSquash_error(t *testing.T) {
+	state := testState(t)
+	state.Put("image_id", "committed")
+
+	driver := state.Get("driver").(*MockDriver)
+	driver.SaveImageError = errors.New("foo")
+
+	step := &stepSquash{
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("bad action: %#v", action)
+	}
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	if id := state.Get("image_id").(string); id != "committed" {
+		t.Fatalf("bad image ID: %s", id)
+	}
+}
@@ -0,0 +1,29 @@
+# Copyright IBM Corp. 2013, 2025
+# SPDX-License-Identifier: MPL-2.0
+
+source "docker" "autogenerated_1" {
+  discard             = true
+  image               = "ubuntu"
+  upload_owner        = "42:43"
+  upload_mode         = "0640"
+  upload_owner_method = "archive"
+}
+
+build {
+  sources = ["source.docker.autogenerated_1"]
+
+  provisioner "file" {
+    destination = "/tmp/strawberry-cake"
+    source      = "test-fixtures/onecakes/strawberry"
+  }
+
+  provisioner "file" {
+    destination = "/tmp/"
+    source      = "test-fixtures/manycakes"
+  }
+
+  provisioner "shell" {
+    inline = ["[ $(stat -c %u:%g:%a /tmp/strawberry-cake) = 42:43:640 ] || (echo 'Invalid owner or mode of /tmp/strawberry-cake' && exit 1)", "[ $(stat -c %u /tmp) -eq 0 ] || (echo 'Destination directory should not be re-owned' && exit 1)", "find /tmp/manycakes -type f | xargs -n1 -IFILE /bin/sh -c '[ $(stat -c %u:%g FILE) = 42:43 ] || (echo \"Invalid owner of FILE\" && exit 1)'"]
+  }
+
+}
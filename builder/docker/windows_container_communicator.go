@@ -157,7 +157,7 @@ func (c *WindowsContainerCommunicator) UploadDir(dst string, src string, exclude
 // Download pulls a file out of a container using `docker cp`. We have a source
 // path and want to write to an io.Writer
 func (c *WindowsContainerCommunicator) Download(src string, dst io.Writer) error {
-	log.Printf("Downloading file from container: %s:%s", c.ContainerID, src)
+	log.Printf("Downloading file from container: %s:%s", c.containerID(), src)
 	// Copy file onto temp file on mounted volume inside container
 	var stdout, stderr bytes.Buffer
 	cmd := &packersdk.RemoteCmd{
@@ -193,3 +193,9 @@ func (c *WindowsContainerCommunicator) Download(src string, dst io.Writer) error
 
 	return nil
 }
+
+// DownloadDir is not supported, since `docker cp` cannot be used with
+// Windows containers.
+func (c *WindowsContainerCommunicator) DownloadDir(src string, dst string, exclude []string) error {
+	return fmt.Errorf("DownloadDir is not implemented for windows containers")
+}
@@ -11,6 +11,7 @@ import (
 	dockerimport "github.com/hashicorp/packer-plugin-docker/post-processor/docker-import"
 	dockerpush "github.com/hashicorp/packer-plugin-docker/post-processor/docker-push"
 	dockersave "github.com/hashicorp/packer-plugin-docker/post-processor/docker-save"
+	dockersquash "github.com/hashicorp/packer-plugin-docker/post-processor/docker-squash"
 	dockertag "github.com/hashicorp/packer-plugin-docker/post-processor/docker-tag"
 	"github.com/hashicorp/packer-plugin-docker/version"
 
@@ -23,6 +24,7 @@ func main() {
 	pps.RegisterPostProcessor("import", new(dockerimport.PostProcessor))
 	pps.RegisterPostProcessor("push", new(dockerpush.PostProcessor))
 	pps.RegisterPostProcessor("save", new(dockersave.PostProcessor))
+	pps.RegisterPostProcessor("squash", new(dockersquash.PostProcessor))
 	pps.RegisterPostProcessor("tag", new(dockertag.PostProcessor))
 	pps.SetVersion(version.PluginVersion)
 	err := pps.Run()
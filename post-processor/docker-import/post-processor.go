@@ -28,6 +28,9 @@ type Config struct {
 	Changes    []string `mapstructure:"changes"`
 	Platform   string   `mapstructure:"platform"`
 
+	ImageConfig docker.ImageConfigChanges `mapstructure:"image_config"`
+	Labels      map[string]string         `mapstructure:"labels"`
+
 	ctx interpolate.Context
 }
 
@@ -54,6 +57,11 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		p.config.Executable = "docker"
 	}
 
+	p.config.Changes = append(append(p.config.ImageConfig.Changes(), docker.LabelChanges(p.config.Labels)...), p.config.Changes...)
+	if es := docker.ValidateChanges(p.config.Changes); len(es) > 0 {
+		return &packersdk.MultiError{Errors: es}
+	}
+
 	return nil
 
 }
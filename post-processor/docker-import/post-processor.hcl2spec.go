@@ -4,25 +4,28 @@ package dockerimport
 
 import (
 	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-docker/builder/docker"
 	"github.com/zclconf/go-cty/cty"
 )
 
 // FlatConfig is an auto-generated flat version of Config.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatConfig struct {
-	PackerBuildName     *string           `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
-	PackerBuilderType   *string           `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
-	PackerCoreVersion   *string           `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
-	PackerDebug         *bool             `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
-	PackerForce         *bool             `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
-	PackerOnError       *string           `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
-	PackerUserVars      map[string]string `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
-	PackerSensitiveVars []string          `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
-	Executable          *string           `mapstructure:"docker_path" cty:"docker_path" hcl:"docker_path"`
-	Repository          *string           `mapstructure:"repository" cty:"repository" hcl:"repository"`
-	Tag                 *string           `mapstructure:"tag" cty:"tag" hcl:"tag"`
-	Changes             []string          `mapstructure:"changes" cty:"changes" hcl:"changes"`
-	Platform            *string           `mapstructure:"platform" cty:"platform" hcl:"platform"`
+	PackerBuildName     *string                        `mapstructure:"packer_build_name" cty:"packer_build_name" hcl:"packer_build_name"`
+	PackerBuilderType   *string                        `mapstructure:"packer_builder_type" cty:"packer_builder_type" hcl:"packer_builder_type"`
+	PackerCoreVersion   *string                        `mapstructure:"packer_core_version" cty:"packer_core_version" hcl:"packer_core_version"`
+	PackerDebug         *bool                          `mapstructure:"packer_debug" cty:"packer_debug" hcl:"packer_debug"`
+	PackerForce         *bool                          `mapstructure:"packer_force" cty:"packer_force" hcl:"packer_force"`
+	PackerOnError       *string                        `mapstructure:"packer_on_error" cty:"packer_on_error" hcl:"packer_on_error"`
+	PackerUserVars      map[string]string              `mapstructure:"packer_user_variables" cty:"packer_user_variables" hcl:"packer_user_variables"`
+	PackerSensitiveVars []string                       `mapstructure:"packer_sensitive_variables" cty:"packer_sensitive_variables" hcl:"packer_sensitive_variables"`
+	Executable          *string                        `mapstructure:"docker_path" cty:"docker_path" hcl:"docker_path"`
+	Repository          *string                        `mapstructure:"repository" cty:"repository" hcl:"repository"`
+	Tag                 *string                        `mapstructure:"tag" cty:"tag" hcl:"tag"`
+	Changes             []string                       `mapstructure:"changes" cty:"changes" hcl:"changes"`
+	Platform            *string                        `mapstructure:"platform" cty:"platform" hcl:"platform"`
+	ImageConfig         *docker.FlatImageConfigChanges `mapstructure:"image_config" cty:"image_config" hcl:"image_config"`
+	Labels              map[string]string              `mapstructure:"labels" cty:"labels" hcl:"labels"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -50,6 +53,8 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"tag":                        &hcldec.AttrSpec{Name: "tag", Type: cty.String, Required: false},
 		"changes":                    &hcldec.AttrSpec{Name: "changes", Type: cty.List(cty.String), Required: false},
 		"platform":                   &hcldec.AttrSpec{Name: "platform", Type: cty.String, Required: false},
+		"image_config":               &hcldec.BlockSpec{TypeName: "image_config", Nested: hcldec.ObjectSpec((*docker.FlatImageConfigChanges)(nil).HCL2Spec())},
+		"labels":                     &hcldec.AttrSpec{Name: "labels", Type: cty.Map(cty.String), Required: false},
 	}
 	return s
 }
@@ -4,6 +4,7 @@
 package dockerimport
 
 import (
+	"reflect"
 	"testing"
 
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -12,3 +13,31 @@ import (
 func TestPostProcessor_ImplementsPostProcessor(t *testing.T) {
 	var _ packersdk.PostProcessor = new(PostProcessor)
 }
+
+func TestPostProcessorConfigure_changes(t *testing.T) {
+	var p PostProcessor
+	err := p.Configure(map[string]interface{}{
+		"repository": "foo",
+		"changes":    []string{"USER nginx"},
+		"labels":     map[string]string{"version": "1.0"},
+		"image_config": map[string]interface{}{
+			"cmd": []string{"nginx"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("bad: %s", err)
+	}
+	expected := []string{`CMD ["nginx"]`, `LABEL "version"="1.0"`, "USER nginx"}
+	if !reflect.DeepEqual(p.config.Changes, expected) {
+		t.Fatalf("bad: %#v", p.config.Changes)
+	}
+
+	p = PostProcessor{}
+	err = p.Configure(map[string]interface{}{
+		"repository": "foo",
+		"changes":    []string{"RUN apt-get update"},
+	})
+	if err == nil {
+		t.Fatal("should error")
+	}
+}
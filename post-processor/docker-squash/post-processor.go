@@ -0,0 +1,136 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+
+package dockersquash
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/packer-plugin-docker/builder/docker"
+	dockerimport "github.com/hashicorp/packer-plugin-docker/post-processor/docker-import"
+	dockertag "github.com/hashicorp/packer-plugin-docker/post-processor/docker-tag"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+const BuilderId = "packer.post-processor.docker-squash"
+
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+
+	Executable string `mapstructure:"docker_path"`
+	BaseImage  string `mapstructure:"base_image"`
+	SquashAll  bool   `mapstructure:"squash_all"`
+
+	ctx interpolate.Context
+}
+
+type PostProcessor struct {
+	Driver docker.Driver
+
+	config Config
+}
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	err := config.Decode(&p.config, &config.DecodeOpts{
+		PluginType:         BuilderId,
+		Interpolate:        true,
+		InterpolateContext: &p.config.ctx,
+		InterpolateFilter: &interpolate.RenderFilter{
+			Exclude: []string{},
+		},
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	if p.config.Executable == "" {
+		p.config.Executable = "docker"
+	}
+
+	if p.config.SquashAll && p.config.BaseImage != "" {
+		return fmt.Errorf("base_image cannot be specified with squash_all")
+	}
+
+	return nil
+
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	if artifact.BuilderId() != dockerimport.BuilderId &&
+		artifact.BuilderId() != dockertag.BuilderId {
+		err := fmt.Errorf(
+			"Unknown artifact type: %s\nCan only squash Docker builder artifacts.",
+			artifact.BuilderId())
+		return nil, false, false, err
+	}
+
+	driver := p.Driver
+	if driver == nil {
+		// If no driver is set, then we use the real driver
+		driver = &docker.DockerDriver{
+			Executable: p.config.Executable,
+			Ctx:        &p.config.ctx,
+			Ui:         ui,
+		}
+	}
+
+	// The RPC turns our original map[string]interface{} into a
+	// map[interface]interface so we need to turn it back
+	genData := map[string]interface{}{}
+	switch data := artifact.State("generated_data").(type) {
+	case map[interface{}]interface{}:
+		for k, v := range data {
+			genData[k.(string)] = v
+		}
+	case map[string]interface{}:
+		for k, v := range data {
+			genData[k] = v
+		}
+	}
+
+	base := p.config.BaseImage
+	if base == "" && !p.config.SquashAll {
+		// The builder records the ID of the image it started from.
+		source, _ := genData["SourceImageSha256"].(string)
+		if source == "" || strings.HasPrefix(source, "ERR_") {
+			err := fmt.Errorf("The image %s was not built from a known source image, "+
+				"set base_image or squash_all", artifact.Id())
+			return nil, false, false, err
+		}
+		base = source
+	}
+
+	ui.Message("Squashing image: " + artifact.Id())
+	// The image is saved to PACKER_TMP_DIR, or TMPDIR, while squashed.
+	id, err := docker.SquashImage(ui, driver, artifact.Id(), base, os.Getenv("PACKER_TMP_DIR"))
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error squashing image: %s", err)
+	}
+	if id == artifact.Id() {
+		return artifact, true, false, nil
+	}
+
+	if s256, err := driver.Sha256(id); err == nil {
+		genData["ImageSha256"] = s256
+	}
+
+	artifact = &docker.ImportArtifact{
+		BuilderIdValue: dockerimport.BuilderId,
+		Driver:         driver,
+		IdValue:        id,
+		StateData:      map[string]interface{}{"generated_data": genData},
+	}
+
+	return artifact, false, false, nil
+}
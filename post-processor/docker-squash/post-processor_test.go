@@ -0,0 +1,153 @@
+// Copyright IBM Corp. 2013, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package dockersquash
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/packer-plugin-docker/builder/docker"
+	dockerimport "github.com/hashicorp/packer-plugin-docker/post-processor/docker-import"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func testUi() *packersdk.BasicUi {
+	return &packersdk.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+}
+
+func TestPostProcessor_ImplementsPostProcessor(t *testing.T) {
+	var _ packersdk.PostProcessor = new(PostProcessor)
+}
+
+func TestPostProcessorConfigure(t *testing.T) {
+	var p PostProcessor
+	err := p.Configure(map[string]interface{}{
+		"base_image": "ubuntu",
+		"squash_all": true,
+	})
+	if err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestPostProcessor_PostProcess_unknownArtifact(t *testing.T) {
+	p := &PostProcessor{Driver: &docker.MockDriver{}}
+	if err := p.Configure(map[string]interface{}{"squash_all": true}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	artifact := &packersdk.MockArtifact{
+		BuilderIdValue: "packer.post-processor.docker-save",
+		IdValue:        "1234567890abcdef",
+	}
+	if _, _, _, err := p.PostProcess(context.Background(), testUi(), artifact); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestPostProcessor_PostProcess_noBase(t *testing.T) {
+	driver := &docker.MockDriver{}
+	p := &PostProcessor{Driver: driver}
+	if err := p.Configure(map[string]interface{}{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	artifact := &packersdk.MockArtifact{
+		BuilderIdValue: dockerimport.BuilderId,
+		IdValue:        "1234567890abcdef",
+		StateValues: map[string]interface{}{
+			"generated_data": map[string]interface{}{
+				"SourceImageSha256": "ERR_SOURCE_IMAGE_SHA256_NOT_FOUND",
+			},
+		},
+	}
+	if _, _, _, err := p.PostProcess(context.Background(), testUi(), artifact); err == nil {
+		t.Fatal("should error")
+	}
+	if driver.SaveImageCalled {
+		t.Fatal("should not have saved the image")
+	}
+}
+
+// testSavedImage returns a `docker save` archive of an image with two
+// layers.
+func testSavedImage(t *testing.T) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var diffIDs []string
+	for i, name := range []string{"bin/sh", "app"} {
+		var layer bytes.Buffer
+		lw := tar.NewWriter(&layer)
+		lw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeReg})
+		lw.Close()
+		write(fmt.Sprintf("layer%d.tar", i), layer.Bytes())
+		diffIDs = append(diffIDs, fmt.Sprintf("sha256:%x", sha256.Sum256(layer.Bytes())))
+	}
+	config, _ := json.Marshal(map[string]interface{}{
+		"rootfs": map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	write("config.json", config)
+	write("manifest.json", []byte(`[{"Config":"config.json","Layers":["layer0.tar","layer1.tar"]}]`))
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPostProcessor_PostProcess(t *testing.T) {
+	driver := &docker.MockDriver{
+		SaveImageReader: bytes.NewReader(testSavedImage(t)),
+		LoadImageId:     "sha256:squashed",
+		Sha256Result:    "sha256:squashed",
+	}
+	p := &PostProcessor{Driver: driver}
+	if err := p.Configure(map[string]interface{}{}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	artifact := &packersdk.MockArtifact{
+		BuilderIdValue: dockerimport.BuilderId,
+		IdValue:        "1234567890abcdef",
+		StateValues: map[string]interface{}{
+			"generated_data": map[interface{}]interface{}{
+				"SourceImageSha256": "sha256:source",
+			},
+		},
+	}
+	result, keep, forceOverride, err := p.PostProcess(context.Background(), testUi(), artifact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if keep || forceOverride {
+		t.Fatal("should let the user keep the input artifact")
+	}
+
+	if driver.ImageLayersId != "sha256:source" {
+		t.Fatalf("should have squashed onto the source image: %q", driver.ImageLayersId)
+	}
+	if result.Id() != "sha256:squashed" || result.BuilderId() != dockerimport.BuilderId {
+		t.Fatalf("bad artifact: %s %s", result.Id(), result.BuilderId())
+	}
+	genData := result.State("generated_data").(map[string]interface{})
+	if genData["ImageSha256"] != "sha256:squashed" || genData["SourceImageSha256"] != "sha256:source" {
+		t.Fatalf("bad generated data: %#v", genData)
+	}
+}